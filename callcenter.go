@@ -0,0 +1,484 @@
+package esl
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Current file contains the support for mod_callcenter commands and events,
+// built on top of the API command (callcenter_config).
+
+// CallcenterInfoEvent is the subclass of CUSTOM events that are fired by
+// mod_callcenter
+const CallcenterInfoEvent = "callcenter::info"
+
+// CallcenterAgentType is the type of an agent
+type CallcenterAgentType string
+
+// CallcenterAgentStatus is the status of an agent
+type CallcenterAgentStatus string
+
+// CallcenterAgentState is the state of an agent
+type CallcenterAgentState string
+
+// CallcenterAction is the CC-Action header of callcenter::info events
+type CallcenterAction string
+
+// Agent types
+const (
+	CCAgentTypeCallback    CallcenterAgentType = "callback"
+	CCAgentTypeUUIDStandby CallcenterAgentType = "uuid-standby"
+)
+
+// Agent statuses
+const (
+	CCAgentStatusLoggedOut         CallcenterAgentStatus = "Logged Out"
+	CCAgentStatusAvailable         CallcenterAgentStatus = "Available"
+	CCAgentStatusAvailableOnDemand CallcenterAgentStatus = "Available (On Demand)"
+	CCAgentStatusOnBreak           CallcenterAgentStatus = "On Break"
+)
+
+// Agent states
+const (
+	CCAgentStateIdle        CallcenterAgentState = "Idle"
+	CCAgentStateWaiting     CallcenterAgentState = "Waiting"
+	CCAgentStateReceiving   CallcenterAgentState = "Receiving"
+	CCAgentStateInQueueCall CallcenterAgentState = "In a queue call"
+)
+
+// Known callcenter actions
+const (
+	CCActionAgentStatusChange CallcenterAction = "agent-status-change"
+	CCActionAgentStateChange  CallcenterAction = "agent-state-change"
+	CCActionAgentOffering     CallcenterAction = "agent-offering"
+	CCActionBridgeAgentStart  CallcenterAction = "bridge-agent-start"
+	CCActionBridgeAgentEnd    CallcenterAction = "bridge-agent-end"
+	CCActionBridgeAgentFail   CallcenterAction = "bridge-agent-fail"
+	CCActionMemberQueueStart  CallcenterAction = "member-queue-start"
+	CCActionMemberQueueEnd    CallcenterAction = "member-queue-end"
+	CCActionMembersCount      CallcenterAction = "members-count"
+)
+
+// CallcenterAgent holds a line of "callcenter_config agent list"
+type CallcenterAgent struct {
+	Name              string
+	InstanceID        string
+	UUID              string
+	Type              CallcenterAgentType
+	Contact           string
+	Status            CallcenterAgentStatus
+	State             CallcenterAgentState
+	MaxNoAnswer       int64
+	WrapUpTime        int64
+	RejectDelayTime   int64
+	BusyDelayTime     int64
+	NoAnswerDelayTime int64
+	LastBridgeStart   time.Time
+	LastBridgeEnd     time.Time
+	LastOfferedCall   time.Time
+	LastStatusChange  time.Time
+	NoAnswerCount     int64
+	CallsAnswered     int64
+	TalkTime          int64
+	ReadyTime         int64
+
+	// Fields holds all the fields as returned by Freeswitch
+	Fields map[string]string
+}
+
+// CallcenterTier holds a line of "callcenter_config tier list"
+type CallcenterTier struct {
+	Queue    string
+	Agent    string
+	State    string
+	Level    int64
+	Position int64
+
+	// Fields holds all the fields as returned by Freeswitch
+	Fields map[string]string
+}
+
+// CallcenterMember holds a line of "callcenter_config queue list members"
+type CallcenterMember struct {
+	Queue         string
+	InstanceID    string
+	UUID          string
+	SessionUUID   string
+	CIDNumber     string
+	CIDName       string
+	SystemEpoch   time.Time
+	JoinedEpoch   time.Time
+	RejoinedEpoch time.Time
+	BridgeEpoch   time.Time
+	AbandonedAt   time.Time
+	BaseScore     int64
+	SkillScore    int64
+	ServingAgent  string
+	ServingSystem string
+	State         string
+	Score         int64
+
+	// Fields holds all the fields as returned by Freeswitch
+	Fields map[string]string
+}
+
+// CallcenterEvent is a decoded callcenter::info event
+type CallcenterEvent struct {
+	Action      CallcenterAction
+	Queue       string
+	Agent       string
+	AgentStatus CallcenterAgentStatus
+	AgentState  CallcenterAgentState
+
+	// Member information, available on member and bridge actions
+	MemberUUID        string
+	MemberSessionUUID string
+	MemberCIDName     string
+	MemberCIDNumber   string
+
+	// Cause and CancelReason are available on member-queue-end
+	Cause        string
+	CancelReason string
+
+	// Count is available on members-count
+	Count int64
+
+	// Headers holds all the headers of the event
+	Headers Headers
+}
+
+// IsAgentState returns true if the event is a change of status or state of
+// an agent
+func (e CallcenterEvent) IsAgentState() bool {
+	return e.Action == CCActionAgentStatusChange || e.Action == CCActionAgentStateChange
+}
+
+// IsMemberQueue returns true if a member entered or left a queue
+func (e CallcenterEvent) IsMemberQueue() bool {
+	return e.Action == CCActionMemberQueueStart || e.Action == CCActionMemberQueueEnd
+}
+
+// Callcenter execute mod_callcenter commands over a socket
+type Callcenter struct {
+	socket *Socket
+}
+
+// NewCallcenter creates a new Callcenter handle for the given socket
+func NewCallcenter(socket *Socket) *Callcenter {
+	return &Callcenter{socket: socket}
+}
+
+// AddAgent adds a new agent
+func (c *Callcenter) AddAgent(name string, agentType CallcenterAgentType) error {
+	return c.config("agent", "add", name, string(agentType))
+}
+
+// DeleteAgent removes an agent
+func (c *Callcenter) DeleteAgent(name string) error {
+	return c.config("agent", "del", name)
+}
+
+// SetAgentStatus sets the status of an agent
+func (c *Callcenter) SetAgentStatus(name string, status CallcenterAgentStatus) error {
+	return c.config("agent", "set", "status", name, string(status))
+}
+
+// SetAgentState sets the state of an agent
+func (c *Callcenter) SetAgentState(name string, state CallcenterAgentState) error {
+	return c.config("agent", "set", "state", name, string(state))
+}
+
+// SetAgentContact sets the dial string of an agent
+func (c *Callcenter) SetAgentContact(name, contact string) error {
+	return c.config("agent", "set", "contact", name, contact)
+}
+
+// SetAgentMaxNoAnswer sets the number of unanswered calls until the agent is
+// set to "On Break"
+func (c *Callcenter) SetAgentMaxNoAnswer(name string, maxNoAnswer int) error {
+	return c.config("agent", "set", "max_no_answer", name, strconv.Itoa(maxNoAnswer))
+}
+
+// Agents returns the list of agents
+func (c *Callcenter) Agents() ([]CallcenterAgent, error) {
+	rows, err := c.list("agent", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	agents := make([]CallcenterAgent, 0, len(rows))
+	for _, row := range rows {
+		agents = append(agents, newCallcenterAgent(row))
+	}
+	return agents, nil
+}
+
+// AddTier adds an agent to a queue with a given level and position
+func (c *Callcenter) AddTier(queue, agent string, level, position int) error {
+	return c.config("tier", "add", queue, agent, strconv.Itoa(level), strconv.Itoa(position))
+}
+
+// DeleteTier removes an agent from a queue
+func (c *Callcenter) DeleteTier(queue, agent string) error {
+	return c.config("tier", "del", queue, agent)
+}
+
+// SetTierLevel sets the level of an agent at a queue
+func (c *Callcenter) SetTierLevel(queue, agent string, level int) error {
+	return c.config("tier", "set", "level", queue, agent, strconv.Itoa(level))
+}
+
+// SetTierPosition sets the position of an agent at a queue
+func (c *Callcenter) SetTierPosition(queue, agent string, position int) error {
+	return c.config("tier", "set", "position", queue, agent, strconv.Itoa(position))
+}
+
+// Tiers returns the list of tiers
+func (c *Callcenter) Tiers() ([]CallcenterTier, error) {
+	rows, err := c.list("tier", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	tiers := make([]CallcenterTier, 0, len(rows))
+	for _, row := range rows {
+		tiers = append(tiers, newCallcenterTier(row))
+	}
+	return tiers, nil
+}
+
+// LoadQueue loads a queue from the configuration
+func (c *Callcenter) LoadQueue(queue string) error {
+	return c.config("queue", "load", queue)
+}
+
+// UnloadQueue unloads a queue
+func (c *Callcenter) UnloadQueue(queue string) error {
+	return c.config("queue", "unload", queue)
+}
+
+// ReloadQueue reloads a queue from the configuration
+func (c *Callcenter) ReloadQueue(queue string) error {
+	return c.config("queue", "reload", queue)
+}
+
+// QueueMembers returns the list of members (callers) that are waiting at a queue
+func (c *Callcenter) QueueMembers(queue string) ([]CallcenterMember, error) {
+	rows, err := c.list("queue", "list", "members", queue)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]CallcenterMember, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, newCallcenterMember(row))
+	}
+	return members, nil
+}
+
+// QueueAgents returns the list of agents of a queue
+func (c *Callcenter) QueueAgents(queue string) ([]CallcenterAgent, error) {
+	rows, err := c.list("queue", "list", "agents", queue)
+	if err != nil {
+		return nil, err
+	}
+
+	agents := make([]CallcenterAgent, 0, len(rows))
+	for _, row := range rows {
+		agents = append(agents, newCallcenterAgent(row))
+	}
+	return agents, nil
+}
+
+// QueueMembersCount returns the number of members that are waiting at a queue
+func (c *Callcenter) QueueMembersCount(queue string) (int64, error) {
+	return c.count("queue", "count", "members", queue)
+}
+
+// QueueAgentsCount returns the number of agents of a queue
+func (c *Callcenter) QueueAgentsCount(queue string) (int64, error) {
+	return c.count("queue", "count", "agents", queue)
+}
+
+// run executes callcenter_config with the given arguments and returns the
+// body of the answer
+func (c *Callcenter) run(args ...string) (string, error) {
+	quoted := make([]Arg, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, QuotedArg(arg))
+	}
+
+	return c.socket.apiArgsBody("callcenter_config", quoted...)
+}
+
+func (c *Callcenter) config(args ...string) error {
+	body, err := c.run(args...)
+	if err != nil {
+		return err
+	}
+
+	body = strings.TrimSpace(body)
+	if !strings.HasPrefix(body, "+OK") {
+		return fmt.Errorf("%w: %s", ErrCallcenterUnexpectedReply, body)
+	}
+
+	return nil
+}
+
+func (c *Callcenter) list(args ...string) ([]map[string]string, error) {
+	body, err := c.run(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCallcenterList(body), nil
+}
+
+func (c *Callcenter) count(args ...string) (int64, error) {
+	body, err := c.run(args...)
+	if err != nil {
+		return 0, err
+	}
+
+	body = strings.TrimSpace(body)
+	count, err := strconv.ParseInt(body, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrCallcenterUnexpectedReply, body)
+	}
+
+	return count, nil
+}
+
+// CallcenterEventFromMessage decodes callcenter::info CUSTOM event.
+func CallcenterEventFromMessage(msg *Message) (*CallcenterEvent, error) {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = isCustomEvent(headers, CallcenterInfoEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	event := CallcenterEvent{
+		Action:            CallcenterAction(headers.GetString("CC-Action")),
+		Queue:             headers.GetString("CC-Queue"),
+		Agent:             headers.GetString("CC-Agent"),
+		AgentStatus:       CallcenterAgentStatus(headers.GetString("CC-Agent-Status")),
+		AgentState:        CallcenterAgentState(headers.GetString("CC-Agent-State")),
+		MemberUUID:        headers.GetString("CC-Member-UUID"),
+		MemberSessionUUID: headers.GetString("CC-Member-Session-UUID"),
+		MemberCIDName:     headers.GetString("CC-Member-CID-Name"),
+		MemberCIDNumber:   headers.GetString("CC-Member-CID-Number"),
+		Cause:             headers.GetString("CC-Cause"),
+		CancelReason:      headers.GetString("CC-Cancel-Reason"),
+		Count:             headers.GetInt("CC-Count"),
+		Headers:           headers,
+	}
+
+	return &event, nil
+}
+
+// parseCallcenterList parse a pipe delimited list, where the first line holds
+// the names of the fields, and the list ends with +OK
+func parseCallcenterList(body string) []map[string]string {
+	var header []string
+	var rows []map[string]string
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "+OK") {
+			continue
+		}
+
+		columns := strings.Split(line, "|")
+		if header == nil {
+			header = columns
+			continue
+		}
+
+		row := make(map[string]string, len(header))
+		for idx, name := range header {
+			if idx < len(columns) {
+				row[name] = columns[idx]
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// parseEpoch returns the time of an epoch string, or zero time if not set
+func parseEpoch(s string) time.Time {
+	epoch := parseInt(s)
+	if epoch <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(epoch, 0)
+}
+
+func newCallcenterAgent(row map[string]string) CallcenterAgent {
+	return CallcenterAgent{
+		Name:              row["name"],
+		InstanceID:        row["instance_id"],
+		UUID:              row["uuid"],
+		Type:              CallcenterAgentType(row["type"]),
+		Contact:           row["contact"],
+		Status:            CallcenterAgentStatus(row["status"]),
+		State:             CallcenterAgentState(row["state"]),
+		MaxNoAnswer:       parseInt(row["max_no_answer"]),
+		WrapUpTime:        parseInt(row["wrap_up_time"]),
+		RejectDelayTime:   parseInt(row["reject_delay_time"]),
+		BusyDelayTime:     parseInt(row["busy_delay_time"]),
+		NoAnswerDelayTime: parseInt(row["no_answer_delay_time"]),
+		LastBridgeStart:   parseEpoch(row["last_bridge_start"]),
+		LastBridgeEnd:     parseEpoch(row["last_bridge_end"]),
+		LastOfferedCall:   parseEpoch(row["last_offered_call"]),
+		LastStatusChange:  parseEpoch(row["last_status_change"]),
+		NoAnswerCount:     parseInt(row["no_answer_count"]),
+		CallsAnswered:     parseInt(row["calls_answered"]),
+		TalkTime:          parseInt(row["talk_time"]),
+		ReadyTime:         parseInt(row["ready_time"]),
+		Fields:            row,
+	}
+}
+
+func newCallcenterTier(row map[string]string) CallcenterTier {
+	return CallcenterTier{
+		Queue:    row["queue"],
+		Agent:    row["agent"],
+		State:    row["state"],
+		Level:    parseInt(row["level"]),
+		Position: parseInt(row["position"]),
+		Fields:   row,
+	}
+}
+
+func newCallcenterMember(row map[string]string) CallcenterMember {
+	return CallcenterMember{
+		Queue:         row["queue"],
+		InstanceID:    row["instance_id"],
+		UUID:          row["uuid"],
+		SessionUUID:   row["session_uuid"],
+		CIDNumber:     row["cid_number"],
+		CIDName:       row["cid_name"],
+		SystemEpoch:   parseEpoch(row["system_epoch"]),
+		JoinedEpoch:   parseEpoch(row["joined_epoch"]),
+		RejoinedEpoch: parseEpoch(row["rejoined_epoch"]),
+		BridgeEpoch:   parseEpoch(row["bridge_epoch"]),
+		AbandonedAt:   parseEpoch(row["abandoned_epoch"]),
+		BaseScore:     parseInt(row["base_score"]),
+		SkillScore:    parseInt(row["skill_score"]),
+		ServingAgent:  row["serving_agent"],
+		ServingSystem: row["serving_system"],
+		State:         row["state"],
+		Score:         parseInt(row["score"]),
+		Fields:        row,
+	}
+}
@@ -0,0 +1,172 @@
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const callcenterAgentsFixture = `name|instance_id|uuid|type|contact|status|state|max_no_answer|wrap_up_time|reject_delay_time|busy_delay_time|no_answer_delay_time|last_bridge_start|last_bridge_end|last_offered_call|last_status_change|no_answer_count|calls_answered|talk_time|ready_time|external_calls_count
+1000@default|single_box||callback|[call_timeout=10]user/1000@default|Available|Waiting|3|10|10|60|0|1600000000|0|0|1600000100|0|12|300|0|0
+1001@default|single_box||callback|user/1001@default|On Break|Idle|3|10|10|60|0|0|0|0|0|0|0|0|0|0
++OK
+`
+
+const callcenterTiersFixture = `queue|agent|state|level|position
+support@default|1000@default|Ready|1|2
++OK
+`
+
+const callcenterMembersFixture = `queue|instance_id|uuid|session_uuid|cid_number|cid_name|system_epoch|joined_epoch|rejoined_epoch|bridge_epoch|abandoned_epoch|base_score|skill_score|serving_agent|serving_system|state|score
+support@default|single_box|m-1|s-1|1002|Carol|1600000000|1600000000|0|0|0|0|0|||Waiting|35
++OK
+`
+
+func TestParseCallcenterList(t *testing.T) {
+	rows := parseCallcenterList(callcenterAgentsFixture)
+	if len(rows) != 2 {
+		t.Errorf("Expected 2 rows, got %d: %+v", len(rows), rows)
+		return
+	}
+
+	agent := newCallcenterAgent(rows[0])
+	if agent.Name != "1000@default" || agent.Status != CCAgentStatusAvailable || agent.State != CCAgentStateWaiting {
+		t.Errorf("Unexpected agent: %+v", agent)
+	}
+
+	if agent.CallsAnswered != 12 || agent.LastBridgeStart.Unix() != 1600000000 {
+		t.Errorf("Unexpected agent counters: %+v", agent)
+	}
+
+	if !agent.LastBridgeEnd.IsZero() {
+		t.Errorf("Expected zero LastBridgeEnd, got %s", agent.LastBridgeEnd)
+	}
+
+	member := newCallcenterMember(parseCallcenterList(callcenterMembersFixture)[0])
+	if member.CIDName != "Carol" || member.State != "Waiting" || member.Score != 35 {
+		t.Errorf("Unexpected member: %+v", member)
+	}
+}
+
+func TestCallcenterCommands(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		switch cmd {
+		case "api callcenter_config agent list":
+			return fakeAPIResponse(callcenterAgentsFixture)
+		case "api callcenter_config tier list":
+			return fakeAPIResponse(callcenterTiersFixture)
+		case "api callcenter_config queue list members support@default":
+			return fakeAPIResponse(callcenterMembersFixture)
+		case "api callcenter_config queue count members support@default":
+			return fakeAPIResponse("1\n")
+		case "api callcenter_config agent set status 1000@default 'On Break'",
+			"api callcenter_config tier add support@default 1000@default 1 2",
+			"api callcenter_config queue load support@default":
+			return fakeAPIResponse("+OK\n")
+		case "api callcenter_config agent del 9999@default":
+			return fakeAPIResponse("-ERR Invalid Agent!\n")
+		}
+		return fakeAPIResponse("Unknown command\n")
+	})
+
+	socket := connectFakeServer(t, server)
+	cc := NewCallcenter(socket)
+
+	agents, err := cc.Agents()
+	if err != nil || len(agents) != 2 {
+		t.Errorf("Unexpected agents: %+v, %v", agents, err)
+	}
+
+	tiers, err := cc.Tiers()
+	if err != nil || len(tiers) != 1 || tiers[0].Position != 2 {
+		t.Errorf("Unexpected tiers: %+v, %v", tiers, err)
+	}
+
+	members, err := cc.QueueMembers("support@default")
+	if err != nil || len(members) != 1 {
+		t.Errorf("Unexpected members: %+v, %v", members, err)
+	}
+
+	count, err := cc.QueueMembersCount("support@default")
+	if err != nil || count != 1 {
+		t.Errorf("Unexpected count: %d, %v", count, err)
+	}
+
+	err = cc.SetAgentStatus("1000@default", CCAgentStatusOnBreak)
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	err = cc.AddTier("support@default", "1000@default", 1, 2)
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	err = cc.LoadQueue("support@default")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	err = cc.DeleteAgent("9999@default")
+	if err == nil {
+		t.Errorf("Expected error deleting unknown agent")
+	}
+
+	err = cc.UnloadQueue("support@default")
+	if !errors.Is(err, ErrCallcenterUnexpectedReply) {
+		t.Errorf("Expected ErrCallcenterUnexpectedReply, got: %v", err)
+	}
+
+	err = cc.LoadQueue("support@default\nqueue unload x")
+	if !errors.Is(err, ErrUnsafeArgument) {
+		t.Errorf("Expected ErrUnsafeArgument, got: %v", err)
+	}
+}
+
+func TestCallcenterEventFromMessage(t *testing.T) {
+	body := "Event-Name: CUSTOM\nEvent-Subclass: callcenter%3A%3Ainfo\n" +
+		"CC-Action: agent-status-change\nCC-Agent: 1000@default\n" +
+		"CC-Agent-Status: Available%20(On%20Demand)\n\n"
+	input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+
+	msg, err := NewMessage([]byte(input), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	event, err := CallcenterEventFromMessage(msg)
+	if err != nil {
+		t.Errorf("Unable to decode event: %s", err)
+		return
+	}
+
+	if !event.IsAgentState() || event.IsMemberQueue() {
+		t.Errorf("Unexpected action: %s", event.Action)
+	}
+
+	if event.Agent != "1000@default" || event.AgentStatus != CCAgentStatusAvailableOnDemand {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+
+	body = "Event-Name: CUSTOM\nEvent-Subclass: callcenter%3A%3Ainfo\n" +
+		"CC-Action: member-queue-end\nCC-Queue: support@default\n" +
+		"CC-Member-UUID: m-1\nCC-Cause: Cancel\nCC-Cancel-Reason: TIMEOUT\n\n"
+	input = fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+
+	msg, err = NewMessage([]byte(input), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	event, err = CallcenterEventFromMessage(msg)
+	if err != nil {
+		t.Errorf("Unable to decode event: %s", err)
+		return
+	}
+
+	if !event.IsMemberQueue() || event.MemberUUID != "m-1" || event.CancelReason != "TIMEOUT" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
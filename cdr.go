@@ -0,0 +1,314 @@
+package esl
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Current file contains call detail records (CDR) that are built from
+// CHANNEL_HANGUP_COMPLETE events, and sinks to write them into.
+
+// CDR legs
+const (
+	CDRLegA = "A"
+	CDRLegB = "B"
+)
+
+// cdrTimeFormat is the format of times in CSV and NDJSON records
+const cdrTimeFormat = time.RFC3339Nano
+
+// CDR is a normalized call detail record of a single leg
+type CDR struct {
+	UUID              string
+	CallUUID          string
+	Leg               string
+	OtherLegUUID      string
+	Direction         string
+	CallerIDName      string
+	CallerIDNumber    string
+	DestinationNumber string
+	Context           string
+	StartTime         time.Time
+	AnswerTime        time.Time
+	EndTime           time.Time
+	Duration          int64
+	BillSec           int64
+	HangupCause       string
+	HangupCauseQ850   int64
+
+	// Variables holds all the channel variables (without the variable_ prefix)
+	Variables map[string]string
+}
+
+// CDRSink receives the records that are built
+type CDRSink interface {
+	WriteCDR(cdr *CDR) error
+}
+
+// CDRSinkFunc is a callback that implements CDRSink
+type CDRSinkFunc func(cdr *CDR) error
+
+// WriteCDR implements CDRSink
+func (f CDRSinkFunc) WriteCDR(cdr *CDR) error {
+	return f(cdr)
+}
+
+// NewCDR creates a CDR from a CHANNEL_HANGUP_COMPLETE event.
+func NewCDR(msg *Message) (*CDR, error) {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	name := headers.GetString("Event-Name")
+	if name != "CHANNEL_HANGUP_COMPLETE" {
+		return nil, fmt.Errorf("%w: expected CHANNEL_HANGUP_COMPLETE, got %s", ErrUnexpectedEvent, name)
+	}
+
+	variables := make(map[string]string)
+	for _, key := range headers.Keys() {
+		if strings.HasPrefix(key, variablePrefix) {
+			variables[strings.TrimPrefix(key, variablePrefix)] = headers.GetString(key)
+		}
+	}
+
+	first := func(values ...string) string {
+		for _, value := range values {
+			if value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	cdr := CDR{
+		UUID:              first(headers.GetString("Unique-ID"), variables["uuid"]),
+		CallUUID:          variables["call_uuid"],
+		Direction:         first(variables["direction"], headers.GetString("Call-Direction")),
+		CallerIDName:      first(variables["caller_id_name"], headers.GetString("Caller-Caller-ID-Name")),
+		CallerIDNumber:    first(variables["caller_id_number"], headers.GetString("Caller-Caller-ID-Number")),
+		DestinationNumber: first(variables["destination_number"], headers.GetString("Caller-Destination-Number")),
+		Context:           first(variables["context"], headers.GetString("Caller-Context")),
+		StartTime:         cdrTime(variables["start_uepoch"], variables["start_epoch"]),
+		AnswerTime:        cdrTime(variables["answer_uepoch"], variables["answer_epoch"]),
+		EndTime:           cdrTime(variables["end_uepoch"], variables["end_epoch"]),
+		Duration:          parseInt(variables["duration"]),
+		BillSec:           parseInt(variables["billsec"]),
+		HangupCause:       first(variables["hangup_cause"], headers.GetString("Hangup-Cause")),
+		HangupCauseQ850:   parseInt(variables["hangup_cause_q850"]),
+		Variables:         variables,
+	}
+
+	// A B leg is originated by the A leg
+	originator := first(variables["originator"], variables["originating_leg_uuid"])
+	if originator != "" {
+		cdr.Leg = CDRLegB
+		cdr.OtherLegUUID = originator
+	} else {
+		cdr.Leg = CDRLegA
+		cdr.OtherLegUUID = first(
+			variables["bridge_uuid"],
+			variables["last_bridge_to"],
+			variables["signal_bond"],
+			headers.GetString("Other-Leg-Unique-ID"),
+		)
+	}
+
+	if cdr.CallUUID == "" {
+		cdr.CallUUID = cdr.UUID
+	}
+
+	return &cdr, nil
+}
+
+// CDRBuilder builds a CDR from every CHANNEL_HANGUP_COMPLETE event, and
+// writes it to all of its sinks
+type CDRBuilder struct {
+	sinks []CDRSink
+}
+
+// NewCDRBuilder creates a new CDRBuilder with the given sinks
+func NewCDRBuilder(sinks ...CDRSink) *CDRBuilder {
+	return &CDRBuilder{sinks: sinks}
+}
+
+// HandleEvent builds a CDR if msg is CHANNEL_HANGUP_COMPLETE event, and
+// writes it into the sinks. Other events are ignored, and nil is returned.
+func (b *CDRBuilder) HandleEvent(msg *Message) (*CDR, error) {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if headers.GetString("Event-Name") != "CHANNEL_HANGUP_COMPLETE" {
+		return nil, nil
+	}
+
+	cdr, err := NewCDR(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, sink := range b.sinks {
+		err = sink.WriteCDR(cdr)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return cdr, errors.Join(errs...)
+}
+
+// cdrColumns are the columns of the CSV sink
+var cdrColumns = []string{
+	"uuid", "call_uuid", "leg", "other_leg_uuid", "direction",
+	"caller_id_name", "caller_id_number", "destination_number", "context",
+	"start_time", "answer_time", "end_time", "duration", "billsec",
+	"hangup_cause", "hangup_cause_q850",
+}
+
+// CSVSink writes records as CSV, with a header line
+type CSVSink struct {
+	lock      sync.Mutex
+	writer    *csv.Writer
+	variables []string
+	header    bool
+}
+
+// NewCSVSink creates a new CSVSink. variables are names of channel variables
+// that are added as extra columns.
+func NewCSVSink(w io.Writer, variables ...string) *CSVSink {
+	return &CSVSink{
+		writer:    csv.NewWriter(w),
+		variables: variables,
+	}
+}
+
+// WriteCDR implements CDRSink
+func (s *CSVSink) WriteCDR(cdr *CDR) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.header {
+		err := s.writer.Write(append(append([]string(nil), cdrColumns...), s.variables...))
+		if err != nil {
+			return err
+		}
+		s.header = true
+	}
+
+	record := []string{
+		cdr.UUID, cdr.CallUUID, cdr.Leg, cdr.OtherLegUUID, cdr.Direction,
+		cdr.CallerIDName, cdr.CallerIDNumber, cdr.DestinationNumber, cdr.Context,
+		formatCDRTime(cdr.StartTime), formatCDRTime(cdr.AnswerTime), formatCDRTime(cdr.EndTime),
+		strconv.FormatInt(cdr.Duration, 10), strconv.FormatInt(cdr.BillSec, 10),
+		cdr.HangupCause, strconv.FormatInt(cdr.HangupCauseQ850, 10),
+	}
+	for _, variable := range s.variables {
+		record = append(record, cdr.Variables[variable])
+	}
+
+	err := s.writer.Write(record)
+	if err != nil {
+		return err
+	}
+
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// NDJSONSink writes every record as a JSON line
+type NDJSONSink struct {
+	lock      sync.Mutex
+	encoder   *json.Encoder
+	variables bool
+}
+
+// NewNDJSONSink creates a new NDJSONSink. If withVariables is true, all the
+// channel variables are written as well.
+func NewNDJSONSink(w io.Writer, withVariables bool) *NDJSONSink {
+	return &NDJSONSink{
+		encoder:   json.NewEncoder(w),
+		variables: withVariables,
+	}
+}
+
+// cdrJSON is the JSON representation of a CDR
+type cdrJSON struct {
+	UUID              string            `json:"uuid"`
+	CallUUID          string            `json:"call_uuid"`
+	Leg               string            `json:"leg"`
+	OtherLegUUID      string            `json:"other_leg_uuid,omitempty"`
+	Direction         string            `json:"direction"`
+	CallerIDName      string            `json:"caller_id_name"`
+	CallerIDNumber    string            `json:"caller_id_number"`
+	DestinationNumber string            `json:"destination_number"`
+	Context           string            `json:"context"`
+	StartTime         string            `json:"start_time,omitempty"`
+	AnswerTime        string            `json:"answer_time,omitempty"`
+	EndTime           string            `json:"end_time,omitempty"`
+	Duration          int64             `json:"duration"`
+	BillSec           int64             `json:"billsec"`
+	HangupCause       string            `json:"hangup_cause"`
+	HangupCauseQ850   int64             `json:"hangup_cause_q850"`
+	Variables         map[string]string `json:"variables,omitempty"`
+}
+
+// WriteCDR implements CDRSink
+func (s *NDJSONSink) WriteCDR(cdr *CDR) error {
+	record := cdrJSON{
+		UUID:              cdr.UUID,
+		CallUUID:          cdr.CallUUID,
+		Leg:               cdr.Leg,
+		OtherLegUUID:      cdr.OtherLegUUID,
+		Direction:         cdr.Direction,
+		CallerIDName:      cdr.CallerIDName,
+		CallerIDNumber:    cdr.CallerIDNumber,
+		DestinationNumber: cdr.DestinationNumber,
+		Context:           cdr.Context,
+		StartTime:         formatCDRTime(cdr.StartTime),
+		AnswerTime:        formatCDRTime(cdr.AnswerTime),
+		EndTime:           formatCDRTime(cdr.EndTime),
+		Duration:          cdr.Duration,
+		BillSec:           cdr.BillSec,
+		HangupCause:       cdr.HangupCause,
+		HangupCauseQ850:   cdr.HangupCauseQ850,
+	}
+	if s.variables {
+		record.Variables = cdr.Variables
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.encoder.Encode(record)
+}
+
+// cdrTime returns the time of micro seconds epoch, or seconds epoch if not
+// available, or zero time if the time was not set (e.g. unanswered call)
+func cdrTime(uepoch, epoch string) time.Time {
+	if micro := parseInt(uepoch); micro > 0 {
+		return time.Unix(0, micro*int64(time.Microsecond)).UTC()
+	}
+
+	if seconds := parseInt(epoch); seconds > 0 {
+		return time.Unix(seconds, 0).UTC()
+	}
+
+	return time.Time{}
+}
+
+func formatCDRTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(cdrTimeFormat)
+}
@@ -0,0 +1,140 @@
+package esl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hangupCompleteLines returns the header lines of a CHANNEL_HANGUP_COMPLETE
+// event of a call, followed by the given lines
+func hangupCompleteLines(uuid string, lines ...string) []string {
+	return append([]string{
+		"Event-Name: CHANNEL_HANGUP_COMPLETE",
+		"Unique-ID: " + uuid,
+		"Hangup-Cause: NORMAL_CLEARING",
+		"variable_uuid: " + uuid,
+		"variable_caller_id_name: Alice%20Smith",
+		"variable_caller_id_number: 1000",
+		"variable_destination_number: 1001",
+		"variable_start_uepoch: 1600000000000000",
+		"variable_answer_uepoch: 1600000005000000",
+		"variable_end_uepoch: 1600000065000000",
+		"variable_duration: 65",
+		"variable_billsec: 60",
+		"variable_hangup_cause: NORMAL_CLEARING",
+		"variable_hangup_cause_q850: 16",
+	}, lines...)
+}
+
+func TestNewCDR(t *testing.T) {
+	cdr, err := NewCDR(fakeEventMessage(t, hangupCompleteLines("a-leg", "variable_direction: inbound", "variable_bridge_uuid: b-leg")...))
+	if err != nil {
+		t.Errorf("Unable to build CDR: %s", err)
+		return
+	}
+
+	if cdr.UUID != "a-leg" || cdr.Leg != CDRLegA || cdr.OtherLegUUID != "b-leg" || cdr.CallUUID != "a-leg" {
+		t.Errorf("Unexpected legs: %+v", cdr)
+	}
+
+	if cdr.CallerIDName != "Alice Smith" || cdr.DestinationNumber != "1001" {
+		t.Errorf("Unexpected caller: %+v", cdr)
+	}
+
+	if cdr.AnswerTime.Sub(cdr.StartTime) != 5*time.Second || cdr.EndTime.Sub(cdr.AnswerTime) != time.Minute {
+		t.Errorf("Unexpected times: %s %s %s", cdr.StartTime, cdr.AnswerTime, cdr.EndTime)
+	}
+
+	if cdr.BillSec != 60 || cdr.Duration != 65 || cdr.HangupCauseQ850 != 16 {
+		t.Errorf("Unexpected durations: %+v", cdr)
+	}
+
+	cdr, err = NewCDR(fakeEventMessage(t, hangupCompleteLines("b-leg", "variable_originator: a-leg", "variable_call_uuid: a-leg")...))
+	if err != nil {
+		t.Errorf("Unable to build CDR: %s", err)
+		return
+	}
+
+	if cdr.Leg != CDRLegB || cdr.OtherLegUUID != "a-leg" || cdr.CallUUID != "a-leg" {
+		t.Errorf("Unexpected B leg: %+v", cdr)
+	}
+
+	_, err = NewCDR(fakeEventMessage(t, "Event-Name: CHANNEL_HANGUP", "Unique-ID: x"))
+	if !errors.Is(err, ErrUnexpectedEvent) {
+		t.Errorf("Expected ErrUnexpectedEvent, got: %v", err)
+	}
+}
+
+func TestCDRBuilderSinks(t *testing.T) {
+	var csvOut, jsonOut bytes.Buffer
+	var called []string
+
+	builder := NewCDRBuilder(
+		NewCSVSink(&csvOut, "hangup_cause_q850"),
+		NewNDJSONSink(&jsonOut, false),
+		CDRSinkFunc(func(cdr *CDR) error {
+			called = append(called, cdr.UUID)
+			return nil
+		}),
+	)
+
+	cdr, err := builder.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_ANSWER", "Unique-ID: x"))
+	if cdr != nil || err != nil {
+		t.Errorf("Expected other events to be ignored: %v %v", cdr, err)
+	}
+
+	for _, uuid := range []string{"a-leg", "b-leg"} {
+		_, err = builder.HandleEvent(fakeEventMessage(t, hangupCompleteLines(uuid)...))
+		if err != nil {
+			t.Errorf("Unable to handle event: %s", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	if len(lines) != 3 {
+		t.Errorf("Expected header and 2 records, got:\n%s", csvOut.String())
+		return
+	}
+
+	if !strings.HasPrefix(lines[0], "uuid,call_uuid,leg,") || !strings.HasSuffix(lines[0], ",hangup_cause_q850,hangup_cause_q850") {
+		t.Errorf("Unexpected header: %s", lines[0])
+	}
+
+	if !strings.HasPrefix(lines[1], "a-leg,a-leg,A,,,Alice Smith,1000,1001,,2020-09-13T12:26:40Z,") {
+		t.Errorf("Unexpected record: %s", lines[1])
+	}
+
+	decoder := json.NewDecoder(&jsonOut)
+	for _, uuid := range []string{"a-leg", "b-leg"} {
+		var record map[string]interface{}
+		err = decoder.Decode(&record)
+		if err != nil {
+			t.Errorf("Unable to decode NDJSON: %s", err)
+			return
+		}
+
+		if record["uuid"] != uuid || record["billsec"] != float64(60) || record["variables"] != nil {
+			t.Errorf("Unexpected record: %v", record)
+		}
+	}
+
+	if strings.Join(called, ",") != "a-leg,b-leg" {
+		t.Errorf("Unexpected callback calls: %v", called)
+	}
+}
+
+func TestCDRBuilderSinkError(t *testing.T) {
+	sinkErr := errors.New("disk full")
+	builder := NewCDRBuilder(CDRSinkFunc(func(cdr *CDR) error {
+		return sinkErr
+	}))
+
+	cdr, err := builder.HandleEvent(fakeEventMessage(t, hangupCompleteLines("a-leg")...))
+	if cdr == nil || !errors.Is(err, sinkErr) {
+		t.Errorf("Expected CDR with sink error, got: %v %v", cdr, err)
+	}
+}
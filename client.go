@@ -0,0 +1,747 @@
+package esl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Current file contains a client that uses two connections to the same
+// server, one for commands and one for events.
+//
+// Events are read by their own connection, so a burst of events never delays
+// the reply of a command. When one of the connections is lost, both are
+// closed and connected again, and the events are subscribed again.
+//
+// When the config has a heartbeat timeout, a Watchdog closes the connections
+// if no heartbeat arrived (see HeartbeatConfig), so they are connected again.
+//
+// When the config has standby hosts, the client connects to the first host
+// that is available. When the active host is lost, the other hosts are tried
+// first, and the host that was lost is tried last.
+
+// AllEvents is the event name of handlers that receive every event
+const AllEvents = "ALL"
+
+// EventHandler receives a parsed event (see ParseEvent)
+type EventHandler func(event *Message)
+
+// Client holds a commands connection and an events connection to the same
+// server, that share the same config and reconnect together.
+type Client struct {
+	config Config
+
+	// lock guards the active host, its sockets and the subscribed events
+	lock          sync.RWMutex
+	host          string
+	commands      *Socket
+	events        *Socket
+	watchdog      *Watchdog
+	subscriptions []string
+
+	handlersLock sync.RWMutex
+	handlers     map[string]map[int]*Subscription
+	reconnected  map[int]func()
+	failovers    map[int]func(host string)
+	gaps         map[int]func(SequenceGap)
+	nextID       int
+
+	// sequence tracks the events when subscribed to ALL
+	sequence      *SequenceTracker
+	trackSequence atomic.Bool
+
+	// heartbeat is the last heartbeat of the server
+	heartbeat atomic.Pointer[Heartbeat]
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewClient connects and logs in both connections to the first host of cfg
+// that is available, with retries based on its backoff policy, and starts
+// reading events. Call Close to close the client.
+func NewClient(cfg Config) (*Client, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &Client{
+		config:      cfg,
+		handlers:    make(map[string]map[int]*Subscription),
+		reconnected: make(map[int]func()),
+		failovers:   make(map[int]func(string)),
+		gaps:        make(map[int]func(SequenceGap)),
+		sequence:    NewSequenceTracker(),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+
+	bo := backoff.WithContext(cfg.Backoff.newBackOff(), ctx)
+	host, commands, events, err := client.connectHosts(cfg.hosts(), bo, false)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	client.host, client.commands, client.events = host, commands, events
+	client.watchdog = client.newWatchdog(events)
+
+	go client.run()
+
+	if cfg.Heartbeat.Timeout > 0 && cfg.Heartbeat.PollInterval > 0 {
+		go client.poll()
+	}
+	if cfg.Failback > 0 && len(cfg.Standby) > 0 {
+		go client.failback()
+	}
+
+	return client, nil
+}
+
+// connectPair connects the commands and the events connections
+func connectPair(cfg Config) (*Socket, *Socket, error) {
+	commands, err := ConnectConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events, err := ConnectConfig(cfg)
+	if err != nil {
+		commands.Close()
+		return nil, nil, err
+	}
+
+	return commands, events, nil
+}
+
+// connectHosts connects both connections to the first host that is
+// available, in the given order, and subscribes the events. Every attempt
+// dials each host once, the attempts are retried using bo. When all the hosts
+// replied with an error (e.g. a wrong password), it is not retried unless
+// retryRejected is true. It returns the host that was connected.
+func (c *Client) connectHosts(hosts []string, bo backoff.BackOff, retryRejected bool) (string, *Socket, *Socket, error) {
+	cfg := c.config.With(WithMaxRetries(0))
+
+	var host string
+	var commands, events *Socket
+	err := backoff.Retry(func() error {
+		var errs []error
+		rejected := 0
+
+		for _, host = range hosts {
+			cfg.Host = host
+
+			var err error
+			commands, events, err = connectPair(cfg)
+			if err == nil {
+				err = c.subscribeEvents(events)
+				if err == nil {
+					return nil
+				}
+				commands.Close()
+				events.Close()
+			}
+
+			var cmdErr *CommandError
+			if errors.As(err, &cmdErr) {
+				rejected++
+			}
+			if len(hosts) > 1 {
+				err = fmt.Errorf("host %s: %w", host, err)
+			}
+			errs = append(errs, err)
+		}
+
+		err := errors.Join(errs...)
+		if rejected == len(hosts) && !retryRejected {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, bo)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return host, commands, events, nil
+}
+
+// Host returns the host that the client is connected to (or was connected
+// to, while connecting again)
+func (c *Client) Host() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.host
+}
+
+// Commands returns the current commands connection, e.g. for NewSofia. The
+// connection is replaced after a reconnect.
+func (c *Client) Commands() *Socket {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.commands
+}
+
+// API sends an api command on the commands connection
+func (c *Client) API(cmd, args string) (*Message, error) {
+	return c.command(func(socket *Socket) (*Message, error) {
+		return socket.API(cmd, args)
+	})
+}
+
+// APIContext sends an api command on the commands connection (see
+// Socket.APIContext)
+func (c *Client) APIContext(ctx context.Context, cmd, args string) (*Message, error) {
+	return c.command(func(socket *Socket) (*Message, error) {
+		return socket.APIContext(ctx, cmd, args)
+	})
+}
+
+// BgAPI sends a bgapi command on the commands connection
+func (c *Client) BgAPI(cmd, args string) (*Message, error) {
+	return c.command(func(socket *Socket) (*Message, error) {
+		return socket.BgAPI(cmd, args)
+	})
+}
+
+// BgAPIContext sends a bgapi command on the commands connection (see
+// Socket.BgAPIContext)
+func (c *Client) BgAPIContext(ctx context.Context, cmd, args string) (*Message, error) {
+	return c.command(func(socket *Socket) (*Message, error) {
+		return socket.BgAPIContext(ctx, cmd, args)
+	})
+}
+
+// Execute runs a dialplan application on a channel (see Socket.Execute)
+func (c *Client) Execute(uuid, app, arg string) (*Message, error) {
+	return c.command(func(socket *Socket) (*Message, error) {
+		return socket.Execute(uuid, app, arg)
+	})
+}
+
+// SendEvent sends an event into the event system (see Socket.SendEvent)
+func (c *Client) SendEvent(eventName string, headers Headers, body string) (*Message, error) {
+	return c.command(func(socket *Socket) (*Message, error) {
+		return socket.SendEvent(eventName, headers, body)
+	})
+}
+
+// command runs send on the commands connection. A connection error closes
+// the events connection as well, so both are connected again.
+func (c *Client) command(send func(socket *Socket) (*Message, error)) (*Message, error) {
+	socket := c.Commands()
+
+	msg, err := send(socket)
+	if err != nil && socket.State() >= StateClosing {
+		c.disconnect()
+	}
+
+	return msg, err
+}
+
+// Subscribe subscribes the events connection to the given events, using the
+// event format of the config. Events that the server accepted are subscribed
+// again after a reconnect.
+func (c *Client) Subscribe(events ...string) error {
+	c.lock.RLock()
+	socket := c.events
+	c.lock.RUnlock()
+
+	// The reply is matched by the socket, while run reads the events
+	msg, err := socket.Events(c.eventFormat(), events...)
+	if err != nil {
+		if socket.State() >= StateClosing {
+			c.disconnect()
+		}
+		return err
+	}
+
+	err = msg.Error()
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.subscriptions = append(c.subscriptions, events...)
+	c.lock.Unlock()
+
+	for _, event := range events {
+		if strings.EqualFold(event, AllEvents) {
+			c.trackSequence.Store(true)
+		}
+	}
+
+	return nil
+}
+
+// eventFormat returns the event format of the config
+func (c *Client) eventFormat() EventOutputType {
+	if c.config.EventFormat == "" {
+		return EOTPlain
+	}
+	return c.config.EventFormat
+}
+
+// Handle registers a handler for events with the given name (Event-Name), or
+// for every event using AllEvents, using the event queue of the config (see
+// HandleQueue). It returns a function that removes the handler.
+func (c *Client) Handle(eventName string, handler EventHandler) func() {
+	// The queue of the config was validated by NewClient
+	subscription, _ := c.HandleQueue(eventName, handler, c.config.EventQueue)
+
+	return subscription.Close
+}
+
+// HandleQueue registers a handler for events with the given name (Event-Name),
+// or for every event using AllEvents.
+//
+// Every handler has its own queue and goroutine, so a slow handler does not
+// delay the others (unless its queue is full and its policy is QueueBlock).
+// A handler is called in the order of the events. The events are shared
+// between the handlers, and must not be changed.
+func (c *Client) HandleQueue(eventName string, handler EventHandler, queue QueueConfig) (*Subscription, error) {
+	err := queue.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
+	id := c.nextID
+	c.nextID++
+
+	subscription := newSubscription(handler, queue, func() {
+		c.handlersLock.Lock()
+		defer c.handlersLock.Unlock()
+
+		delete(c.handlers[eventName], id)
+	}, c.config.Metrics)
+
+	if c.handlers[eventName] == nil {
+		c.handlers[eventName] = make(map[int]*Subscription)
+	}
+	c.handlers[eventName][id] = subscription
+
+	return subscription, nil
+}
+
+// OnReconnect registers a function that is called after both connections
+// were connected again, e.g. for Tracker.Bootstrap. It returns a function
+// that removes it.
+func (c *Client) OnReconnect(fn func()) func() {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
+	id := c.nextID
+	c.nextID++
+	c.reconnected[id] = fn
+
+	return func() {
+		c.handlersLock.Lock()
+		defer c.handlersLock.Unlock()
+
+		delete(c.reconnected, id)
+	}
+}
+
+// OnFailover registers a function that is called with the new host, after
+// the client was connected to another host (see Config.Standby). It is called
+// before the functions of OnReconnect. It returns a function that removes it.
+func (c *Client) OnFailover(fn func(host string)) func() {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
+	id := c.nextID
+	c.nextID++
+	c.failovers[id] = fn
+
+	return func() {
+		c.handlersLock.Lock()
+		defer c.handlersLock.Unlock()
+
+		delete(c.failovers, id)
+	}
+}
+
+// OnGap registers a function that is called when events were lost or
+// duplicated, based on their Event-Sequence. Gaps are detected only when the
+// client is subscribed to ALL the events (see SequenceTracker). It returns a
+// function that removes it.
+//
+// The function is called before the event that revealed the gap is queued to
+// the handlers, while older events can still be queued. A resync of the state
+// should be queued after them, as Tracker.Attach does.
+func (c *Client) OnGap(fn func(gap SequenceGap)) func() {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
+	id := c.nextID
+	c.nextID++
+	c.gaps[id] = fn
+
+	return func() {
+		c.handlersLock.Lock()
+		defer c.handlersLock.Unlock()
+
+		delete(c.gaps, id)
+	}
+}
+
+// Status returns the status of the server using the "status" api command
+func (c *Client) Status() (Heartbeat, error) {
+	var heartbeat Heartbeat
+
+	_, err := c.command(func(socket *Socket) (*Message, error) {
+		var err error
+		heartbeat, err = socket.Status()
+		return nil, err
+	})
+
+	return heartbeat, err
+}
+
+// Heartbeat returns the last heartbeat of the server, and false if none
+// arrived. Heartbeats are received only when the config has a heartbeat
+// timeout.
+func (c *Client) Heartbeat() (Heartbeat, bool) {
+	heartbeat := c.heartbeat.Load()
+	if heartbeat == nil {
+		return Heartbeat{}, false
+	}
+	return *heartbeat, true
+}
+
+// SequenceStats returns the counters of lost and duplicated events
+func (c *Client) SequenceStats() SequenceStats {
+	return c.sequence.Stats()
+}
+
+// Done is closed when the client was closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes both connections and stops reading events. Close can be
+// called more than once.
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.lock.RLock()
+	commands, events, watchdog := c.commands, c.events, c.watchdog
+	c.lock.RUnlock()
+
+	if watchdog != nil {
+		watchdog.Stop()
+	}
+
+	err := commands.Close()
+	eventsErr := events.Close()
+	if err == nil {
+		err = eventsErr
+	}
+
+	// Unblock reading events if a queue is full
+	c.handlersLock.RLock()
+	var subscriptions []*Subscription
+	for _, handlers := range c.handlers {
+		for _, subscription := range handlers {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	c.handlersLock.RUnlock()
+
+	for _, subscription := range subscriptions {
+		subscription.Close()
+	}
+
+	<-c.done
+	return err
+}
+
+// disconnect closes the events connection, so run connects both again
+func (c *Client) disconnect() {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	c.events.Close()
+}
+
+// run reads and dispatches events, and reconnects when a connection is lost
+func (c *Client) run() {
+	defer close(c.done)
+
+	for {
+		c.lock.RLock()
+		events, watchdog := c.events, c.watchdog
+		c.lock.RUnlock()
+
+		c.readEvents(events, watchdog)
+
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		if !c.reconnect() {
+			return
+		}
+	}
+}
+
+// readEvents reads from the events connection until it fails, and passes
+// heartbeats to the watchdog of the connection
+func (c *Client) readEvents(events *Socket, watchdog *Watchdog) {
+	for {
+		msg, err := events.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch msg.ContentType() {
+		case ECTEventPlain, ECTEventJSON, ECTEventXML:
+			event, err := ParseEvent(msg)
+			if err != nil {
+				continue
+			}
+			if watchdog != nil && event.Headers.GetString("Event-Name") == HeartbeatEvent {
+				heartbeat, err := HeartbeatFromEvent(event)
+				if err == nil {
+					c.beat(watchdog, heartbeat)
+				}
+			}
+			c.dispatch(event)
+		case ECTDisconnectNotice, ECTRudeRejection:
+			return
+		}
+	}
+}
+
+// dispatch calls the handlers of an event
+func (c *Client) dispatch(event *Message) {
+	if c.trackSequence.Load() {
+		gap, found := c.sequence.Track(event.Headers)
+		if found {
+			c.reportGap(gap)
+		}
+	}
+
+	name := event.Headers.GetString("Event-Name")
+
+	c.handlersLock.RLock()
+	var subscriptions []*Subscription
+	for _, subscription := range c.handlers[name] {
+		subscriptions = append(subscriptions, subscription)
+	}
+	for _, subscription := range c.handlers[AllEvents] {
+		subscriptions = append(subscriptions, subscription)
+	}
+	c.handlersLock.RUnlock()
+
+	for _, subscription := range subscriptions {
+		subscription.push(event)
+	}
+}
+
+// reportGap reports a gap into the metrics and the gap functions
+func (c *Client) reportGap(gap SequenceGap) {
+	reportGap(c.config.Metrics, gap)
+
+	c.handlersLock.RLock()
+	var hooks []func(SequenceGap)
+	for _, fn := range c.gaps {
+		hooks = append(hooks, fn)
+	}
+	c.handlersLock.RUnlock()
+
+	for _, fn := range hooks {
+		fn(gap)
+	}
+}
+
+// subscribeEvents subscribes a new events connection to the subscribed
+// events, and to HEARTBEAT if the watchdog uses events
+func (c *Client) subscribeEvents(events *Socket) error {
+	c.lock.RLock()
+	subscriptions := append([]string(nil), c.subscriptions...)
+	c.lock.RUnlock()
+
+	heartbeat := c.config.Heartbeat
+	if heartbeat.Timeout > 0 && heartbeat.PollInterval == 0 {
+		subscriptions = append(subscriptions, HeartbeatEvent)
+	}
+
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	msg, err := events.Events(c.eventFormat(), subscriptions...)
+	if err != nil {
+		return err
+	}
+	return msg.Error()
+}
+
+// newWatchdog starts the watchdog of an events connection, that closes the
+// connection when it is dead. It returns nil if the watchdog is disabled.
+func (c *Client) newWatchdog(events *Socket) *Watchdog {
+	if c.config.Heartbeat.Timeout <= 0 {
+		return nil
+	}
+
+	return NewWatchdog(c.config.Heartbeat.Timeout, func() { events.Close() })
+}
+
+// beat passes a heartbeat to a watchdog
+func (c *Client) beat(watchdog *Watchdog, heartbeat Heartbeat) {
+	c.heartbeat.Store(&heartbeat)
+	watchdog.Beat(heartbeat)
+}
+
+// poll sends "api status" every poll interval until the client is closed,
+// and passes the replies to the current watchdog
+func (c *Client) poll() {
+	ticker := time.NewTicker(c.config.Heartbeat.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		heartbeat, err := c.Status()
+		if err == nil {
+			c.lock.RLock()
+			watchdog := c.watchdog
+			c.lock.RUnlock()
+
+			c.beat(watchdog, heartbeat)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// failback checks every failback interval whether a host that comes before
+// the active host is ready, and closes the events connection to move back to
+// it, until the client is closed
+func (c *Client) failback() {
+	ticker := time.NewTicker(c.config.Failback)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return
+		}
+
+		active := c.Host()
+		for _, host := range c.config.hosts() {
+			if host == active {
+				break
+			}
+
+			if c.ready(host) {
+				c.disconnect()
+				break
+			}
+		}
+	}
+}
+
+// ready returns true if a host can be connected and its status is ready
+func (c *Client) ready(host string) bool {
+	cfg := c.config.With(WithMaxRetries(0))
+	cfg.Host = host
+
+	socket, err := ConnectConfig(cfg)
+	if err != nil {
+		return false
+	}
+	defer socket.Close()
+
+	status, err := socket.Status()
+	return err == nil && status.Ready
+}
+
+// reconnectHosts returns the hosts to try after the active host was lost: the
+// other hosts in their order, and then the active host
+func (c *Client) reconnectHosts() []string {
+	active := c.Host()
+
+	var hosts []string
+	for _, host := range c.config.hosts() {
+		if host != active {
+			hosts = append(hosts, host)
+		}
+	}
+	return append(hosts, active)
+}
+
+// reconnect closes both connections, and connects them again until it
+// succeeds or the client is closed. It returns false if the client was
+// closed.
+func (c *Client) reconnect() bool {
+	c.lock.Lock()
+	if c.watchdog != nil {
+		c.watchdog.Stop()
+	}
+	c.commands.Close()
+	c.events.Close()
+	c.lock.Unlock()
+
+	bo := backoff.WithContext(c.config.Backoff.newReconnectBackOff(), c.ctx)
+	host, commands, events, err := c.connectHosts(c.reconnectHosts(), bo, true)
+	if err != nil {
+		return false
+	}
+
+	c.lock.Lock()
+	if c.ctx.Err() != nil {
+		c.lock.Unlock()
+		commands.Close()
+		events.Close()
+		return false
+	}
+	failover := host != c.host
+	c.host, c.commands, c.events = host, commands, events
+	c.watchdog = c.newWatchdog(events)
+	c.lock.Unlock()
+
+	if c.config.Metrics != nil {
+		c.config.Metrics.Reconnected()
+	}
+
+	c.handlersLock.RLock()
+	var failovers []func(string)
+	if failover {
+		for _, fn := range c.failovers {
+			failovers = append(failovers, fn)
+		}
+	}
+	var hooks []func()
+	for _, fn := range c.reconnected {
+		hooks = append(hooks, fn)
+	}
+	c.handlersLock.RUnlock()
+
+	for _, fn := range failovers {
+		fn(host)
+	}
+	for _, fn := range hooks {
+		fn()
+	}
+
+	return true
+}
@@ -0,0 +1,477 @@
+package esl
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeClientServer returns a fake server that echoes api commands, and
+// accepts every other command
+func newFakeClientServer(t *testing.T) *fakeServer {
+	return newFakeServer(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "api echo ") {
+			return fakeAPIResponse(strings.TrimPrefix(cmd, "api echo "))
+		}
+		return fakeCommandReply("+OK " + cmd)
+	})
+}
+
+// waitFor waits until cond is true
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := newFakeClientServer(t)
+
+	client, err := NewClient(NewConfig(server.Addr(), fakePassword, WithEventFormat(EOUTJSON)))
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	heartbeats := make(chan *Message, 1)
+	all := make(chan *Message, 2)
+	client.Handle("HEARTBEAT", func(event *Message) { heartbeats <- event })
+	client.Handle(AllEvents, func(event *Message) { all <- event })
+
+	err = client.Subscribe("HEARTBEAT", "CHANNEL_CREATE")
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err)
+	}
+
+	if server.Subscribers() != 1 {
+		t.Errorf("Expected a single events connection, got %d", server.Subscribers())
+	}
+
+	server.Publish(fakeEvent("Event-Name: HEARTBEAT", "Up-Time: 1"))
+	server.Publish(fakeEvent("Event-Name: CHANNEL_CREATE", "Unique-ID: 1234"))
+
+	select {
+	case event := <-heartbeats:
+		if event.Headers.GetString("Up-Time") != "1" {
+			t.Errorf("Unexpected event: %s", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The handler was not called")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-all:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The handler of all events was not called")
+		}
+	}
+
+	msg, err := client.API("echo", "hello")
+	if err != nil || string(msg.Body) != "hello" {
+		t.Errorf("Unexpected reply: %v, %v", msg, err)
+	}
+
+	found := false
+	for _, cmd := range server.Commands() {
+		if cmd == "event json HEARTBEAT CHANNEL_CREATE" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Events were not subscribed: %v", server.Commands())
+	}
+}
+
+func TestClientSlowHandler(t *testing.T) {
+	server := newFakeClientServer(t)
+
+	client, err := NewClient(NewConfig(server.Addr(), fakePassword))
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+
+	release := make(chan struct{})
+	client.Handle(AllEvents, func(*Message) { <-release })
+
+	err = client.Subscribe("ALL")
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		server.Publish(fakeEvent("Event-Name: HEARTBEAT"))
+	}
+
+	// The events connection is blocked by the handler, commands are not
+	start := time.Now()
+	msg, err := client.API("echo", "fast")
+	if err != nil || string(msg.Body) != "fast" {
+		t.Errorf("Unexpected reply: %v, %v", msg, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Command was delayed by events: %s", time.Since(start))
+	}
+
+	close(release)
+	client.Close()
+}
+
+func TestClientReconnect(t *testing.T) {
+	server := newFakeClientServer(t)
+	metrics := NewMetrics()
+
+	cfg := NewConfig(server.Addr(), fakePassword,
+		WithMetrics(metrics),
+		WithBackoff(BackoffConfig{InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond}),
+	)
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	reconnected := make(chan struct{}, 1)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+
+	events := make(chan *Message, 1)
+	client.Handle("CUSTOM", func(event *Message) { events <- event })
+
+	err = client.Subscribe("CUSTOM", "example::test")
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err)
+	}
+
+	before := client.Commands()
+	server.Drop()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not reconnect")
+	}
+
+	if client.Commands() == before {
+		t.Errorf("Commands connection was not replaced")
+	}
+
+	if metrics.Snapshot().Reconnects != 1 {
+		t.Errorf("Expected a single reconnect, got %d", metrics.Snapshot().Reconnects)
+	}
+
+	// Events are subscribed again
+	waitFor(t, "resubscribe", func() bool { return server.Subscribers() == 1 })
+	server.Publish(fakeEvent("Event-Name: CUSTOM", "Event-Subclass: example::test"))
+
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event was not delivered after reconnect")
+	}
+
+	msg, err := client.API("echo", "again")
+	if err != nil || string(msg.Body) != "again" {
+		t.Errorf("Unexpected reply: %v, %v", msg, err)
+	}
+}
+
+func TestClientSubscribeRejected(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if strings.Contains(cmd, "BAD") {
+			return fakeCommandReply("-ERR invalid event")
+		}
+		return fakeCommandReply("+OK " + cmd)
+	})
+
+	cfg := NewConfig(server.Addr(), fakePassword,
+		WithBackoff(BackoffConfig{InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond}),
+	)
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	err = client.Subscribe("BAD")
+	if err == nil {
+		t.Fatal("Expected the subscription to be rejected")
+	}
+
+	err = client.Subscribe("CHANNEL_CREATE")
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err)
+	}
+
+	reconnected := make(chan struct{}, 1)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+	server.Drop()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not reconnect")
+	}
+
+	// Only the accepted subscription is sent again
+	commands := server.Commands()
+	if last := commands[len(commands)-1]; last != "event plain CHANNEL_CREATE" {
+		t.Errorf("Unexpected subscription after reconnect: %v", commands)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	server := newFakeClientServer(t)
+
+	client, err := NewClient(NewConfig(server.Addr(), fakePassword))
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = client.Close()
+		if err != nil {
+			t.Errorf("Unexpected error (%d): %s", i, err)
+		}
+	}
+
+	select {
+	case <-client.Done():
+	default:
+		t.Error("Done is not closed")
+	}
+
+	_, err = client.API("echo", "closed")
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got: %v", err)
+	}
+
+	err = client.Subscribe("ALL")
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got: %v", err)
+	}
+}
+
+func TestClientSequenceGap(t *testing.T) {
+	server := newFakeClientServer(t)
+	metrics := NewMetrics()
+
+	client, err := NewClient(NewConfig(server.Addr(), fakePassword, WithMetrics(metrics)))
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	gaps := make(chan SequenceGap, 1)
+	client.OnGap(func(gap SequenceGap) { gaps <- gap })
+
+	received := make(chan struct{}, 10)
+	client.Handle(AllEvents, func(*Message) { received <- struct{}{} })
+
+	err = client.Subscribe("ALL")
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err)
+	}
+
+	for _, sequence := range []int{1, 2, 5, 6} {
+		server.Publish(fakeEvent("Event-Name: HEARTBEAT", "Core-UUID: core-1", "Event-Sequence: "+strconv.Itoa(sequence)))
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for event %d", i)
+		}
+	}
+
+	select {
+	case gap := <-gaps:
+		if gap.Expected != 3 || gap.Received != 5 || gap.Missed != 2 {
+			t.Errorf("Unexpected gap: %+v", gap)
+		}
+	default:
+		t.Fatal("Gap was not reported")
+	}
+
+	stats := client.SequenceStats()
+	if stats.Last != 6 || stats.Missed != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	if metrics.Snapshot().EventsMissed != 2 {
+		t.Errorf("Expected 2 missed events, got %d", metrics.Snapshot().EventsMissed)
+	}
+}
+
+// newFakeStatusServer returns a fake server that replies to "api status"
+// with a status that is ready if ready is true
+func newFakeStatusServer(t *testing.T, ready *atomic.Bool) *fakeServer {
+	return newFakeServer(t, func(cmd string) string {
+		switch cmd {
+		case "api status":
+			if ready.Load() {
+				return fakeAPIResponse(testStatus)
+			}
+			return fakeAPIResponse(strings.Replace(testStatus, "is ready", "is not ready", 1))
+		}
+		return fakeCommandReply("+OK " + cmd)
+	})
+}
+
+// failoverRecorder records the hosts of OnFailover
+type failoverRecorder struct {
+	lock  sync.Mutex
+	hosts []string
+}
+
+func (r *failoverRecorder) failover(host string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.hosts = append(r.hosts, host)
+}
+
+func (r *failoverRecorder) Hosts() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return strings.Join(r.hosts, " ")
+}
+
+var fastBackoff = WithBackoff(BackoffConfig{InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond})
+
+func TestClientFailover(t *testing.T) {
+	primary := newFakeClientServer(t)
+	standby := newFakeClientServer(t)
+
+	client, err := NewClient(NewConfig(primary.Addr(), fakePassword, WithStandby(standby.Addr()), fastBackoff))
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	recorder := &failoverRecorder{}
+	client.OnFailover(recorder.failover)
+
+	if client.Host() != primary.Addr() {
+		t.Errorf("Expected the primary host, got: %s", client.Host())
+	}
+
+	err = client.Subscribe("CHANNEL_CREATE")
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err)
+	}
+
+	primary.Close()
+
+	waitFor(t, "failover", func() bool { return client.Host() == standby.Addr() })
+	waitFor(t, "subscription", func() bool { return standby.Subscribers() == 1 })
+	if recorder.Hosts() != standby.Addr() {
+		t.Errorf("Unexpected failovers: %s", recorder.Hosts())
+	}
+
+	msg, err := client.API("echo", "standby")
+	if err != nil || string(msg.Body) != "standby" {
+		t.Errorf("Unexpected reply: %v, %v", msg, err)
+	}
+}
+
+func TestClientFailoverPrimaryDown(t *testing.T) {
+	standby := newFakeClientServer(t)
+
+	cfg := NewConfig("127.0.0.1:1", fakePassword, WithStandby(standby.Addr()))
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	if client.Host() != standby.Addr() {
+		t.Errorf("Expected the standby host, got: %s", client.Host())
+	}
+
+	// All the hosts are down
+	_, err = NewClient(NewConfig("127.0.0.1:1", fakePassword, WithStandby("127.0.0.1:2")))
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:1") || !strings.Contains(err.Error(), "127.0.0.1:2") {
+		t.Errorf("Expected the errors of both hosts, got: %v", err)
+	}
+
+	// A wrong password is not retried
+	start := time.Now()
+	_, err = NewClient(NewConfig(standby.Addr(), "wrong", WithMaxRetries(10)))
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied, got: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("A wrong password was retried: %s", time.Since(start))
+	}
+}
+
+func TestClientFailback(t *testing.T) {
+	var primaryReady, standbyReady atomic.Bool
+	standbyReady.Store(true)
+	primary := newFakeStatusServer(t, &primaryReady)
+	standby := newFakeStatusServer(t, &standbyReady)
+
+	cfg := NewConfig(primary.Addr(), fakePassword,
+		WithStandby(standby.Addr()),
+		WithFailback(20*time.Millisecond),
+		fastBackoff,
+	)
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	recorder := &failoverRecorder{}
+	client.OnFailover(recorder.failover)
+
+	// The primary still accepts connections, the host that was lost is tried
+	// last
+	primary.Drop()
+	waitFor(t, "failover", func() bool { return client.Host() == standby.Addr() })
+
+	// The primary is not ready, so the client stays at the standby
+	time.Sleep(100 * time.Millisecond)
+	if client.Host() != standby.Addr() {
+		t.Fatalf("Moved back to a host that is not ready")
+	}
+
+	primaryReady.Store(true)
+	waitFor(t, "failback", func() bool { return client.Host() == primary.Addr() })
+
+	if recorder.Hosts() != standby.Addr()+" "+primary.Addr() {
+		t.Errorf("Unexpected failovers: %s", recorder.Hosts())
+	}
+}
+
+func TestFailoverConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"host": "127.0.0.1", "standby": ["127.0.0.2", "127.0.0.3"], "failback": "1m"}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(cfg.hosts(), " ") != "127.0.0.1 127.0.0.2 127.0.0.3" || cfg.Failback != time.Minute {
+		t.Errorf("Unexpected config: %v %s", cfg.hosts(), cfg.Failback)
+	}
+
+	invalid := []Config{
+		NewConfig("127.0.0.1", fakePassword, WithStandby("")),
+		NewConfig("127.0.0.1", fakePassword, WithFailback(-time.Second)),
+	}
+	for _, cfg := range invalid {
+		if !errors.Is(cfg.Validate(), ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for %v %s", cfg.Standby, cfg.Failback)
+		}
+	}
+}
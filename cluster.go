@@ -0,0 +1,425 @@
+package esl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Current file contains a client of several Freeswitch servers.
+//
+// A Cluster holds a Client for every node, and merges their events, so a
+// handler receives the events of all the nodes together with the node that
+// fired them. Commands of a channel (uuid_* and execute) are sent to the node
+// that owns the channel, that is learned from "show channels" and from the
+// events, and new calls are spread between the nodes using a Strategy.
+
+// clusterEvents are the events that every node is subscribed to, in order to
+// learn which node owns a channel
+var clusterEvents = []string{"CHANNEL_CREATE", "CHANNEL_DESTROY"}
+
+// NodeEventHandler receives a parsed event and the node that fired it
+type NodeEventHandler func(node *Node, event *Message)
+
+// Node is a single server of a Cluster
+type Node struct {
+	// Name is the host of the node, as set at its config
+	Name   string
+	client *Client
+
+	lock     sync.RWMutex
+	hostname string
+	coreUUID string
+}
+
+// Client returns the client of the node, e.g. to send a command to a
+// specific node
+func (n *Node) Client() *Client {
+	return n.client
+}
+
+// Hostname returns the FreeSWITCH-Hostname of the node, as learned from its
+// events, or an empty string if no event arrived yet
+func (n *Node) Hostname() string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	return n.hostname
+}
+
+// CoreUUID returns the Core-UUID of the node, as learned from its events, or
+// an empty string if no event arrived yet
+func (n *Node) CoreUUID() string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	return n.coreUUID
+}
+
+// Connected returns true if the commands connection of the node is logged in
+func (n *Node) Connected() bool {
+	return n.client.Commands().State() == StateAuthenticated
+}
+
+// learn keeps the identity of the node from the headers of an event
+func (n *Node) learn(headers Headers) {
+	hostname := headers.GetString("FreeSWITCH-Hostname")
+	coreUUID := headers.GetString("Core-UUID")
+	if hostname == "" && coreUUID == "" {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if hostname != "" {
+		n.hostname = hostname
+	}
+	if coreUUID != "" {
+		n.coreUUID = coreUUID
+	}
+}
+
+// Cluster holds the clients of several Freeswitch nodes
+type Cluster struct {
+	nodes    []*Node
+	strategy Strategy
+
+	lock   sync.RWMutex
+	owners map[string]*Node
+	// syncs holds the changes of the channels of nodes that are bootstrapped
+	syncs map[*Node]*ownersSync
+}
+
+// ownersSync holds the channels of a node that were learned and destroyed
+// while it is bootstrapped, so they are kept when the result of the query
+// replaces its channels
+type ownersSync struct {
+	learned   map[string]struct{}
+	destroyed map[string]struct{}
+}
+
+// NewCluster creates a Client for every config, subscribes them to the
+// channel events that are used to learn the owners of channels, and learns the
+// channels that already exist. The channels of a node are learned again after
+// it was reconnected. strategy picks the node of new calls, RoundRobin is used
+// if it is nil.
+//
+// All the nodes must be connected, otherwise the connected nodes are closed
+// and the error is returned.
+func NewCluster(configs []Config, strategy Strategy) (*Cluster, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%w: missing nodes", ErrInvalidConfig)
+	}
+
+	if strategy == nil {
+		strategy = RoundRobin()
+	}
+
+	cluster := &Cluster{
+		strategy: strategy,
+		owners:   make(map[string]*Node),
+		syncs:    make(map[*Node]*ownersSync),
+	}
+
+	for _, cfg := range configs {
+		client, err := NewClient(cfg)
+		if err != nil {
+			cluster.Close()
+			return nil, fmt.Errorf("node %s: %w", cfg.Host, err)
+		}
+
+		node := &Node{Name: cfg.Host, client: client}
+		cluster.nodes = append(cluster.nodes, node)
+
+		client.Handle(AllEvents, func(event *Message) {
+			cluster.learn(node, event)
+		})
+		client.OnReconnect(func() {
+			cluster.bootstrap(node)
+		})
+	}
+
+	err := cluster.Subscribe(clusterEvents...)
+	if err != nil {
+		cluster.Close()
+		return nil, err
+	}
+
+	for _, node := range cluster.nodes {
+		err = cluster.bootstrap(node)
+		if err != nil {
+			cluster.Close()
+			return nil, fmt.Errorf("node %s: %w", node.Name, err)
+		}
+	}
+
+	return cluster, nil
+}
+
+// Nodes returns all the nodes of the cluster
+func (c *Cluster) Nodes() []*Node {
+	return append([]*Node(nil), c.nodes...)
+}
+
+// Node returns the node with the given name, or nil if it is not found
+func (c *Cluster) Node(name string) *Node {
+	for _, node := range c.nodes {
+		if node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes all the nodes to the given events. Every node is
+// subscribed even if another node failed.
+func (c *Cluster) Subscribe(events ...string) error {
+	var errs []error
+	for _, node := range c.nodes {
+		err := node.client.Subscribe(events...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", node.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Handle registers a handler for events with the given name of all the
+// nodes, or for every event using AllEvents (see Client.Handle). The events
+// of different nodes are not ordered. It returns a function that removes the
+// handler.
+func (c *Cluster) Handle(eventName string, handler NodeEventHandler) func() {
+	removes := make([]func(), 0, len(c.nodes))
+	for _, node := range c.nodes {
+		node := node
+		removes = append(removes, node.client.Handle(eventName, func(event *Message) {
+			handler(node, event)
+		}))
+	}
+
+	return func() {
+		for _, remove := range removes {
+			remove()
+		}
+	}
+}
+
+// learn keeps the identity of a node, and the channels that it owns
+func (c *Cluster) learn(node *Node, event *Message) {
+	node.learn(event.Headers)
+
+	uuid := event.Headers.GetString("Unique-ID")
+	if uuid == "" {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if event.Headers.GetString("Event-Name") == "CHANNEL_DESTROY" {
+		c.disown(node, uuid)
+		return
+	}
+	c.own(node, uuid)
+}
+
+// own sets the owner of a channel, the lock must be held
+func (c *Cluster) own(node *Node, uuid string) {
+	c.owners[uuid] = node
+
+	changes := c.syncs[node]
+	if changes != nil {
+		changes.learned[uuid] = struct{}{}
+		delete(changes.destroyed, uuid)
+	}
+}
+
+// disown forgets the owner of a destroyed channel, the lock must be held
+func (c *Cluster) disown(node *Node, uuid string) {
+	delete(c.owners, uuid)
+
+	changes := c.syncs[node]
+	if changes != nil {
+		changes.destroyed[uuid] = struct{}{}
+		delete(changes.learned, uuid)
+	}
+}
+
+// bootstrap replaces the channels of a node with the result of
+// "show channels as json". Channels that are learned from events (or
+// Originate) meanwhile are kept, and channels that are destroyed meanwhile
+// are not learned again from the result.
+func (c *Cluster) bootstrap(node *Node) error {
+	changes := &ownersSync{
+		learned:   make(map[string]struct{}),
+		destroyed: make(map[string]struct{}),
+	}
+
+	c.lock.Lock()
+	c.syncs[node] = changes
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		if c.syncs[node] == changes {
+			delete(c.syncs, node)
+		}
+		c.lock.Unlock()
+	}()
+
+	msg, err := node.client.API("show", "channels as json")
+	if err != nil {
+		return err
+	}
+	if msg.HasError() {
+		return msg.Error()
+	}
+
+	var result struct {
+		Rows []struct {
+			UUID string `json:"uuid"`
+		} `json:"rows"`
+	}
+	err = json.Unmarshal(msg.Body, &result)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	owners := make(map[string]*Node, len(c.owners))
+	for uuid, owner := range c.owners {
+		if _, learned := changes.learned[uuid]; owner != node || learned {
+			owners[uuid] = owner
+		}
+	}
+	for _, row := range result.Rows {
+		if _, destroyed := changes.destroyed[row.UUID]; row.UUID != "" && !destroyed {
+			owners[row.UUID] = node
+		}
+	}
+	c.owners = owners
+
+	return nil
+}
+
+// Owner returns the node that owns a channel, as learned from "show channels",
+// its events or from Originate
+func (c *Cluster) Owner(uuid string) (*Node, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	node, found := c.owners[uuid]
+	return node, found
+}
+
+// ownerNode returns the owner of a channel, or ErrUnknownUUID
+func (c *Cluster) ownerNode(uuid string) (*Node, error) {
+	node, found := c.Owner(uuid)
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownUUID, uuid)
+	}
+	return node, nil
+}
+
+// route returns the node of a command. uuid_* commands are sent to the owner
+// of the channel that is their first argument, other commands to the node
+// that is picked by the strategy.
+func (c *Cluster) route(cmd, args string) (*Node, error) {
+	if strings.HasPrefix(cmd, "uuid_") {
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%w: %s without uuid", ErrInvalidUUID, cmd)
+		}
+		return c.ownerNode(fields[0])
+	}
+
+	return c.Pick()
+}
+
+// Pick returns a connected node using the strategy of the cluster
+func (c *Cluster) Pick() (*Node, error) {
+	var nodes []*Node
+	for _, node := range c.nodes {
+		if node.Connected() {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNoNodeAvailable
+	}
+
+	return c.strategy.Pick(nodes)
+}
+
+// API sends an api command, uuid_* commands are sent to the owner of the
+// channel (see Owner) and other commands to the node that is picked by the
+// strategy
+func (c *Cluster) API(cmd, args string) (*Message, error) {
+	node, err := c.route(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	return node.client.API(cmd, args)
+}
+
+// BgAPI sends a bgapi command, routed like API
+func (c *Cluster) BgAPI(cmd, args string) (*Message, error) {
+	node, err := c.route(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	return node.client.BgAPI(cmd, args)
+}
+
+// Execute runs a dialplan application on a channel, at the node that owns it
+func (c *Cluster) Execute(uuid, app, arg string) (*Message, error) {
+	node, err := c.ownerNode(uuid)
+	if err != nil {
+		return nil, err
+	}
+	return node.client.Execute(uuid, app, arg)
+}
+
+// Originate sends "api originate" with the given arguments to the node that
+// is picked by the strategy, and returns the node and the uuid of the new
+// channel. The node becomes the owner of the channel.
+func (c *Cluster) Originate(args string) (*Node, string, error) {
+	node, err := c.Pick()
+	if err != nil {
+		return nil, "", err
+	}
+
+	msg, err := node.client.API("originate", args)
+	if err != nil {
+		return node, "", err
+	}
+	if msg.HasError() {
+		return node, "", msg.Error()
+	}
+
+	uuid := strings.TrimSpace(strings.TrimPrefix(string(msg.Body), "+OK"))
+	if uuid != "" {
+		c.lock.Lock()
+		c.own(node, uuid)
+		c.lock.Unlock()
+	}
+
+	return node, uuid, nil
+}
+
+// Close closes the clients of all the nodes
+func (c *Cluster) Close() error {
+	var errs []error
+	for _, node := range c.nodes {
+		err := node.client.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", node.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,329 @@
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// showChannels returns the result of "show channels as json" with the given
+// channels
+func showChannels(uuids ...string) string {
+	if len(uuids) == 0 {
+		return `{"row_count":0}`
+	}
+
+	rows := make([]string, 0, len(uuids))
+	for _, uuid := range uuids {
+		rows = append(rows, fmt.Sprintf(`{"uuid":%q,"direction":"inbound","state":"CS_EXECUTE"}`, uuid))
+	}
+	return fmt.Sprintf(`{"row_count":%d,"rows":[%s]}`, len(uuids), strings.Join(rows, ","))
+}
+
+// newFakeNode returns a fake server that replies to originate with the given
+// uuid, and accepts every other command
+func newFakeNode(t *testing.T, uuid string) *fakeServer {
+	return newFakeServer(t, func(cmd string) string {
+		switch {
+		case cmd == "api show channels as json":
+			return fakeAPIResponse(showChannels())
+		case strings.HasPrefix(cmd, "api originate "):
+			return fakeAPIResponse("+OK " + uuid + "\n")
+		case strings.HasPrefix(cmd, "api "):
+			return fakeAPIResponse("+OK\n")
+		}
+		return fakeCommandReply("+OK " + cmd)
+	})
+}
+
+// serverReceived returns true if the server received cmd
+func serverReceived(server *fakeServer, cmd string) bool {
+	for _, received := range server.Commands() {
+		if received == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCluster(t *testing.T) {
+	servers := []*fakeServer{newFakeNode(t, "uuid-1"), newFakeNode(t, "uuid-2")}
+
+	cluster, err := NewCluster([]Config{
+		NewConfig(servers[0].Addr(), fakePassword),
+		NewConfig(servers[1].Addr(), fakePassword),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Unable to create cluster: %s", err)
+	}
+	defer cluster.Close()
+
+	for _, server := range servers {
+		if !serverReceived(server, "event plain CHANNEL_CREATE CHANNEL_DESTROY") {
+			t.Errorf("Channel events were not subscribed: %v", server.Commands())
+		}
+	}
+
+	type nodeEvent struct {
+		node  *Node
+		event *Message
+	}
+	events := make(chan nodeEvent, 10)
+	remove := cluster.Handle("CHANNEL_CREATE", func(node *Node, event *Message) {
+		events <- nodeEvent{node: node, event: event}
+	})
+
+	servers[1].Publish(fakeEvent(
+		"Event-Name: CHANNEL_CREATE",
+		"FreeSWITCH-Hostname: fs2",
+		"Core-UUID: core-2",
+		"Unique-ID: channel-1",
+	))
+
+	select {
+	case received := <-events:
+		if received.node != cluster.Node(servers[1].Addr()) {
+			t.Errorf("Unexpected node: %s", received.node.Name)
+		}
+		if received.event.Headers.GetString("Unique-ID") != "channel-1" {
+			t.Errorf("Unexpected event: %s", received.event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The handler was not called")
+	}
+	remove()
+
+	second := cluster.Node(servers[1].Addr())
+	waitFor(t, "owner", func() bool {
+		owner, found := cluster.Owner("channel-1")
+		return found && owner == second
+	})
+	if second.Hostname() != "fs2" || second.CoreUUID() != "core-2" {
+		t.Errorf("Unexpected node identity: %s %s", second.Hostname(), second.CoreUUID())
+	}
+
+	// uuid_* commands are sent to the owner of the channel
+	_, err = cluster.API("uuid_kill", "channel-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !serverReceived(servers[1], "api uuid_kill channel-1") || serverReceived(servers[0], "api uuid_kill channel-1") {
+		t.Error("uuid_kill was not routed to the owner")
+	}
+
+	_, err = cluster.Execute("channel-1", "playback", "hello.wav")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	_, err = cluster.API("uuid_kill", "unknown")
+	if !errors.Is(err, ErrUnknownUUID) {
+		t.Errorf("Expected ErrUnknownUUID, got: %v", err)
+	}
+
+	_, err = cluster.API("uuid_kill", "")
+	if !errors.Is(err, ErrInvalidUUID) {
+		t.Errorf("Expected ErrInvalidUUID, got: %v", err)
+	}
+
+	servers[1].Publish(fakeEvent("Event-Name: CHANNEL_DESTROY", "Unique-ID: channel-1"))
+	waitFor(t, "destroy", func() bool {
+		_, found := cluster.Owner("channel-1")
+		return !found
+	})
+}
+
+func TestClusterOriginate(t *testing.T) {
+	servers := []*fakeServer{newFakeNode(t, "uuid-1"), newFakeNode(t, "uuid-2")}
+
+	cluster, err := NewCluster([]Config{
+		NewConfig(servers[0].Addr(), fakePassword),
+		NewConfig(servers[1].Addr(), fakePassword),
+	}, RoundRobin())
+	if err != nil {
+		t.Fatalf("Unable to create cluster: %s", err)
+	}
+	defer cluster.Close()
+
+	for i, expected := range []string{"uuid-1", "uuid-2", "uuid-1"} {
+		node, uuid, err := cluster.Originate("user/1000 &park")
+		if err != nil {
+			t.Fatalf("Unable to originate: %s", err)
+		}
+		if uuid != expected || node != cluster.Node(servers[i%2].Addr()) {
+			t.Errorf("Unexpected originate %d: %s %s", i, node.Name, uuid)
+		}
+
+		owner, found := cluster.Owner(uuid)
+		if !found || owner != node {
+			t.Errorf("Owner of %s was not learned", uuid)
+		}
+	}
+
+	// Disconnected nodes are not picked
+	cluster.Node(servers[0].Addr()).Client().Commands().Close()
+	servers[0].Close()
+	for i := 0; i < 2; i++ {
+		node, _, err := cluster.Originate("user/1000 &park")
+		if err != nil || node.Name != servers[1].Addr() {
+			t.Errorf("Unexpected node: %v %v", node, err)
+		}
+	}
+}
+
+func TestClusterErrors(t *testing.T) {
+	_, err := NewCluster(nil, nil)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got: %v", err)
+	}
+
+	server := newFakeNode(t, "uuid-1")
+	cfg := NewConfig(server.Addr(), fakePassword)
+	down := NewConfig("127.0.0.1:1", fakePassword, WithMaxRetries(0))
+
+	_, err = NewCluster([]Config{cfg, down}, nil)
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:1") {
+		t.Errorf("Expected an error of the node that is down, got: %v", err)
+	}
+
+	cluster, err := NewCluster([]Config{cfg}, StrategyFunc(func([]*Node) (*Node, error) {
+		return nil, ErrNoNodeAvailable
+	}))
+	if err != nil {
+		t.Fatalf("Unable to create cluster: %s", err)
+	}
+	defer cluster.Close()
+
+	_, _, err = cluster.Originate("user/1000 &park")
+	if !errors.Is(err, ErrNoNodeAvailable) {
+		t.Errorf("Expected ErrNoNodeAvailable, got: %v", err)
+	}
+}
+
+func TestClusterBootstrap(t *testing.T) {
+	var channels atomic.Value
+	channels.Store(showChannels("existing-1", "existing-2"))
+	server := newFakeServer(t, func(cmd string) string {
+		switch {
+		case cmd == "api show channels as json":
+			return fakeAPIResponse(channels.Load().(string))
+		case strings.HasPrefix(cmd, "api "):
+			return fakeAPIResponse("+OK\n")
+		}
+		return fakeCommandReply("+OK " + cmd)
+	})
+
+	cfg := NewConfig(server.Addr(), fakePassword,
+		WithBackoff(BackoffConfig{InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond}),
+	)
+	cluster, err := NewCluster([]Config{cfg}, nil)
+	if err != nil {
+		t.Fatalf("Unable to create cluster: %s", err)
+	}
+	defer cluster.Close()
+
+	// Channels that existed before the cluster was created are known
+	node := cluster.Node(server.Addr())
+	for _, uuid := range []string{"existing-1", "existing-2"} {
+		owner, found := cluster.Owner(uuid)
+		if !found || owner != node {
+			t.Errorf("Owner of %s was not learned", uuid)
+		}
+	}
+
+	_, err = cluster.API("uuid_kill", "existing-1")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// The channels of a node are learned again after a reconnect
+	channels.Store(showChannels("existing-2", "new-1"))
+	reconnected := make(chan struct{}, 1)
+	node.Client().OnReconnect(func() { reconnected <- struct{}{} })
+	server.Drop()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Node did not reconnect")
+	}
+
+	waitFor(t, "bootstrap", func() bool {
+		_, found := cluster.Owner("new-1")
+		return found
+	})
+	if _, found := cluster.Owner("existing-1"); found {
+		t.Error("A channel that is gone was kept")
+	}
+	if _, found := cluster.Owner("existing-2"); !found {
+		t.Error("A live channel was forgotten")
+	}
+}
+
+func TestClusterBootstrapEvents(t *testing.T) {
+	var block atomic.Bool
+	release := make(chan struct{})
+	server := newFakeServer(t, func(cmd string) string {
+		switch {
+		case cmd == "api show channels as json":
+			if block.Load() {
+				<-release
+			}
+			return fakeAPIResponse(showChannels("existing-1", "existing-2"))
+		case strings.HasPrefix(cmd, "api "):
+			return fakeAPIResponse("+OK\n")
+		}
+		return fakeCommandReply("+OK " + cmd)
+	})
+
+	cluster, err := NewCluster([]Config{NewConfig(server.Addr(), fakePassword)}, nil)
+	if err != nil {
+		t.Fatalf("Unable to create cluster: %s", err)
+	}
+	defer cluster.Close()
+
+	node := cluster.Node(server.Addr())
+	block.Store(true)
+	done := make(chan error, 1)
+	go func() { done <- cluster.bootstrap(node) }()
+	waitFor(t, "query", func() bool {
+		queries := 0
+		for _, cmd := range server.Commands() {
+			if cmd == "api show channels as json" {
+				queries++
+			}
+		}
+		return queries == 2
+	})
+
+	// The channels are known while the query runs, and the events that arrive
+	// meanwhile are not overridden by its result
+	server.Publish(fakeEvent("Event-Name: CHANNEL_DESTROY", "Unique-ID: existing-2"))
+	server.Publish(fakeEvent("Event-Name: CHANNEL_CREATE", "Unique-ID: new-1"))
+	waitFor(t, "events", func() bool {
+		_, found := cluster.Owner("new-1")
+		return found
+	})
+	if _, found := cluster.Owner("existing-1"); !found {
+		t.Error("A live channel was forgotten during the query")
+	}
+
+	close(release)
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("Unable to bootstrap: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Bootstrap did not return")
+	}
+
+	for uuid, expected := range map[string]bool{"existing-1": true, "existing-2": false, "new-1": true} {
+		if _, found := cluster.Owner(uuid); found != expected {
+			t.Errorf("Expected owner of %s to be found=%t", uuid, expected)
+		}
+	}
+}
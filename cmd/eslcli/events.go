@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ik5/esl"
+)
+
+var errEventConnectionClosed = errors.New("Event connection is closed")
+
+// eventStream is a dedicated connection for events and logs.
+//
+// Only run reads events from the connection, commands are sent using the
+// methods of the socket, that take their own replies.
+type eventStream struct {
+	socket *esl.Socket
+	out    io.Writer
+	done   chan struct{}
+}
+
+func newEventStream(socket *esl.Socket, out io.Writer) *eventStream {
+	return &eventStream{
+		socket: socket,
+		out:    out,
+		done:   make(chan struct{}),
+	}
+}
+
+// run reads messages until the connection is closed
+func (e *eventStream) run() {
+	defer close(e.done)
+
+	for {
+		msg, err := e.socket.ReadMessage()
+		if err != nil {
+			fmt.Fprintf(e.out, "\nEvent connection closed: %s\n", err)
+			return
+		}
+
+		switch msg.ContentType() {
+		case esl.ECLogData:
+			fmt.Fprintf(e.out, "\n%s\n", strings.TrimRight(string(msg.Body), "\n"))
+		case esl.ECTEventPlain, esl.ECTEventJSON, esl.ECTEventXML:
+			fmt.Fprintln(e.out)
+			err = printEvent(e.out, msg)
+			if err != nil {
+				fmt.Fprintf(e.out, "Unable to print event: %s\n", err)
+			}
+		case esl.ECTDisconnectNotice, esl.ECTRudeRejection:
+			fmt.Fprintf(e.out, "\nDisconnected: %s\n", msg.Body)
+			return
+		}
+	}
+}
+
+// command sends a command using send, unless the connection was closed
+func (e *eventStream) command(send func(socket *esl.Socket) (*esl.Message, error)) (*esl.Message, error) {
+	select {
+	case <-e.done:
+		return nil, errEventConnectionClosed
+	default:
+	}
+
+	return send(e.socket)
+}
+
+func (e *eventStream) close() {
+	e.socket.Close()
+	<-e.done
+}
+
+// printEvent pretty prints an event based on its content type
+func printEvent(w io.Writer, msg *esl.Message) error {
+	switch msg.ContentType() {
+	case esl.ECTEventJSON:
+		var buf bytes.Buffer
+		err := json.Indent(&buf, msg.Body, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, buf.String())
+	case esl.ECTEventXML:
+		fmt.Fprintln(w, strings.TrimRight(string(msg.Body), "\n"))
+	default:
+		event, err := esl.ParseEvent(msg)
+		if err != nil {
+			return err
+		}
+
+		keys := event.Headers.Keys()
+		sort.Strings(keys)
+
+		fmt.Fprintf(w, "[%s]\n", event.Headers.GetString("Event-Name"))
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s: %s\n", key, event.Headers.GetString(key))
+		}
+
+		if len(event.Body) > 0 {
+			fmt.Fprintf(w, "\n%s\n", event.Body)
+		}
+	}
+
+	return nil
+}
+
+// syncWriter allows events and command output to be written from different
+// goroutines
+type syncWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func newSyncWriter(w io.Writer) *syncWriter {
+	return &syncWriter{w: w}
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.w.Write(p)
+}
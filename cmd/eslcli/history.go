@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// history holds the executed commands, and keep them in a file between runs
+type history struct {
+	path  string
+	lines []string
+}
+
+// loadHistory loads the history from a file. An empty path keeps the history
+// only in memory.
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+
+	return h
+}
+
+// add a line to the history
+func (h *history) add(line string) {
+	if h == nil {
+		return
+	}
+
+	h.lines = append(h.lines, line)
+	if h.path == "" {
+		return
+	}
+
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	fmt.Fprintln(file, line)
+}
+
+// expand replace "!!" with the last command, and "!n" with command number n
+func (h *history) expand(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") {
+		return line, nil
+	}
+
+	if h == nil || len(h.lines) == 0 {
+		return "", fmt.Errorf("History is empty")
+	}
+
+	if line == "!!" {
+		return h.lines[len(h.lines)-1], nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(h.lines) {
+		return "", fmt.Errorf("No such history entry: %s", line)
+	}
+
+	return h.lines[n-1], nil
+}
+
+// list writes the history with its numbers
+func (h *history) list(w io.Writer) {
+	if h == nil {
+		return
+	}
+
+	for idx, line := range h.lines {
+		fmt.Fprintf(w, "%5d  %s\n", idx+1, line)
+	}
+}
@@ -0,0 +1,92 @@
+// eslcli is an interactive command line client for Freeswitch ESL, similar
+// to fs_cli.
+//
+// Usage:
+//
+//	eslcli [-host host:port] [-password password] [-format plain|json|xml] [-x "command"]
+//
+// The host and password can also be provided using the ESLHOST and
+// ESLPASSWORD environment variables.
+//
+// Every line that does not start with "/" is sent as an api command. Type
+// /help inside the client for the list of client commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ik5/esl"
+)
+
+const (
+	defaultRetries uint64        = 3
+	defaultTimeout time.Duration = 10 * time.Second
+	historyFile                  = ".eslcli_history"
+)
+
+func main() {
+	host := flag.String("host", os.Getenv("ESLHOST"), "ESL host[:port] (ESLHOST)")
+	password := flag.String("password", os.Getenv("ESLPASSWORD"), "ESL password (ESLPASSWORD)")
+	retries := flag.Uint64("retries", defaultRetries, "Number of connection retries")
+	timeout := flag.Duration("timeout", defaultTimeout, "Connection timeout")
+	format := flag.String("format", string(esl.EOTPlain), "Event format: plain, json or xml")
+	execute := flag.String("x", "", "Execute a single command and exit")
+	historyPath := flag.String("history", defaultHistoryPath(), "History file (empty to disable)")
+	flag.Parse()
+
+	outputType, err := parseFormat(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	socket, err := esl.Connect(*host, *password, *retries, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to connect to %s: %s\n", *host, err)
+		os.Exit(1)
+	}
+	defer socket.Close()
+
+	c := &cli{
+		host:     *host,
+		password: *password,
+		retries:  *retries,
+		timeout:  *timeout,
+		format:   outputType,
+		socket:   socket,
+		out:      newSyncWriter(os.Stdout),
+	}
+
+	if *execute != "" {
+		_, err = c.execute(*execute)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	c.history = loadHistory(*historyPath)
+	c.repl(os.Stdin)
+	c.close()
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFile)
+}
+
+func parseFormat(format string) (esl.EventOutputType, error) {
+	switch esl.EventOutputType(format) {
+	case esl.EOTPlain, esl.EOUTJSON, esl.EOUTXML:
+		return esl.EventOutputType(format), nil
+	}
+	return "", fmt.Errorf("Unknown format: %s (expected plain, json or xml)", format)
+}
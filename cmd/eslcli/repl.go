@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ik5/esl"
+)
+
+const helpText = `Commands:
+  <command> [args]                 Execute an api command
+  /api <command> [args]            Execute an api command
+  /bgapi <command> [args]          Execute a background api command
+  /event [plain|json|xml] <events> Subscribe to events (e.g. /event ALL)
+  /noevents                        Stop all events
+  /filter <header> <value>         Add an event filter
+  /filter delete <header> [value]  Remove an event filter
+  /format plain|json|xml           Output format of the next subscription
+  /log <level>                     Show logs from the given level (0-7 or name)
+  /nolog                           Stop showing logs
+  /history                         Show the history (!n or !! to repeat)
+  /help                            Show this help
+  /quit, /exit, /bye               Exit
+`
+
+// errUnknownCommand is returned for commands that starts with "/" and are not
+// known
+var errUnknownCommand = errors.New("Unknown command, type /help for the list of commands")
+
+// cli holds the state of the client
+type cli struct {
+	host     string
+	password string
+	retries  uint64
+	timeout  time.Duration
+	format   esl.EventOutputType
+
+	socket  *esl.Socket
+	events  *eventStream
+	history *history
+	out     io.Writer
+}
+
+// repl reads commands until EOF or a quit command
+func (c *cli) repl(input io.Reader) {
+	scanner := bufio.NewScanner(input)
+
+	for {
+		fmt.Fprintf(c.out, "freeswitch@%s> ", c.host)
+		if !scanner.Scan() {
+			fmt.Fprintln(c.out)
+			return
+		}
+
+		line, err := c.history.expand(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			fmt.Fprintln(c.out, err)
+			continue
+		}
+
+		if line == "" {
+			continue
+		}
+		c.history.add(line)
+
+		quit, err := c.execute(line)
+		if err != nil {
+			fmt.Fprintf(c.out, "-ERR %s\n", err)
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+// execute a single line, and returns true if the client should quit
+func (c *cli) execute(line string) (bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false, nil
+	}
+
+	if !strings.HasPrefix(line, "/") {
+		return false, c.api(line)
+	}
+
+	name, args := splitCommand(line)
+
+	switch name {
+	case "/quit", "/exit", "/bye":
+		return true, nil
+	case "/help":
+		fmt.Fprint(c.out, helpText)
+	case "/api":
+		return false, c.api(args)
+	case "/bgapi":
+		return false, c.bgapi(args)
+	case "/event":
+		return false, c.subscribe(args)
+	case "/noevents":
+		return false, c.eventCommand((*esl.Socket).NoEvents)
+	case "/filter":
+		return false, c.filter(args)
+	case "/format":
+		format, err := parseFormat(args)
+		if err != nil {
+			return false, err
+		}
+		c.format = format
+	case "/log":
+		if args == "" {
+			args = "debug"
+		}
+		return false, c.eventCommand(func(socket *esl.Socket) (*esl.Message, error) {
+			return socket.Log(args)
+		})
+	case "/nolog":
+		return false, c.eventCommand((*esl.Socket).NoLog)
+	case "/history":
+		c.history.list(c.out)
+	default:
+		return false, errUnknownCommand
+	}
+
+	return false, nil
+}
+
+func (c *cli) api(line string) error {
+	cmd, args := splitCommand(line)
+	if cmd == "" {
+		return errors.New("Missing api command")
+	}
+
+	msg, err := c.socket.API(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if msg.HasError() {
+		return msg.Error()
+	}
+
+	fmt.Fprintln(c.out, strings.TrimRight(string(msg.Body), "\n"))
+	return nil
+}
+
+func (c *cli) bgapi(line string) error {
+	cmd, args := splitCommand(line)
+	if cmd == "" {
+		return errors.New("Missing bgapi command")
+	}
+
+	msg, err := c.socket.BgAPI(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if msg.HasError() {
+		return msg.Error()
+	}
+
+	fmt.Fprintln(c.out, msg.Headers.GetString("Reply-Text"))
+	return nil
+}
+
+// subscribe to events, the format can be given as the first argument
+func (c *cli) subscribe(args string) error {
+	format := c.format
+	first, rest := splitCommand(args)
+	if f, err := parseFormat(first); err == nil {
+		format = f
+		args = rest
+	}
+
+	events := strings.Fields(args)
+	if len(events) == 0 {
+		return errors.New("Missing events to subscribe")
+	}
+
+	return c.eventCommand(func(socket *esl.Socket) (*esl.Message, error) {
+		return socket.Events(format, events...)
+	})
+}
+
+func (c *cli) filter(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return errors.New("Usage: /filter <header> <value> or /filter delete <header> [value]")
+	}
+
+	return c.eventCommand(func(socket *esl.Socket) (*esl.Message, error) {
+		if fields[0] == "delete" {
+			return socket.FilterDelete(fields[1], strings.Join(fields[2:], " "))
+		}
+		return socket.Filter(fields[0], strings.Join(fields[1:], " "))
+	})
+}
+
+// eventCommand sends a command to the event connection, and open it if needed
+func (c *cli) eventCommand(send func(socket *esl.Socket) (*esl.Message, error)) error {
+	if c.events == nil {
+		socket, err := c.connectEvents()
+		if err != nil {
+			return err
+		}
+
+		c.events = newEventStream(socket, c.out)
+		go c.events.run()
+	}
+
+	msg, err := c.events.command(send)
+	if err != nil {
+		return err
+	}
+
+	if msg.HasError() {
+		return msg.Error()
+	}
+
+	fmt.Fprintln(c.out, msg.Headers.GetString("Reply-Text"))
+	return nil
+}
+
+// connectEvents opens the event connection, commands that are not replied
+// after the timeout fail
+func (c *cli) connectEvents() (*esl.Socket, error) {
+	host := c.host
+	if host == "" {
+		host = esl.DefaultHost
+	}
+
+	return esl.ConnectConfig(esl.NewConfig(host, c.password,
+		esl.WithDialTimeout(c.timeout),
+		esl.WithReadTimeout(c.timeout),
+		esl.WithKeepAlive(c.timeout),
+		esl.WithBackoff(esl.BackoffConfig{MaxRetries: c.retries, MaxElapsedTime: c.timeout}),
+	))
+}
+
+func (c *cli) close() {
+	if c.events != nil {
+		c.events.close()
+	}
+}
+
+// splitCommand splits the first word from the rest of the line
+func splitCommand(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	idx := strings.IndexAny(line, " \t")
+	if idx < 0 {
+		return line, ""
+	}
+	return line[:idx], strings.TrimSpace(line[idx+1:])
+}
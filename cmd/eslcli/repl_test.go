@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ik5/esl"
+)
+
+func TestSplitCommand(t *testing.T) {
+	type fixture struct {
+		input string
+		cmd   string
+		args  string
+	}
+
+	fixtures := []fixture{
+		{input: "status", cmd: "status", args: ""},
+		{input: "  show channels as json ", cmd: "show", args: "channels as json"},
+		{input: "/event\tplain ALL", cmd: "/event", args: "plain ALL"},
+		{input: "", cmd: "", args: ""},
+	}
+
+	for idx, test := range fixtures {
+		cmd, args := splitCommand(test.input)
+		if cmd != test.cmd || args != test.args {
+			t.Errorf("Expected (%d) '%s' '%s', got '%s' '%s'", idx, test.cmd, test.args, cmd, args)
+		}
+	}
+}
+
+func TestHistoryExpand(t *testing.T) {
+	h := loadHistory("")
+	h.add("status")
+	h.add("show calls")
+
+	line, err := h.expand("!!")
+	if err != nil || line != "show calls" {
+		t.Errorf("Expected 'show calls', got '%s' (%v)", line, err)
+	}
+
+	line, err = h.expand("!1")
+	if err != nil || line != "status" {
+		t.Errorf("Expected 'status', got '%s' (%v)", line, err)
+	}
+
+	_, err = h.expand("!3")
+	if err == nil {
+		t.Errorf("Expected error for missing history entry")
+	}
+
+	line, err = h.expand("version")
+	if err != nil || line != "version" {
+		t.Errorf("Expected 'version', got '%s' (%v)", line, err)
+	}
+
+	var out bytes.Buffer
+	h.list(&out)
+	if !strings.Contains(out.String(), "2  show calls") {
+		t.Errorf("Unexpected history list: %s", out.String())
+	}
+}
+
+func TestPrintEventPlain(t *testing.T) {
+	body := "Event-Name: HEARTBEAT\nUp-Time: 0%20years\n\n"
+	input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+
+	msg, err := esl.NewMessage([]byte(input), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	var out bytes.Buffer
+	err = printEvent(&out, msg)
+	if err != nil {
+		t.Errorf("Unable to print event: %s", err)
+		return
+	}
+
+	expected := "[HEARTBEAT]\nEvent-Name: HEARTBEAT\nUp-Time: 0 years\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestPrintEventJSON(t *testing.T) {
+	body := `{"Event-Name":"HEARTBEAT"}`
+	input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-json\n\n%s", len(body), body)
+
+	msg, err := esl.NewMessage([]byte(input), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	var out bytes.Buffer
+	err = printEvent(&out, msg)
+	if err != nil {
+		t.Errorf("Unable to print event: %s", err)
+		return
+	}
+
+	expected := "{\n  \"Event-Name\": \"HEARTBEAT\"\n}\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestExecuteLocalCommands(t *testing.T) {
+	var out bytes.Buffer
+	c := &cli{
+		format:  esl.EOTPlain,
+		history: loadHistory(""),
+		out:     &out,
+	}
+
+	quit, err := c.execute("/format json")
+	if err != nil || quit || c.format != esl.EOUTJSON {
+		t.Errorf("Unexpected result of /format: %t %v %s", quit, err, c.format)
+	}
+
+	_, err = c.execute("/format yaml")
+	if err == nil {
+		t.Errorf("Expected error for unknown format")
+	}
+
+	_, err = c.execute("/unknown")
+	if !errors.Is(err, errUnknownCommand) {
+		t.Errorf("Expected errUnknownCommand, got: %v", err)
+	}
+
+	quit, err = c.execute("/quit")
+	if err != nil || !quit {
+		t.Errorf("Expected /quit to quit: %t %v", quit, err)
+	}
+}
+
+// fakeESL accepts a single connection, and accepts every command
+func fakeESL(t *testing.T) (string, func() []string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var lock sync.Mutex
+	var commands []string
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "Content-Type: auth/request\n\n")
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			if line == "" {
+				continue
+			}
+
+			lock.Lock()
+			commands = append(commands, line)
+			lock.Unlock()
+
+			reply := "+OK"
+			if strings.HasPrefix(line, "auth ") {
+				reply = "+OK accepted"
+			}
+			fmt.Fprintf(conn, "Content-Type: command/reply\nReply-Text: %s\n\n", reply)
+		}
+	}()
+
+	return listener.Addr().String(), func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return append([]string(nil), commands...)
+	}
+}
+
+func TestExecuteEventCommands(t *testing.T) {
+	host, commands := fakeESL(t)
+
+	var out bytes.Buffer
+	c := &cli{
+		host:     host,
+		password: "ClueCon",
+		timeout:  5 * time.Second,
+		format:   esl.EOTPlain,
+		out:      newSyncWriter(&out),
+	}
+	defer c.close()
+
+	lines := []string{
+		"/event json CHANNEL_CREATE CHANNEL_ANSWER",
+		"/filter Unique-ID abc",
+		"/filter delete Unique-ID abc",
+		"/log debug",
+		"/nolog",
+		"/noevents",
+	}
+	for _, line := range lines {
+		_, err := c.execute(line)
+		if err != nil {
+			t.Fatalf("Unable to execute %q: %s", line, err)
+		}
+	}
+
+	expected := []string{
+		"auth ClueCon",
+		"event json CHANNEL_CREATE CHANNEL_ANSWER",
+		"filter Unique-ID abc",
+		"filter delete Unique-ID abc",
+		"log debug",
+		"nolog",
+		"noevents",
+	}
+	if fmt.Sprint(commands()) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected commands:\n%q\nexpected:\n%q", commands(), expected)
+	}
+
+	_, err := c.execute("/event")
+	if err == nil {
+		t.Errorf("Expected error for missing events")
+	}
+}
@@ -0,0 +1,128 @@
+package esl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Current file contains the validation and encoding of command arguments.
+//
+// A command ends with an empty line, so an argument with an embedded EOL can
+// smuggle another command into the socket (e.g. "uuid\n\napi system ...").
+// Every argument that is sent by the package is validated, and values that
+// arrive from users should be passed using QuotedArg.
+
+// ArgumentError is returned when an argument of a command is rejected.
+// It matches ErrUnsafeArgument using errors.Is.
+type ArgumentError struct {
+	// Command is the command (or its first word) that the argument belongs to
+	Command string
+	// Arg is the rejected argument
+	Arg    string
+	Reason string
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("%s: %s: %q (%s)", ErrUnsafeArgument, e.Command, e.Arg, e.Reason)
+}
+
+// Unwrap returns ErrUnsafeArgument
+func (e *ArgumentError) Unwrap() error {
+	return ErrUnsafeArgument
+}
+
+// validateArgs rejects arguments that contain CR or LF
+func validateArgs(command string, args ...string) error {
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return &ArgumentError{Command: command, Arg: arg, Reason: "contains CR/LF"}
+		}
+	}
+	return nil
+}
+
+// Arg is a single argument of a command, see RawArg and QuotedArg
+type Arg struct {
+	value  string
+	quoted bool
+}
+
+// RawArg is an argument that is sent as is. It is rejected if it contains CR
+// or LF.
+func RawArg(value string) Arg {
+	return Arg{value: value}
+}
+
+// QuotedArg is an argument that is always sent as a single argument, as
+// Freeswitch splits the arguments of most commands: a value with whitespace,
+// quotes or backslashes (or an empty value) is wrapped with single quotes, and
+// its single quotes and backslashes are escaped with a backslash. Other values
+// are sent as is. It is rejected if it contains CR or LF.
+func QuotedArg(value string) Arg {
+	return Arg{value: value, quoted: true}
+}
+
+func (a Arg) String() string {
+	if !a.quoted {
+		return a.value
+	}
+	return quoteArg(a.value)
+}
+
+var argEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+)
+
+// quoteArg wraps a value with single quotes when it is needed in order to
+// send it as a single argument
+func quoteArg(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t'\"\\") {
+		return value
+	}
+	return "'" + argEscaper.Replace(value) + "'"
+}
+
+// BuildCommand joins a command and its arguments into a single command line,
+// and returns ArgumentError if any of them is unsafe.
+func BuildCommand(cmd string, args ...Arg) (string, error) {
+	err := validateArgs(cmd, cmd)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, cmd)
+
+	for _, arg := range args {
+		err = validateArgs(cmd, arg.value)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, arg.String())
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// APIArgs sends an api command, built from safe arguments
+func (s *Socket) APIArgs(cmd string, args ...Arg) (*Message, error) {
+	line, err := BuildCommand(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, msg, err := s.sendCommand("api " + line)
+	return msg, err
+}
+
+// BgAPIArgs sends a bgapi command, built from safe arguments
+func (s *Socket) BgAPIArgs(cmd string, args ...Arg) (*Message, error) {
+	line, err := BuildCommand(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, msg, err := s.sendCommand("bgapi " + line)
+	return msg, err
+}
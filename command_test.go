@@ -0,0 +1,155 @@
+package esl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBuildCommand(t *testing.T) {
+	line, err := BuildCommand("uuid_setvar", RawArg("a-leg"), QuotedArg("foo"), QuotedArg(`it's a "value" \ with`))
+	if err != nil {
+		t.Errorf("Unable to build command: %s", err)
+	}
+
+	expected := `uuid_setvar a-leg foo 'it\'s a "value" \\ with'`
+	if line != expected {
+		t.Errorf("Expected %s, got: %s", expected, line)
+	}
+
+	line, err = BuildCommand("uuid_setvar", RawArg("a-leg"), QuotedArg("foo"), QuotedArg(""))
+	if err != nil || line != "uuid_setvar a-leg foo ''" {
+		t.Errorf("Unexpected command of an empty value: %s %v", line, err)
+	}
+
+	_, err = BuildCommand("uuid_setvar", RawArg("a-leg"), RawArg("foo"), QuotedArg("multi\nline"))
+	if !errors.Is(err, ErrUnsafeArgument) {
+		t.Errorf("Expected ErrUnsafeArgument of a quoted EOL, got: %v", err)
+	}
+
+	_, err = BuildCommand("uuid_kill", RawArg("a-leg\n\napi system rm -rf"))
+	var argErr *ArgumentError
+	if !errors.Is(err, ErrUnsafeArgument) || !errors.As(err, &argErr) {
+		t.Errorf("Expected ArgumentError, got: %v", err)
+		return
+	}
+
+	if argErr.Command != "uuid_kill" || argErr.Arg != "a-leg\n\napi system rm -rf" {
+		t.Errorf("Unexpected error: %+v", argErr)
+	}
+
+	_, err = BuildCommand("status\r\n")
+	if !errors.Is(err, ErrUnsafeArgument) {
+		t.Errorf("Expected ErrUnsafeArgument, got: %v", err)
+	}
+}
+
+func TestSocketRejectsInjection(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeAPIResponse("+OK")
+	})
+	socket := connectFakeServer(t, server)
+
+	injected := "a-leg\n\napi system rm -rf /"
+
+	calls := map[string]func() error{
+		"API": func() error {
+			_, err := socket.API("uuid_kill", injected)
+			return err
+		},
+		"BgAPI": func() error {
+			_, err := socket.BgAPI("uuid_kill\n", "a-leg")
+			return err
+		},
+		"Filter": func() error {
+			_, err := socket.Filter("Unique-ID", injected)
+			return err
+		},
+		"FilterDelete": func() error {
+			_, err := socket.FilterDelete("Unique-ID", injected)
+			return err
+		},
+		"SendCommands": func() error {
+			_, _, err := socket.SendCommands("api\r", "status", "")
+			return err
+		},
+		"Events": func() error {
+			_, err := socket.Events(EOTPlain, "CHANNEL_CREATE\n\napi status")
+			return err
+		},
+		"SetVar": func() error {
+			_, err := socket.SetVar("a-leg", "foo", "bar\nbaz")
+			return err
+		},
+		"SendEvent": func() error {
+			headers := NewHeaders()
+			headers.Add("Foo", "bar\n\napi status")
+			_, err := socket.SendEvent("CUSTOM", headers, "")
+			return err
+		},
+		"Execute": func() error {
+			_, err := socket.Execute(injected, "playback", "")
+			return err
+		},
+		"APIArgs": func() error {
+			_, err := socket.APIArgs("uuid_kill", RawArg(injected))
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		err := call()
+		if !errors.Is(err, ErrUnsafeArgument) {
+			t.Errorf("%s: expected ErrUnsafeArgument, got: %v", name, err)
+		}
+	}
+
+	err := socket.Send("api status\n\napi system rm -rf /")
+	if !errors.Is(err, ErrCmdEOL) {
+		t.Errorf("Expected ErrCmdEOL, got: %v", err)
+	}
+
+	if commands := server.Commands(); len(commands) != 0 {
+		t.Errorf("Expected nothing to be sent, got: %q", commands)
+	}
+}
+
+func TestSocketSafeArguments(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "sendmsg") || strings.HasPrefix(cmd, "sendevent") {
+			return fakeCommandReply("+OK")
+		}
+		return fakeAPIResponse("+OK")
+	})
+	socket := connectFakeServer(t, server)
+
+	_, err := socket.APIArgs("uuid_setvar", RawArg("a-leg"), RawArg("greeting"), QuotedArg("hello world"))
+	if err != nil {
+		t.Errorf("Unable to send api: %s", err)
+	}
+
+	_, err = socket.Execute("a-leg", "playback", "/tmp/hello.wav")
+	if err != nil {
+		t.Errorf("Unable to execute: %s", err)
+	}
+
+	// Multi line arguments are sent in the body
+	_, err = socket.Execute("a-leg", "set", "foo=bar\n\napi status")
+	if err != nil {
+		t.Errorf("Unable to execute: %s", err)
+	}
+
+	commands := server.Commands()
+	expected := []string{
+		"api uuid_setvar a-leg greeting 'hello world'",
+		"sendmsg a-leg\ncall-command: execute\nexecute-app-name: playback\nexecute-app-arg: /tmp/hello.wav",
+		"sendmsg a-leg\ncall-command: execute\nexecute-app-name: set\ncontent-type: text/plain\nContent-Length: 19\n\nfoo=bar\n\napi status",
+	}
+
+	for idx, cmd := range expected {
+		if idx >= len(commands) || commands[idx] != cmd {
+			t.Errorf("Expected command %q, got: %q", cmd, commands)
+			return
+		}
+	}
+}
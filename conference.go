@@ -0,0 +1,335 @@
+package esl
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Current file contains the support for mod_conference commands and events,
+// built on top of the API command.
+
+// ConferenceMaintenanceEvent is the subclass of CUSTOM events that are fired
+// by mod_conference
+const ConferenceMaintenanceEvent = "conference::maintenance"
+
+// Special member targets that can be used instead of a member id
+const (
+	ConferenceAllMembers    = "all"
+	ConferenceLastMember    = "last"
+	ConferenceNonModerators = "non_moderator"
+)
+
+// Member flags as returned by the list command
+const (
+	ConferenceFlagHear       = "hear"
+	ConferenceFlagSpeak      = "speak"
+	ConferenceFlagTalking    = "talking"
+	ConferenceFlagVideo      = "video"
+	ConferenceFlagFloor      = "floor"
+	ConferenceFlagMuteDetect = "mute_detect"
+	ConferenceFlagModerator  = "moderator"
+)
+
+// ConferenceAction is the Action header of conference::maintenance events
+type ConferenceAction string
+
+// Known conference actions
+const (
+	ConferenceActionAddMember    ConferenceAction = "add-member"
+	ConferenceActionDelMember    ConferenceAction = "del-member"
+	ConferenceActionStartTalking ConferenceAction = "start-talking"
+	ConferenceActionStopTalking  ConferenceAction = "stop-talking"
+	ConferenceActionDTMF         ConferenceAction = "dtmf"
+)
+
+// ConferenceMember holds information about a member of a conference
+type ConferenceMember struct {
+	ID             int64
+	Channel        string
+	UUID           string
+	CallerIDName   string
+	CallerIDNumber string
+	Type           string
+	Flags          []string
+	VolumeIn       int64
+	VolumeOut      int64
+	EnergyLevel    int64
+}
+
+// HasFlag returns true if the member has a given flag
+func (m ConferenceMember) HasFlag(flag string) bool {
+	for _, f := range m.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// ConferenceEvent is a decoded conference::maintenance event
+type ConferenceEvent struct {
+	Action         ConferenceAction
+	Conference     string
+	ConferenceUUID string
+	ConferenceSize int64
+	Member         ConferenceMember
+
+	// DTMFKey is set only on dtmf actions
+	DTMFKey string
+
+	// Headers holds all the headers of the event
+	Headers Headers
+}
+
+// IsJoin returns true if a member joined the conference
+func (e ConferenceEvent) IsJoin() bool {
+	return e.Action == ConferenceActionAddMember
+}
+
+// IsLeave returns true if a member left the conference
+func (e ConferenceEvent) IsLeave() bool {
+	return e.Action == ConferenceActionDelMember
+}
+
+// IsTalking returns true if a member started or stopped talking
+func (e ConferenceEvent) IsTalking() bool {
+	return e.Action == ConferenceActionStartTalking || e.Action == ConferenceActionStopTalking
+}
+
+// IsDTMF returns true if a member pressed a DTMF key
+func (e ConferenceEvent) IsDTMF() bool {
+	return e.Action == ConferenceActionDTMF
+}
+
+// Conference execute mod_conference commands on a given conference
+type Conference struct {
+	socket *Socket
+	name   string
+}
+
+// NewConference creates a new Conference handle for the conference name
+func NewConference(socket *Socket, name string) *Conference {
+	return &Conference{
+		socket: socket,
+		name:   name,
+	}
+}
+
+// Name returns the name of the conference
+func (c *Conference) Name() string {
+	return c.name
+}
+
+// List returns the members of the conference
+func (c *Conference) List() ([]ConferenceMember, error) {
+	body, err := c.command(RawArg("list"))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConferenceList(body), nil
+}
+
+// Mute mutes a member (id, "all", "last" or "non_moderator")
+func (c *Conference) Mute(member string) error {
+	_, err := c.command(RawArg("mute"), QuotedArg(member))
+	return err
+}
+
+// Unmute unmutes a member (id, "all", "last" or "non_moderator")
+func (c *Conference) Unmute(member string) error {
+	_, err := c.command(RawArg("unmute"), QuotedArg(member))
+	return err
+}
+
+// Deaf makes a member deaf (id, "all", "last" or "non_moderator")
+func (c *Conference) Deaf(member string) error {
+	_, err := c.command(RawArg("deaf"), QuotedArg(member))
+	return err
+}
+
+// Undeaf makes a member hear again (id, "all", "last" or "non_moderator")
+func (c *Conference) Undeaf(member string) error {
+	_, err := c.command(RawArg("undeaf"), QuotedArg(member))
+	return err
+}
+
+// Kick removes a member from the conference (id, "all" or "last")
+func (c *Conference) Kick(member string) error {
+	_, err := c.command(RawArg("kick"), QuotedArg(member))
+	return err
+}
+
+// Play plays a file to the conference.
+// If member is not empty, the file is played only to the given member.
+func (c *Conference) Play(file string, member string) error {
+	args := []Arg{RawArg("play"), QuotedArg(file)}
+	if member != "" {
+		args = append(args, QuotedArg(member))
+	}
+	_, err := c.command(args...)
+	return err
+}
+
+// Record starts recording the conference into a file
+func (c *Conference) Record(file string) error {
+	_, err := c.command(RawArg("record"), QuotedArg(file))
+	return err
+}
+
+// StopRecording stops recording into a file, or "all" of the recordings
+func (c *Conference) StopRecording(file string) error {
+	_, err := c.command(RawArg("recording"), RawArg("stop"), QuotedArg(file))
+	return err
+}
+
+// Lock locks the conference, so new members cannot join
+func (c *Conference) Lock() error {
+	_, err := c.command(RawArg("lock"))
+	return err
+}
+
+// Unlock unlocks the conference
+func (c *Conference) Unlock() error {
+	_, err := c.command(RawArg("unlock"))
+	return err
+}
+
+// Floor gives the floor to a member
+func (c *Conference) Floor(member string) error {
+	_, err := c.command(RawArg("floor"), QuotedArg(member))
+	return err
+}
+
+// VideoFloor gives the video floor to a member
+func (c *Conference) VideoFloor(member string) error {
+	_, err := c.command(RawArg("vid-floor"), QuotedArg(member))
+	return err
+}
+
+// command runs "conference <name>" with the given arguments
+func (c *Conference) command(args ...Arg) (string, error) {
+	body, err := c.socket.apiArgsBody("conference", append([]Arg{QuotedArg(c.name)}, args...)...)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.HasPrefix(body, "Conference ") && strings.Contains(body, "not found"):
+		return "", fmt.Errorf("%w: %s", ErrConferenceNotFound, c.name)
+	case strings.HasPrefix(body, "Non-Existant ID"), strings.HasPrefix(body, "Non-Existent ID"):
+		return "", fmt.Errorf("%w: %s", ErrConferenceMemberNotFound, strings.TrimSpace(body))
+	}
+
+	return body, nil
+}
+
+// ConferenceEventFromMessage decodes conference::maintenance CUSTOM event.
+func ConferenceEventFromMessage(msg *Message) (*ConferenceEvent, error) {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = isCustomEvent(headers, ConferenceMaintenanceEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	member := ConferenceMember{
+		ID:             headers.GetInt("Member-ID"),
+		Channel:        headers.GetString("Channel-Name"),
+		UUID:           headers.GetString("Unique-ID"),
+		CallerIDName:   headers.GetString("Caller-Caller-ID-Name"),
+		CallerIDNumber: headers.GetString("Caller-Caller-ID-Number"),
+		Type:           headers.GetString("Member-Type"),
+		EnergyLevel:    headers.GetInt("Energy-Level"),
+	}
+
+	flags := []struct {
+		header string
+		flag   string
+	}{
+		{"Hear", ConferenceFlagHear},
+		{"Speak", ConferenceFlagSpeak},
+		{"Talking", ConferenceFlagTalking},
+		{"Video", ConferenceFlagVideo},
+		{"Floor", ConferenceFlagFloor},
+		{"Mute-Detect", ConferenceFlagMuteDetect},
+	}
+	for _, f := range flags {
+		if headers.GetString(f.header) == "true" {
+			member.Flags = append(member.Flags, f.flag)
+		}
+	}
+	if member.Type == ConferenceFlagModerator {
+		member.Flags = append(member.Flags, ConferenceFlagModerator)
+	}
+
+	event := ConferenceEvent{
+		Action:         ConferenceAction(headers.GetString("Action")),
+		Conference:     headers.GetString("Conference-Name"),
+		ConferenceUUID: headers.GetString("Conference-Unique-ID"),
+		ConferenceSize: headers.GetInt("Conference-Size"),
+		Member:         member,
+		DTMFKey:        headers.GetString("DTMF-Key"),
+		Headers:        headers,
+	}
+
+	return &event, nil
+}
+
+// parseConferenceList parse the ";" delimited list of members:
+//
+//	id;channel;uuid;caller id name;caller id number;flags;volume in;volume out;energy
+func parseConferenceList(body string) []ConferenceMember {
+	var members []ConferenceMember
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "+OK") {
+			continue
+		}
+
+		fields := strings.Split(line, ";")
+		if len(fields) < 6 {
+			continue
+		}
+
+		id, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		member := ConferenceMember{
+			ID:             id,
+			Channel:        fields[1],
+			UUID:           fields[2],
+			CallerIDName:   fields[3],
+			CallerIDNumber: fields[4],
+		}
+
+		for _, flag := range strings.Split(fields[5], "|") {
+			if flag != "" {
+				member.Flags = append(member.Flags, flag)
+			}
+		}
+
+		if len(fields) > 6 {
+			member.VolumeIn = parseInt(fields[6])
+		}
+		if len(fields) > 7 {
+			member.VolumeOut = parseInt(fields[7])
+		}
+		if len(fields) > 8 {
+			member.EnergyLevel = parseInt(fields[8])
+		}
+
+		members = append(members, member)
+	}
+
+	return members
+}
@@ -0,0 +1,154 @@
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const conferenceListFixture = `1;sofia/internal/1000@10.0.0.5;0fa1b2c3-aaaa-bbbb-cccc-000000000001;Alice;1000;hear|speak|talking|floor;0;0;100
+2;sofia/internal/1001@10.0.0.5;0fa1b2c3-aaaa-bbbb-cccc-000000000002;Bob;1001;hear;-1;2;300
+`
+
+func TestParseConferenceList(t *testing.T) {
+	members := parseConferenceList(conferenceListFixture)
+	if len(members) != 2 {
+		t.Errorf("Expected 2 members, got %d: %+v", len(members), members)
+		return
+	}
+
+	alice := members[0]
+	if alice.ID != 1 || alice.CallerIDName != "Alice" || alice.CallerIDNumber != "1000" {
+		t.Errorf("Unexpected member: %+v", alice)
+	}
+
+	if !alice.HasFlag(ConferenceFlagFloor) || !alice.HasFlag(ConferenceFlagTalking) {
+		t.Errorf("Expected floor and talking flags, got %v", alice.Flags)
+	}
+
+	bob := members[1]
+	if bob.HasFlag(ConferenceFlagSpeak) {
+		t.Errorf("Bob is not expected to speak: %v", bob.Flags)
+	}
+
+	if bob.VolumeIn != -1 || bob.VolumeOut != 2 || bob.EnergyLevel != 300 {
+		t.Errorf("Unexpected levels: %+v", bob)
+	}
+}
+
+func TestConferenceCommands(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		switch cmd {
+		case "api conference 3000 list":
+			return fakeAPIResponse(conferenceListFixture)
+		case "api conference 3000 mute 1":
+			return fakeAPIResponse("+OK mute 1\n")
+		case "api conference 3000 kick 9":
+			return fakeAPIResponse("Non-Existant ID 9\n")
+		case "api conference 3000 lock":
+			return fakeAPIResponse("OK 3000 locked\n")
+		case "api conference 4000 list":
+			return fakeAPIResponse("Conference 4000 not found\n")
+		}
+		return fakeAPIResponse("-ERR unknown command\n")
+	})
+
+	socket := connectFakeServer(t, server)
+	conference := NewConference(socket, "3000")
+
+	members, err := conference.List()
+	if err != nil || len(members) != 2 {
+		t.Errorf("Unexpected members: %+v, %v", members, err)
+	}
+
+	err = conference.Mute("1")
+	if err != nil {
+		t.Errorf("Unexpected mute error: %s", err)
+	}
+
+	err = conference.Lock()
+	if err != nil {
+		t.Errorf("Unexpected lock error: %s", err)
+	}
+
+	err = conference.Kick("9")
+	if !errors.Is(err, ErrConferenceMemberNotFound) {
+		t.Errorf("Expected ErrConferenceMemberNotFound, got: %v", err)
+	}
+
+	err = conference.Floor("1")
+	if err == nil {
+		t.Errorf("Expected error for unknown command")
+	}
+
+	_, err = NewConference(socket, "4000").List()
+	if !errors.Is(err, ErrConferenceNotFound) {
+		t.Errorf("Expected ErrConferenceNotFound, got: %v", err)
+	}
+
+	// A value with spaces is a single argument
+	conference.Play("/tmp/a b.wav", "all kick")
+	commands := server.Commands()
+	if last := commands[len(commands)-1]; last != "api conference 3000 play '/tmp/a b.wav' 'all kick'" {
+		t.Errorf("Unexpected command: %s", last)
+	}
+}
+
+func TestConferenceEventFromMessage(t *testing.T) {
+	type fixture struct {
+		action  string
+		extra   string
+		join    bool
+		leave   bool
+		talking bool
+		dtmf    bool
+	}
+
+	fixtures := []fixture{
+		{action: "add-member", join: true},
+		{action: "del-member", leave: true},
+		{action: "start-talking", extra: "Talking: true\n", talking: true},
+		{action: "dtmf", extra: "DTMF-Key: 5\n", dtmf: true},
+	}
+
+	for idx, test := range fixtures {
+		body := "Event-Name: CUSTOM\nEvent-Subclass: conference%3A%3Amaintenance\n" +
+			"Conference-Name: 3000\nConference-Size: 2\nMember-ID: 7\n" +
+			"Caller-Caller-ID-Name: Alice\nHear: true\nSpeak: true\n" +
+			"Action: " + test.action + "\n" + test.extra + "\n"
+		input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+
+		msg, err := NewMessage([]byte(input), true)
+		if err != nil {
+			t.Errorf("Unable to parse message (%d): %s", idx, err)
+			continue
+		}
+
+		event, err := ConferenceEventFromMessage(msg)
+		if err != nil {
+			t.Errorf("Unable to decode event (%d): %s", idx, err)
+			continue
+		}
+
+		if event.Conference != "3000" || event.ConferenceSize != 2 || event.Member.ID != 7 {
+			t.Errorf("Unexpected event (%d): %+v", idx, event)
+		}
+
+		if event.IsJoin() != test.join || event.IsLeave() != test.leave ||
+			event.IsTalking() != test.talking || event.IsDTMF() != test.dtmf {
+			t.Errorf("Unexpected action (%d): %s", idx, event.Action)
+		}
+
+		if !event.Member.HasFlag(ConferenceFlagHear) || !event.Member.HasFlag(ConferenceFlagSpeak) {
+			t.Errorf("Expected hear and speak flags (%d): %v", idx, event.Member.Flags)
+		}
+
+		if test.talking && !event.Member.HasFlag(ConferenceFlagTalking) {
+			t.Errorf("Expected talking flag (%d): %v", idx, event.Member.Flags)
+		}
+
+		if test.dtmf && event.DTMFKey != "5" {
+			t.Errorf("Expected DTMF key of 5 (%d), got '%s'", idx, event.DTMFKey)
+		}
+	}
+}
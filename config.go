@@ -0,0 +1,560 @@
+package esl
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Current file contains the configuration of a connection.
+//
+// A Config is created using NewConfig and options, loaded from the
+// environment (ConfigFromEnv) or from a JSON file (LoadConfig), and is used by
+// DialConfig and ConnectConfig:
+//
+//	cfg := esl.NewConfig("127.0.0.1", "ClueCon",
+//		esl.WithDialTimeout(5*time.Second),
+//		esl.WithReadTimeout(30*time.Second),
+//	)
+//	socket, err := esl.ConnectConfig(cfg)
+
+// Default values of a Config
+const (
+	DefaultDialTimeout = 10 * time.Second
+	DefaultKeepAlive   = 30 * time.Second
+	DefaultBufferSize  = 4096
+)
+
+// Dialer opens the connection to the server, net.Dialer implements it
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// BackoffConfig is the exponential backoff policy of dialing. Zero values use
+// the defaults of backoff.ExponentialBackOff.
+type BackoffConfig struct {
+	// MaxRetries is the number of retries after the first attempt failed
+	MaxRetries uint64
+	// InitialInterval is the wait after the first failure
+	InitialInterval time.Duration
+	// MaxInterval is the max wait between two attempts
+	MaxInterval time.Duration
+	// Multiplier of the interval after every attempt
+	Multiplier float64
+	// MaxElapsedTime stops retrying after the given time, 0 retries forever
+	// (up to MaxRetries)
+	MaxElapsedTime time.Duration
+}
+
+// newBackOff creates the backoff policy of the config
+func (b BackoffConfig) newBackOff() backoff.BackOff {
+	exp := b.exponential()
+	exp.MaxElapsedTime = b.MaxElapsedTime
+	exp.Reset()
+
+	return backoff.WithMaxRetries(exp, b.MaxRetries)
+}
+
+// newReconnectBackOff creates the backoff policy of reconnecting, that uses
+// the same intervals but never stops retrying
+func (b BackoffConfig) newReconnectBackOff() backoff.BackOff {
+	exp := b.exponential()
+	exp.MaxElapsedTime = 0
+	exp.Reset()
+
+	return exp
+}
+
+// exponential returns an exponential backoff with the intervals of the config
+func (b BackoffConfig) exponential() *backoff.ExponentialBackOff {
+	exp := backoff.NewExponentialBackOff()
+	if b.InitialInterval > 0 {
+		exp.InitialInterval = b.InitialInterval
+	}
+	if b.MaxInterval > 0 {
+		exp.MaxInterval = b.MaxInterval
+	}
+	if b.Multiplier > 0 {
+		exp.Multiplier = b.Multiplier
+	}
+
+	return exp
+}
+
+// Config holds the settings of a connection
+type Config struct {
+	// Host is host[:port], the default port is 8021
+	Host     string
+	Password string
+	// Standby are hosts that a Client fails over to, in order, when Host is
+	// not available. They use the same password.
+	Standby []string
+	// Failback is the interval of checking whether a host that comes before
+	// the active host (at Host and Standby) is available again, so a Client
+	// moves back to it. 0 disables failing back.
+	Failback time.Duration
+
+	// DialTimeout is the timeout of a single dial attempt
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period, a negative value disables it
+	KeepAlive time.Duration
+	// ReadTimeout is the max wait for the reply of a command, 0 waits forever.
+	// Events that are read using ReadMessage are not limited.
+	ReadTimeout time.Duration
+	// WriteTimeout is the max time of writing a command, 0 waits forever
+	WriteTimeout time.Duration
+	Backoff      BackoffConfig
+
+	// Dialer replaces the default net.Dialer
+	Dialer Dialer
+	// TLS enables TLS (e.g. when ESL is behind a TLS proxy) if not nil
+	TLS *tls.Config
+
+	Observer Observer
+	Metrics  MetricsCollector
+	Tracer   Tracer
+
+	ReadBufferSize  int
+	WriteBufferSize int
+	// MaxFrameSize is the max size of a single frame, see
+	// Parser.SetMaxFrameSize
+	MaxFrameSize int
+
+	// EventFormat is the format of events that are subscribed using
+	// Socket.Subscribe
+	EventFormat EventOutputType
+	// EventQueue is the queue of the event handlers of a Client
+	EventQueue QueueConfig
+	// Heartbeat is the liveness watchdog of a Client
+	Heartbeat HeartbeatConfig
+}
+
+// Option changes a Config
+type Option func(*Config)
+
+// NewConfig creates a Config with the default values, and applies the options
+func NewConfig(host, password string, opts ...Option) Config {
+	cfg := Config{
+		Host:            host,
+		Password:        password,
+		DialTimeout:     DefaultDialTimeout,
+		KeepAlive:       DefaultKeepAlive,
+		ReadBufferSize:  DefaultBufferSize,
+		WriteBufferSize: DefaultBufferSize,
+		MaxFrameSize:    DefaultMaxFrameSize,
+		EventFormat:     EOTPlain,
+		EventQueue:      QueueConfig{Size: DefaultQueueSize, Policy: QueueBlock},
+	}
+
+	return cfg.With(opts...)
+}
+
+// With returns a copy of the config with the options applied
+func (c Config) With(opts ...Option) Config {
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithStandby sets the hosts that a Client fails over to
+func WithStandby(hosts ...string) Option {
+	return func(c *Config) { c.Standby = hosts }
+}
+
+// WithFailback sets the interval of checking whether a Client can move back
+// to a preferred host
+func WithFailback(interval time.Duration) Option {
+	return func(c *Config) { c.Failback = interval }
+}
+
+// WithDialTimeout sets the timeout of a single dial attempt
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Config) { c.DialTimeout = timeout }
+}
+
+// WithKeepAlive sets the TCP keep-alive period, a negative value disables it
+func WithKeepAlive(period time.Duration) Option {
+	return func(c *Config) { c.KeepAlive = period }
+}
+
+// WithReadTimeout sets the max wait for the reply of a command
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *Config) { c.ReadTimeout = timeout }
+}
+
+// WithWriteTimeout sets the max time of writing a command
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c *Config) { c.WriteTimeout = timeout }
+}
+
+// WithBackoff sets the backoff policy of dialing
+func WithBackoff(policy BackoffConfig) Option {
+	return func(c *Config) { c.Backoff = policy }
+}
+
+// WithMaxRetries sets the number of dial retries
+func WithMaxRetries(retries uint64) Option {
+	return func(c *Config) { c.Backoff.MaxRetries = retries }
+}
+
+// WithDialer sets a custom dialer
+func WithDialer(dialer Dialer) Option {
+	return func(c *Config) { c.Dialer = dialer }
+}
+
+// WithTLS connects using TLS
+func WithTLS(config *tls.Config) Option {
+	return func(c *Config) { c.TLS = config }
+}
+
+// WithObserver attaches an observer to the socket
+func WithObserver(observer Observer) Option {
+	return func(c *Config) { c.Observer = observer }
+}
+
+// WithMetrics attaches a metrics collector to the socket
+func WithMetrics(metrics MetricsCollector) Option {
+	return func(c *Config) { c.Metrics = metrics }
+}
+
+// WithTracer attaches a tracer to the socket
+func WithTracer(tracer Tracer) Option {
+	return func(c *Config) { c.Tracer = tracer }
+}
+
+// WithBufferSizes sets the sizes of the read and write buffers
+func WithBufferSizes(read, write int) Option {
+	return func(c *Config) {
+		c.ReadBufferSize = read
+		c.WriteBufferSize = write
+	}
+}
+
+// WithMaxFrameSize sets the max size of a single frame
+func WithMaxFrameSize(size int) Option {
+	return func(c *Config) { c.MaxFrameSize = size }
+}
+
+// WithEventFormat sets the format of subscribed events
+func WithEventFormat(format EventOutputType) Option {
+	return func(c *Config) { c.EventFormat = format }
+}
+
+// WithEventQueue sets the queue of the event handlers of a Client
+func WithEventQueue(queue QueueConfig) Option {
+	return func(c *Config) { c.EventQueue = queue }
+}
+
+// WithHeartbeat sets the liveness watchdog of a Client
+func WithHeartbeat(heartbeat HeartbeatConfig) Option {
+	return func(c *Config) { c.Heartbeat = heartbeat }
+}
+
+// Validate returns an error if the config can not be used by a Client.
+// DialConfig validates only the settings of a single connection, so the
+// settings of a Client (such as the standby hosts, the event queue and the
+// heartbeat) do not have to be set.
+func (c Config) Validate() error {
+	err := c.validateSocket()
+	if err != nil {
+		return err
+	}
+
+	for _, host := range c.Standby {
+		if host == "" {
+			return fmt.Errorf("%w: empty standby host", ErrInvalidConfig)
+		}
+	}
+	if c.Failback < 0 {
+		return fmt.Errorf("%w: failback interval must not be negative", ErrInvalidConfig)
+	}
+
+	err = c.EventQueue.validate()
+	if err != nil {
+		return err
+	}
+
+	return c.Heartbeat.validate()
+}
+
+// validateSocket returns an error if the config can not be used by a Socket
+func (c Config) validateSocket() error {
+	if c.Host == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidConfig)
+	}
+
+	switch c.EventFormat {
+	case EOTPlain, EOUTJSON, EOUTXML:
+	default:
+		return fmt.Errorf("%w: unknown event format %q", ErrInvalidConfig, c.EventFormat)
+	}
+
+	if c.ReadBufferSize <= 0 || c.WriteBufferSize <= 0 || c.MaxFrameSize <= 0 {
+		return fmt.Errorf("%w: buffer sizes must be positive", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// hosts returns Host and the standby hosts, in order
+func (c Config) hosts() []string {
+	return append([]string{c.Host}, c.Standby...)
+}
+
+// dialer returns the dialer of the config
+func (c Config) dialer() Dialer {
+	var dialer Dialer = c.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.KeepAlive}
+	}
+
+	if c.TLS != nil {
+		return tlsDialer{dialer: dialer, config: c.TLS}
+	}
+
+	return dialer
+}
+
+// tlsDialer wraps a connection of another dialer with TLS
+type tlsDialer struct {
+	dialer Dialer
+	config *tls.Config
+}
+
+func (d tlsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	config := d.config.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil {
+			config.ServerName = host
+		}
+	}
+
+	tlsConn := tls.Client(conn, config)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// Environment variables that are used by ConfigFromEnv
+const (
+	EnvHost     = "ESLHOST"
+	EnvPassword = "ESLPASSWORD"
+)
+
+// ConfigFromEnv creates a Config from ESLHOST and ESLPASSWORD, and applies the
+// options.
+func ConfigFromEnv(opts ...Option) (Config, error) {
+	cfg := NewConfig(os.Getenv(EnvHost), os.Getenv(EnvPassword), opts...)
+
+	err := cfg.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// Duration is a time.Duration that is written in JSON as a string (e.g.
+// "1m30s") or as nanoseconds
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+		}
+		*d = Duration(duration)
+	default:
+		return fmt.Errorf("%w: invalid duration %s", ErrInvalidConfig, data)
+	}
+
+	return nil
+}
+
+// fileConfig is the structure of a config file
+type fileConfig struct {
+	Host            string          `json:"host"`
+	Password        string          `json:"password"`
+	Standby         []string        `json:"standby"`
+	Failback        Duration        `json:"failback"`
+	DialTimeout     *Duration       `json:"dial_timeout"`
+	KeepAlive       *Duration       `json:"keep_alive"`
+	ReadTimeout     Duration        `json:"read_timeout"`
+	WriteTimeout    Duration        `json:"write_timeout"`
+	Backoff         fileBackoff     `json:"backoff"`
+	ReadBufferSize  int             `json:"read_buffer_size"`
+	WriteBufferSize int             `json:"write_buffer_size"`
+	MaxFrameSize    int             `json:"max_frame_size"`
+	EventFormat     EventOutputType `json:"event_format"`
+	EventQueue      *fileQueue      `json:"event_queue"`
+	Heartbeat       fileHeartbeat   `json:"heartbeat"`
+	TLS             *fileTLS        `json:"tls"`
+}
+
+type fileHeartbeat struct {
+	Timeout      Duration `json:"timeout"`
+	PollInterval Duration `json:"poll_interval"`
+}
+
+type fileQueue struct {
+	Size          int         `json:"size"`
+	Policy        QueuePolicy `json:"policy"`
+	HighWatermark int         `json:"high_watermark"`
+}
+
+type fileBackoff struct {
+	MaxRetries      uint64   `json:"max_retries"`
+	InitialInterval Duration `json:"initial_interval"`
+	MaxInterval     Duration `json:"max_interval"`
+	Multiplier      float64  `json:"multiplier"`
+	MaxElapsedTime  Duration `json:"max_elapsed_time"`
+}
+
+type fileTLS struct {
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// LoadConfig reads a Config from a JSON file, and applies the options.
+// Fields that are missing keep their default value, durations are written as
+// strings (e.g. "10s"):
+//
+//	{
+//		"host": "127.0.0.1:8021",
+//		"standby": ["127.0.0.2:8021"],
+//		"password": "ClueCon",
+//		"dial_timeout": "5s",
+//		"read_timeout": "30s",
+//		"backoff": {"max_retries": 3, "max_elapsed_time": "1m"},
+//		"event_format": "json",
+//		"event_queue": {"size": 1000, "policy": "drop-oldest", "high_watermark": 800},
+//		"heartbeat": {"timeout": "1m"}
+//	}
+//
+// An empty password is taken from ESLPASSWORD, so it does not have to be
+// stored in the file.
+//
+// Only JSON files are supported, a YAML file (.yaml or .yml) is rejected, so
+// the package does not depend on a YAML parser.
+func LoadConfig(path string, opts ...Option) (Config, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return Config{}, fmt.Errorf("%w: %s: only JSON config files are supported", ErrInvalidConfig, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return ParseConfig(data, opts...)
+}
+
+// ParseConfig parses a Config from JSON, see LoadConfig
+func ParseConfig(data []byte, opts ...Option) (Config, error) {
+	var file fileConfig
+	err := json.Unmarshal(data, &file)
+	if err != nil {
+		return Config{}, fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+
+	password := file.Password
+	if password == "" {
+		password = os.Getenv(EnvPassword)
+	}
+
+	cfg := NewConfig(file.Host, password)
+	cfg.Standby = file.Standby
+	cfg.Failback = time.Duration(file.Failback)
+	if file.DialTimeout != nil {
+		cfg.DialTimeout = time.Duration(*file.DialTimeout)
+	}
+	if file.KeepAlive != nil {
+		cfg.KeepAlive = time.Duration(*file.KeepAlive)
+	}
+	cfg.ReadTimeout = time.Duration(file.ReadTimeout)
+	cfg.WriteTimeout = time.Duration(file.WriteTimeout)
+	cfg.Backoff = BackoffConfig{
+		MaxRetries:      file.Backoff.MaxRetries,
+		InitialInterval: time.Duration(file.Backoff.InitialInterval),
+		MaxInterval:     time.Duration(file.Backoff.MaxInterval),
+		Multiplier:      file.Backoff.Multiplier,
+		MaxElapsedTime:  time.Duration(file.Backoff.MaxElapsedTime),
+	}
+	if file.ReadBufferSize > 0 {
+		cfg.ReadBufferSize = file.ReadBufferSize
+	}
+	if file.WriteBufferSize > 0 {
+		cfg.WriteBufferSize = file.WriteBufferSize
+	}
+	if file.MaxFrameSize > 0 {
+		cfg.MaxFrameSize = file.MaxFrameSize
+	}
+	if file.EventFormat != "" {
+		cfg.EventFormat = file.EventFormat
+	}
+	if file.EventQueue != nil {
+		cfg.EventQueue = QueueConfig{
+			Size:          file.EventQueue.Size,
+			Policy:        file.EventQueue.Policy,
+			HighWatermark: file.EventQueue.HighWatermark,
+		}
+		if cfg.EventQueue.Size == 0 {
+			cfg.EventQueue.Size = DefaultQueueSize
+		}
+	}
+	cfg.Heartbeat = HeartbeatConfig{
+		Timeout:      time.Duration(file.Heartbeat.Timeout),
+		PollInterval: time.Duration(file.Heartbeat.PollInterval),
+	}
+	if file.TLS != nil {
+		cfg.TLS = &tls.Config{
+			ServerName:         file.TLS.ServerName,
+			InsecureSkipVerify: file.TLS.InsecureSkipVerify,
+		}
+	}
+
+	cfg = cfg.With(opts...)
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,333 @@
+package esl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewConfig(t *testing.T) {
+	cfg := NewConfig("127.0.0.1", "ClueCon")
+	if cfg.DialTimeout != DefaultDialTimeout || cfg.KeepAlive != DefaultKeepAlive ||
+		cfg.MaxFrameSize != DefaultMaxFrameSize || cfg.EventFormat != EOTPlain {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+
+	cfg = NewConfig("127.0.0.1", "ClueCon",
+		WithDialTimeout(time.Second),
+		WithKeepAlive(time.Minute),
+		WithReadTimeout(2*time.Second),
+		WithWriteTimeout(3*time.Second),
+		WithMaxRetries(5),
+		WithBufferSizes(1024, 2048),
+		WithMaxFrameSize(4096),
+		WithEventFormat(EOUTJSON),
+	)
+	if cfg.DialTimeout != time.Second || cfg.KeepAlive != time.Minute ||
+		cfg.ReadTimeout != 2*time.Second || cfg.WriteTimeout != 3*time.Second ||
+		cfg.Backoff.MaxRetries != 5 || cfg.ReadBufferSize != 1024 ||
+		cfg.WriteBufferSize != 2048 || cfg.MaxFrameSize != 4096 || cfg.EventFormat != EOUTJSON {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	err := cfg.Validate()
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	configs := []Config{
+		NewConfig("", "ClueCon"),
+		NewConfig("127.0.0.1", "ClueCon", WithEventFormat("yaml")),
+		NewConfig("127.0.0.1", "ClueCon", WithBufferSizes(0, 10)),
+		NewConfig("127.0.0.1", "ClueCon", WithMaxFrameSize(-1)),
+	}
+
+	for idx, cfg := range configs {
+		err := cfg.Validate()
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig (%d), got: %v", idx, err)
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv(EnvHost, "freeswitch.example.com")
+	t.Setenv(EnvPassword, "secret")
+
+	cfg, err := ConfigFromEnv(WithMaxRetries(2))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if cfg.Host != "freeswitch.example.com" || cfg.Password != "secret" || cfg.Backoff.MaxRetries != 2 {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	t.Setenv(EnvHost, "")
+	_, err = ConfigFromEnv()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got: %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv(EnvPassword, "from-env")
+
+	path := filepath.Join(t.TempDir(), "esl.json")
+	data := `{
+		"host": "127.0.0.1:8022",
+		"dial_timeout": "5s",
+		"keep_alive": "-1s",
+		"read_timeout": 1000000000,
+		"backoff": {"max_retries": 3, "initial_interval": "100ms", "max_elapsed_time": "1m"},
+		"max_frame_size": 1024,
+		"event_format": "xml",
+		"tls": {"server_name": "fs.example.com"}
+	}`
+	err := os.WriteFile(path, []byte(data), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path, WithWriteTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := BackoffConfig{MaxRetries: 3, InitialInterval: 100 * time.Millisecond, MaxElapsedTime: time.Minute}
+	if cfg.Host != "127.0.0.1:8022" || cfg.Password != "from-env" ||
+		cfg.DialTimeout != 5*time.Second || cfg.KeepAlive != -time.Second ||
+		cfg.ReadTimeout != time.Second || cfg.WriteTimeout != time.Second ||
+		cfg.Backoff != expected || cfg.MaxFrameSize != 1024 ||
+		cfg.ReadBufferSize != DefaultBufferSize || cfg.EventFormat != EOUTXML ||
+		cfg.TLS == nil || cfg.TLS.ServerName != "fs.example.com" {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	invalid := []string{
+		`{"host": "127.0.0.1", "dial_timeout": "5 seconds"}`,
+		`{"host": "127.0.0.1", "read_timeout": true}`,
+		`{"host": 1}`,
+		`{"password": "ClueCon"}`,
+	}
+	for _, data := range invalid {
+		_, err = ParseConfig([]byte(data))
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for %s, got: %v", data, err)
+		}
+	}
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got: %v", err)
+	}
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "esl.yaml"))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a YAML file, got: %v", err)
+	}
+}
+
+// countingDialer counts the dial attempts, and fails the first ones
+type countingDialer struct {
+	attempts int32
+	failures int32
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if atomic.AddInt32(&d.attempts, 1) <= d.failures {
+		return nil, errors.New("dial failed")
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+func TestConnectConfig(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeCommandReply("+OK " + cmd)
+	})
+
+	dialer := &countingDialer{failures: 2}
+	var connected string
+	cfg := NewConfig(server.Addr(), fakePassword,
+		WithDialer(dialer),
+		WithBackoff(BackoffConfig{MaxRetries: 3, InitialInterval: time.Millisecond}),
+		WithObserver(ObserverFuncs{Connect: func(addr string) { connected = addr }}),
+		WithEventFormat(EOUTJSON),
+	)
+
+	socket, err := ConnectConfig(cfg)
+	if err != nil {
+		t.Fatalf("Unable to connect: %s", err)
+	}
+	defer socket.Close()
+
+	if dialer.attempts != 3 {
+		t.Errorf("Expected 3 dial attempts, got %d", dialer.attempts)
+	}
+
+	if connected != server.Addr() {
+		t.Errorf("Observer was not notified: %q", connected)
+	}
+
+	msg, err := socket.Subscribe("HEARTBEAT")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if msg.Headers.GetString("Reply-Text") != "+OK event json HEARTBEAT" {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+}
+
+func TestConnectConfigClientFields(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeCommandReply("+OK")
+	})
+
+	// The settings of a Client are not used by a single connection
+	cfg := NewConfig(server.Addr(), fakePassword)
+	cfg.Standby = []string{""}
+	cfg.EventQueue = QueueConfig{}
+
+	if !errors.Is(cfg.Validate(), ErrInvalidConfig) {
+		t.Errorf("Expected the client config to be invalid")
+	}
+
+	socket, err := ConnectConfig(cfg)
+	if err != nil {
+		t.Fatalf("Unable to connect: %s", err)
+	}
+	socket.Close()
+}
+
+func TestConnectConfigRetriesExhausted(t *testing.T) {
+	dialer := &countingDialer{failures: 10}
+	cfg := NewConfig("127.0.0.1", fakePassword,
+		WithDialer(dialer),
+		WithBackoff(BackoffConfig{MaxRetries: 2, InitialInterval: time.Millisecond}),
+	)
+
+	_, err := ConnectConfig(cfg)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if dialer.attempts != 3 {
+		t.Errorf("Expected 3 dial attempts, got %d", dialer.attempts)
+	}
+}
+
+func TestConfigReadTimeout(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "api status" {
+			return ""
+		}
+		return fakeAPIResponse("+OK")
+	})
+
+	cfg := NewConfig(server.Addr(), fakePassword, WithReadTimeout(50*time.Millisecond))
+	socket, err := ConnectConfig(cfg)
+	if err != nil {
+		t.Fatalf("Unable to connect: %s", err)
+	}
+	defer socket.Close()
+
+	start := time.Now()
+	_, err = socket.API("status", "")
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got: %v", err)
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Read timeout took %s", time.Since(start))
+	}
+}
+
+func TestConnectConfigTLS(t *testing.T) {
+	serverConfig, roots := testTLSConfig(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	server := &fakeServer{
+		listener: tls.NewListener(listener, serverConfig),
+		handler:  func(string) string { return fakeAPIResponse("UP") },
+	}
+	go server.serve()
+	t.Cleanup(server.Close)
+
+	cfg := NewConfig(server.Addr(), fakePassword, WithTLS(&tls.Config{RootCAs: roots}))
+	socket, err := ConnectConfig(cfg)
+	if err != nil {
+		t.Fatalf("Unable to connect: %s", err)
+	}
+	defer socket.Close()
+
+	msg, err := socket.API("status", "")
+	if err != nil || string(msg.Body) != "UP" {
+		t.Errorf("Unexpected reply: %v, %v", msg, err)
+	}
+
+	// The certificate is not trusted without the roots
+	_, err = ConnectConfig(NewConfig(server.Addr(), fakePassword, WithTLS(&tls.Config{})))
+	if err == nil {
+		t.Errorf("Expected a certificate error")
+	}
+}
+
+// testTLSConfig creates a self signed certificate for 127.0.0.1
+func testTLSConfig(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "esl test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, roots
+}
@@ -4,9 +4,11 @@ import "errors"
 
 // Default settings
 const (
+	DefaultHost                 = "localhost"
 	DefaultPort                 = "8021"
 	EOL                         = "\n"
 	MaxBufferSize         int64 = 2_000_000
+	DefaultMaxFrameSize         = 16 * 1024 * 1024
 	AuthRequestBufferSize int64 = 32
 )
 
@@ -61,4 +63,33 @@ var (
 	ErrContentLengthZero            = errors.New("Content Length is zero")
 	ErrUnableToGetConnectedSocket   = errors.New("Unable to get connected socket")
 	ErrUnableToLogInNoErrorReturned = errors.New("Unable to log in, no error returned")
+	ErrNotAnEvent                   = errors.New("Message is not an event")
+	ErrUnexpectedEvent              = errors.New("Unexpected event")
+	ErrSofiaInvalidProfile          = errors.New("Invalid sofia profile")
+	ErrSofiaInvalidGateway          = errors.New("Invalid sofia gateway")
+	ErrConferenceNotFound           = errors.New("Conference not found")
+	ErrConferenceMemberNotFound     = errors.New("Conference member not found")
+	ErrCallcenterUnexpectedReply    = errors.New("Unexpected callcenter reply")
+	ErrInvalidRecordDirection       = errors.New("Invalid record direction")
+	ErrDisconnected                 = errors.New("Disconnected by the server")
+	ErrHeaderNotFound               = errors.New("Header not found")
+	ErrInvalidHeaderValue           = errors.New("Invalid header value")
+	ErrInvalidContentLength         = errors.New("Invalid Content-Length")
+	ErrMalformedHeader              = errors.New("Malformed header line")
+	ErrFrameTooLarge                = errors.New("Frame is larger than the max frame size")
+	ErrUnsafeArgument               = errors.New("Unsafe command argument")
+	ErrCommandFailed                = errors.New("Command failed")
+	ErrPermissionDenied             = errors.New("Permission denied")
+	ErrCommandNotFound              = errors.New("Command not found")
+	ErrInvalidUUID                  = errors.New("Invalid UUID")
+	ErrTimeout                      = errors.New("Timeout")
+	ErrUnexpectedReply              = errors.New("Unexpected reply")
+	ErrInvalidConfig                = errors.New("Invalid config")
+	ErrClosed                       = errors.New("Connection is closed")
+	ErrFilterNotFound               = errors.New("Filter not found")
+	ErrQueueOverflow                = errors.New("Event queue overflow")
+	ErrHeartbeatTimeout             = errors.New("Heartbeat timeout")
+	ErrInvalidStatus                = errors.New("Invalid status")
+	ErrNoNodeAvailable              = errors.New("No node is available")
+	ErrUnknownUUID                  = errors.New("Unknown UUID owner")
 )
@@ -0,0 +1,123 @@
+package esl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Current file contains the errors that are returned by Freeswitch.
+//
+// A command that fails returns a CommandError, that holds the command that was
+// sent and the reply. Common replies are mapped into sentinel errors, so they
+// can be checked using errors.Is instead of comparing the reply text:
+//
+//	err := msg.Error()
+//	if errors.Is(err, esl.ErrInvalidUUID) {
+//		// the channel is already gone
+//	}
+//
+//	var cmdErr *esl.CommandError
+//	if errors.As(err, &cmdErr) {
+//		log.Println(cmdErr.Command, cmdErr.Reply)
+//	}
+
+// CommandError is returned when Freeswitch replies to a command with an error.
+//
+// It matches ErrCommandFailed and its Cause (if any) using errors.Is.
+type CommandError struct {
+	// Command is the first line of the command that was sent, with its
+	// password redacted. It is empty if the command is not known.
+	Command string
+	// ContentType is the content type of the reply
+	ContentType EventContentType
+	// Reply is the reply text as arrived (e.g. "-ERR no such channel")
+	Reply string
+	// Cause is the sentinel error that the reply was mapped into, such as
+	// ErrInvalidUUID, or nil if the reply is not a known error
+	Cause error
+}
+
+func (e *CommandError) Error() string {
+	text := errorText(e.Reply)
+	if text == "" {
+		cause := e.Cause
+		if cause == nil {
+			cause = ErrCommandFailed
+		}
+		text = fmt.Sprintf("%s (%s)", cause, e.ContentType)
+	}
+	if e.Command == "" {
+		return text
+	}
+	return e.Command + ": " + text
+}
+
+// Unwrap returns the cause of the error
+func (e *CommandError) Unwrap() error {
+	return e.Cause
+}
+
+// Is returns true for ErrCommandFailed
+func (e *CommandError) Is(target error) bool {
+	return target == ErrCommandFailed
+}
+
+// replyCauses maps the (lower case) text of common error replies into errors
+var replyCauses = []struct {
+	texts []string
+	cause error
+}{
+	{
+		texts: []string{"permission denied", "not allowed", "access denied"},
+		cause: ErrPermissionDenied,
+	},
+	{
+		texts: []string{"command not found", "no such command", "unknown command"},
+		cause: ErrCommandNotFound,
+	},
+	{
+		texts: []string{"no such channel", "invalid uuid", "invalid session id"},
+		cause: ErrInvalidUUID,
+	},
+	{
+		texts: []string{"timeout", "timed out"},
+		cause: ErrTimeout,
+	},
+}
+
+// replyCause returns the sentinel error of a reply, or nil if it is unknown
+func replyCause(reply string) error {
+	lower := strings.ToLower(reply)
+
+	for _, known := range replyCauses {
+		for _, text := range known.texts {
+			if strings.Contains(lower, text) {
+				return known.cause
+			}
+		}
+	}
+
+	return nil
+}
+
+// newCommandError creates a CommandError of a reply to cmd
+func newCommandError(cmd string, contentType EventContentType, reply string) *CommandError {
+	reply = strings.TrimSpace(reply)
+
+	return &CommandError{
+		Command:     commandLine(cmd),
+		ContentType: contentType,
+		Reply:       reply,
+		Cause:       replyCause(reply),
+	}
+}
+
+// commandLine returns the first line of cmd, without its password
+func commandLine(cmd string) string {
+	line := redactCommand(cmd)
+	idx := strings.IndexAny(line, "\r\n")
+	if idx >= 0 {
+		line = line[:idx]
+	}
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,103 @@
+package esl
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommandErrorCause(t *testing.T) {
+	fixtures := []struct {
+		reply    string
+		expected error
+	}{
+		{reply: "-ERR permission denied!", expected: ErrPermissionDenied},
+		{reply: "-ERR uuid_kill Command not found!", expected: ErrCommandNotFound},
+		{reply: "-ERR command not found", expected: ErrCommandNotFound},
+		{reply: "-ERR No such channel!", expected: ErrInvalidUUID},
+		{reply: "-ERR Invalid uuid", expected: ErrInvalidUUID},
+		{reply: "-ERR Timeout", expected: ErrTimeout},
+		{reply: "-ERR NORMAL_TEMPORARY_FAILURE", expected: nil},
+	}
+
+	for _, fixture := range fixtures {
+		err := newCommandError("api test", ECTAPIResponse, fixture.reply+"\n")
+
+		if !errors.Is(err, ErrCommandFailed) {
+			t.Errorf("%q: expected ErrCommandFailed", fixture.reply)
+		}
+
+		if err.Cause != fixture.expected {
+			t.Errorf("%q: expected %v, got %v", fixture.reply, fixture.expected, err.Cause)
+		}
+
+		if fixture.expected != nil && !errors.Is(err, fixture.expected) {
+			t.Errorf("%q: expected errors.Is to match %s", fixture.reply, fixture.expected)
+		}
+	}
+}
+
+func TestCommandErrorText(t *testing.T) {
+	err := newCommandError("auth ClueCon\n\n", ECTCommandReply, "-ERR invalid")
+	if err.Error() != "auth ********: invalid" {
+		t.Errorf("Unexpected error text: %q", err.Error())
+	}
+
+	err = &CommandError{ContentType: ECTDisconnectNotice, Cause: ErrUnexpectedReply}
+	if err.Error() != "Unexpected reply (text/disconnect-notice)" {
+		t.Errorf("Unexpected error text: %q", err.Error())
+	}
+}
+
+func TestSocketCommandError(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		switch cmd {
+		case "api uuid_kill 1234":
+			return fakeAPIResponse("-ERR No such channel!\n")
+		case "event plain FOO":
+			return fakeCommandReply("-ERR permission denied")
+		}
+		return fakeCommandReply("+OK")
+	})
+	socket := connectFakeServer(t, server)
+
+	msg, err := socket.API("uuid_kill", "1234")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var cmdErr *CommandError
+	err = msg.Error()
+	if !errors.As(err, &cmdErr) || !errors.Is(err, ErrInvalidUUID) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cmdErr.Command != "api uuid_kill 1234" || cmdErr.ContentType != ECTAPIResponse ||
+		cmdErr.Reply != "-ERR No such channel!" {
+		t.Errorf("Unexpected command error: %+v", cmdErr)
+	}
+
+	msg, err = socket.Events(EOTPlain, "FOO")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !errors.Is(msg.Error(), ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied, got: %v", msg.Error())
+	}
+}
+
+func TestSocketLoginPermissionDenied(t *testing.T) {
+	server := newFakeServer(t, func(string) string { return "" })
+
+	_, err := Connect(server.Addr(), "wrong", 0, 5*time.Second)
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cmdErr.Command != "auth "+redactedPassword || cmdErr.Reply != "-ERR invalid" {
+		t.Errorf("Unexpected command error: %+v", cmdErr)
+	}
+}
@@ -1,7 +1,15 @@
 package esl
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -20,8 +28,13 @@ type ESL struct {
 
 // NewESL create a new ESL, and does a login
 func NewESL(host string, password string, maxRetries uint64, timeout time.Duration) (*ESL, error) {
+	return NewESLConfig(legacyConfig(host, password, maxRetries, timeout))
+}
+
+// NewESLConfig create a new ESL using cfg, and does a login
+func NewESLConfig(cfg Config) (*ESL, error) {
 	esl := ESL{}
-	socket, err := Dial(host, password, maxRetries, timeout)
+	socket, err := DialConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -35,9 +48,306 @@ func NewESL(host string, password string, maxRetries uint64, timeout time.Durati
 		}
 
 		if !loggedIn {
-			return nil, errors.New("Unable to loggin, but no error")
+			return nil, ErrUnableToLogInNoErrorReturned
 		}
 	}
 
 	return &esl, nil
 }
+
+// ParseEvent decodes the body of an event message (text/event-plain,
+// text/event-json or text/event-xml) into a new Message, that holds the
+// headers of the event itself and its body (if any).
+//
+// The decoders of events (such as NewCDR and HeartbeatFromEvent) accept
+// either the event message as arrived from the socket, or an event that was
+// already parsed using ParseEvent.
+func ParseEvent(msg *Message) (*Message, error) {
+	var err error
+	event := &Message{
+		MessageType: ETEvent,
+		Headers:     NewHeaders(),
+		Parsed:      true,
+	}
+
+	switch msg.ContentType() {
+	case ECTEventPlain:
+		err = parsePlainEvent(event, msg.Body)
+	case ECTEventJSON:
+		err = parseJSONEvent(event, msg.Body)
+	case ECTEventXML:
+		err = parseXMLEvent(event, msg.Body)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNotAnEvent, msg.ContentType())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// eventHeaders returns the headers of an event, parsing the message first if
+// it still holds the event as its body.
+func eventHeaders(msg *Message) (Headers, error) {
+	switch msg.ContentType() {
+	case ECTEventPlain, ECTEventJSON, ECTEventXML:
+		event, err := ParseEvent(msg)
+		if err != nil {
+			return Headers{}, err
+		}
+		return event.Headers, nil
+	default:
+		return msg.Headers, nil
+	}
+}
+
+// eventName returns the Event-Name of an event message, without parsing the
+// whole event. found is false if msg is not an event, or has no Event-Name.
+func eventName(msg *Message) (name string, found bool) {
+	switch msg.ContentType() {
+	case ECTEventPlain:
+		headers := msg.Body
+		if idx := bytes.Index(headers, []byte("\n\n")); idx >= 0 {
+			headers = headers[:idx+1]
+		}
+
+		value, found := findHeader(headers, "Event-Name")
+		if !found {
+			return "", false
+		}
+		name, err := url.PathUnescape(string(value))
+		if err != nil {
+			name = string(value)
+		}
+		return name, true
+	case ECTEventJSON:
+		return jsonEventName(msg.Body)
+	case ECTEventXML:
+		return xmlEventName(msg.Body)
+	}
+
+	return "", false
+}
+
+// jsonEventName reads the fields of a json event up to Event-Name
+func jsonEventName(body []byte) (string, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+
+	token, err := decoder.Token()
+	if delim, ok := token.(json.Delim); err != nil || !ok || delim != '{' {
+		return "", false
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", false
+		}
+
+		if key, _ := token.(string); key != "Event-Name" {
+			var skip json.RawMessage
+			if decoder.Decode(&skip) != nil {
+				return "", false
+			}
+			continue
+		}
+
+		var name string
+		if decoder.Decode(&name) != nil {
+			return "", false
+		}
+		return name, true
+	}
+
+	return "", false
+}
+
+// xmlEventName reads the elements of a xml event up to Event-Name
+func xmlEventName(body []byte) (string, bool) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", false
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 3 && t.Name.Local == "Event-Name" {
+				var name string
+				if decoder.DecodeElement(&name, &t) != nil {
+					return "", false
+				}
+				return name, true
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// isCustomEvent validates that the headers are of a CUSTOM event with the
+// given subclass.
+func isCustomEvent(headers Headers, subclass string) error {
+	name := headers.GetString("Event-Name")
+	sub := headers.GetString("Event-Subclass")
+	if name != "CUSTOM" || sub != subclass {
+		return fmt.Errorf("%w: expected CUSTOM %s, got %s %s",
+			ErrUnexpectedEvent, subclass, name, sub)
+	}
+
+	return nil
+}
+
+func parsePlainEvent(event *Message, body []byte) error {
+	reader := bufio.NewReader(bytes.NewReader(body))
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		idx := strings.Index(line, ":")
+		if idx > 0 {
+			key := line[:idx]
+			value := strings.TrimSpace(line[idx+1:])
+			unescaped, uerr := url.PathUnescape(value)
+			if uerr == nil {
+				value = unescaped
+			}
+			event.Headers.appendDecoded(key, value)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+
+	if !event.Headers.Exists("Content-Length") {
+		return nil
+	}
+
+	l := event.Headers.GetInt("Content-Length")
+	if l <= 0 {
+		return nil
+	}
+
+	// The body can not be longer than the event itself
+	if l > int64(len(body)) {
+		l = int64(len(body))
+	}
+
+	content := make([]byte, l)
+	n, err := io.ReadFull(reader, content)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	event.Body = content[:n]
+
+	return nil
+}
+
+// parseJSONEvent parses the fields of a json event, in the order they
+// arrived (as done for plain and xml events)
+func parseJSONEvent(event *Message, body []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("%w: json event is not an object", ErrInvalidHeaderValue)
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+
+		var value interface{}
+		err = decoder.Decode(&value)
+		if err != nil {
+			return err
+		}
+
+		switch v := value.(type) {
+		case string:
+			if key == "_body" {
+				event.Body = []byte(v)
+				continue
+			}
+			event.Headers.appendDecoded(key, v)
+		case json.Number:
+			event.Headers.Add(key, v.String())
+		case []interface{}:
+			for _, item := range v {
+				event.Headers.Append(key, fmt.Sprint(item))
+			}
+		default:
+			event.Headers.Add(key, fmt.Sprint(v))
+		}
+	}
+
+	_, err = decoder.Token()
+	return err
+}
+
+func parseXMLEvent(event *Message, body []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var path []string
+	var text strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			switch {
+			case len(path) == 3 && path[1] == "headers":
+				value := text.String()
+				unescaped, uerr := url.PathUnescape(value)
+				if uerr == nil {
+					value = unescaped
+				}
+				event.Headers.appendDecoded(t.Name.Local, value)
+			case len(path) == 2 && path[1] == "body":
+				event.Body = []byte(text.String())
+			}
+			path = path[:len(path)-1]
+			text.Reset()
+		}
+	}
+}
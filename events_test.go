@@ -0,0 +1,212 @@
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseEventPlain(t *testing.T) {
+	body := "Event-Name: CUSTOM\nEvent-Subclass: sofia%3A%3Agateway_state\n" +
+		"Gateway: my%20gw\nContent-Length: 5\n\nhello"
+	input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+
+	msg, err := NewMessage([]byte(input), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	event, err := ParseEvent(msg)
+	if err != nil {
+		t.Errorf("Unable to parse event: %s", err)
+		return
+	}
+
+	subclass := event.Headers.GetString("Event-Subclass")
+	if subclass != "sofia::gateway_state" {
+		t.Errorf("Expected 'sofia::gateway_state', got '%s'", subclass)
+	}
+
+	gateway := event.Headers.GetString("Gateway")
+	if gateway != "my gw" {
+		t.Errorf("Expected 'my gw', got '%s'", gateway)
+	}
+
+	if string(event.Body) != "hello" {
+		t.Errorf("Expected body of 'hello', got '%s'", event.Body)
+	}
+}
+
+func TestParseEventJSON(t *testing.T) {
+	body := `{"Event-Name":"HEARTBEAT","Session-Count":"3","_body":"text"}`
+	input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-json\n\n%s", len(body), body)
+
+	msg, err := NewMessage([]byte(input), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	event, err := ParseEvent(msg)
+	if err != nil {
+		t.Errorf("Unable to parse event: %s", err)
+		return
+	}
+
+	if event.Headers.GetString("Event-Name") != "HEARTBEAT" {
+		t.Errorf("Unexpected headers: %s", event.Headers)
+	}
+
+	if event.Headers.GetInt("Session-Count") != 3 {
+		t.Errorf("Expected Session-Count of 3, got: %s", event.Headers)
+	}
+
+	if string(event.Body) != "text" {
+		t.Errorf("Expected body of 'text', got '%s'", event.Body)
+	}
+}
+
+func TestParseEventJSONOrder(t *testing.T) {
+	body := `{"Event-Name":"CHANNEL_CREATE","Core-UUID":"core","Unique-ID":"a","Event-Sequence":12,` +
+		`"variable_b":"2","variable_a":"1","Caller-Caller-ID-Number":"1000","_body":"text"}`
+	input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-json\n\n%s", len(body), body)
+
+	msg, err := NewMessage([]byte(input), true)
+	if err != nil {
+		t.Fatalf("Unable to parse message: %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		event, err := ParseEvent(msg)
+		if err != nil {
+			t.Fatalf("Unable to parse event: %s", err)
+		}
+
+		keys := strings.Join(event.Headers.Keys(), " ")
+		expected := "Event-Name Core-UUID Unique-ID Event-Sequence variable_b variable_a Caller-Caller-ID-Number"
+		if keys != expected {
+			t.Fatalf("Unexpected order:\n%s\nexpected:\n%s", keys, expected)
+		}
+		if event.Headers.GetString("Event-Sequence") != "12" {
+			t.Errorf("Unexpected sequence: %s", event.Headers.GetString("Event-Sequence"))
+		}
+	}
+
+	input = "Content-Length: 2\nContent-Type: text/event-json\n\n[]"
+	msg, _ = NewMessage([]byte(input), true)
+	_, err = ParseEvent(msg)
+	if err == nil {
+		t.Errorf("Expected an error for a json event that is not an object")
+	}
+}
+
+func TestParseEventXML(t *testing.T) {
+	body := `<event><headers><Event-Name>CUSTOM</Event-Name>` +
+		`<Event-Subclass>sofia%3A%3Agateway_state</Event-Subclass></headers>` +
+		`<body>text</body></event>`
+	input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-xml\n\n%s", len(body), body)
+
+	msg, err := NewMessage([]byte(input), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	event, err := ParseEvent(msg)
+	if err != nil {
+		t.Errorf("Unable to parse event: %s", err)
+		return
+	}
+
+	if event.Headers.GetString("Event-Subclass") != "sofia::gateway_state" {
+		t.Errorf("Unexpected headers: %s", event.Headers)
+	}
+
+	if string(event.Body) != "text" {
+		t.Errorf("Expected body of 'text', got '%s'", event.Body)
+	}
+}
+
+func TestParseEventNotAnEvent(t *testing.T) {
+	msg, err := NewMessage([]byte("Content-Type: command/reply\nReply-Text: +OK\n"), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	_, err = ParseEvent(msg)
+	if !errors.Is(err, ErrNotAnEvent) {
+		t.Errorf("Expected ErrNotAnEvent, got: %v", err)
+	}
+}
+
+func TestParseEventArray(t *testing.T) {
+	jsonBody := `{"Event-Name":"CHANNEL_DATA","variable_codecs":"ARRAY::PCMU|:PCMA|:G722"}`
+	inputs := []string{
+		fakeEvent("Event-Name: CHANNEL_DATA", "variable_codecs: ARRAY%3A%3APCMU%7C%3APCMA%7C%3AG722"),
+		fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-json\n\n%s", len(jsonBody), jsonBody),
+	}
+
+	for idx, input := range inputs {
+		msg, err := NewMessage([]byte(input), true)
+		if err != nil {
+			t.Errorf("Unable to parse message (%d): %s", idx, err)
+			continue
+		}
+
+		event, err := ParseEvent(msg)
+		if err != nil {
+			t.Errorf("Unable to parse event (%d): %s", idx, err)
+			continue
+		}
+
+		values := event.Headers.Values("variable_codecs")
+		if fmt.Sprint(values) != "[PCMU PCMA G722]" {
+			t.Errorf("Unexpected values (%d): %v", idx, values)
+		}
+	}
+}
+
+func TestEventName(t *testing.T) {
+	messages := map[string]string{
+		"plain": fakeEvent("Core-UUID: core", "Event-Name: CHANNEL_CREATE", "Unique-ID: a"),
+		"json": fakeJSONEvent(`{"Core-UUID":"core","Event-Calling-Line-Number":12,` +
+			`"variable_list":["a","b"],"Event-Name":"CHANNEL_CREATE"}`),
+		"xml": func() string {
+			body := `<event><headers><Core-UUID>core</Core-UUID><Event-Name>CHANNEL_CREATE</Event-Name>` +
+				`</headers><body>Event-Name: OTHER</body></event>`
+			return fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-xml\n\n%s", len(body), body)
+		}(),
+	}
+
+	for format, raw := range messages {
+		msg, err := NewMessage([]byte(raw), true)
+		if err != nil {
+			t.Fatalf("Unable to parse %s message: %s", format, err)
+		}
+
+		event, err := ParseEvent(msg)
+		if err != nil {
+			t.Fatalf("Unable to parse %s event: %s", format, err)
+		}
+
+		name, found := eventName(msg)
+		if !found || name != event.Headers.GetString("Event-Name") {
+			t.Errorf("Unexpected %s event name: %q %t", format, name, found)
+		}
+	}
+
+	// The body of the event is not searched
+	body := "Core-UUID: core\n\nEvent-Name: OTHER\n"
+	msg, _ := NewMessage([]byte(fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)), true)
+	if name, found := eventName(msg); found {
+		t.Errorf("Unexpected event name: %q", name)
+	}
+
+	msg, _ = NewMessage([]byte(fakeCommandReply("+OK")), true)
+	if _, found := eventName(msg); found {
+		t.Errorf("A command reply has no event name")
+	}
+}
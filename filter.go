@@ -0,0 +1,382 @@
+package esl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Current file contains a small DSL for event filters.
+//
+// Freeswitch filters can only express header equality (case insensitive) or a
+// regular expression, and an event is sent if it matches any of the filters.
+// Conditions are compiled into the filters that the server can handle, and
+// are also evaluated on the client for everything that the server can not
+// express (AND, NOT, numeric comparisons and header presence).
+
+// Condition is a predicate on the headers of an event
+type Condition interface {
+	Match(headers Headers) bool
+}
+
+// ConditionFunc is a client side only Condition
+type ConditionFunc func(headers Headers) bool
+
+// Match implements Condition
+func (f ConditionFunc) Match(headers Headers) bool {
+	return f(headers)
+}
+
+type equalsCondition struct {
+	header string
+	value  string
+}
+
+// HeaderEquals matches when a header equals to value (case insensitive, as
+// done by Freeswitch)
+func HeaderEquals(header, value string) Condition {
+	return equalsCondition{header: header, value: value}
+}
+
+func (c equalsCondition) Match(headers Headers) bool {
+	return headers.Exists(c.header) && strings.EqualFold(headers.GetString(c.header), c.value)
+}
+
+type regexCondition struct {
+	header  string
+	pattern *regexp.Regexp
+}
+
+// HeaderMatches matches when a header matches a regular expression.
+// The server uses PCRE, so pattern should use the syntax that is common to
+// both.
+func HeaderMatches(header string, pattern *regexp.Regexp) Condition {
+	return regexCondition{header: header, pattern: pattern}
+}
+
+func (c regexCondition) Match(headers Headers) bool {
+	return headers.Exists(c.header) && c.pattern.MatchString(headers.GetString(c.header))
+}
+
+// HeaderExists matches when a header exists (client side only)
+func HeaderExists(header string) Condition {
+	return ConditionFunc(func(headers Headers) bool {
+		return headers.Exists(header)
+	})
+}
+
+// numericCondition compares a header as a number, headers that are missing
+// or are not a number never match
+func numericCondition(header string, compare func(value float64) bool) Condition {
+	return ConditionFunc(func(headers Headers) bool {
+		value, err := strconv.ParseFloat(strings.TrimSpace(headers.GetString(header)), 64)
+		if err != nil {
+			return false
+		}
+		return compare(value)
+	})
+}
+
+// HeaderGreaterThan matches when a header is a number that is greater than
+// value (client side only)
+func HeaderGreaterThan(header string, value float64) Condition {
+	return numericCondition(header, func(n float64) bool { return n > value })
+}
+
+// HeaderGreaterOrEqual matches when a header is a number that is greater
+// than or equal to value (client side only)
+func HeaderGreaterOrEqual(header string, value float64) Condition {
+	return numericCondition(header, func(n float64) bool { return n >= value })
+}
+
+// HeaderLessThan matches when a header is a number that is less than value
+// (client side only)
+func HeaderLessThan(header string, value float64) Condition {
+	return numericCondition(header, func(n float64) bool { return n < value })
+}
+
+// HeaderLessOrEqual matches when a header is a number that is less than or
+// equal to value (client side only)
+func HeaderLessOrEqual(header string, value float64) Condition {
+	return numericCondition(header, func(n float64) bool { return n <= value })
+}
+
+type andCondition []Condition
+
+// And matches when all the conditions match
+func And(conditions ...Condition) Condition {
+	return andCondition(conditions)
+}
+
+func (c andCondition) Match(headers Headers) bool {
+	for _, condition := range c {
+		if !condition.Match(headers) {
+			return false
+		}
+	}
+	return true
+}
+
+type orCondition []Condition
+
+// Or matches when any of the conditions match
+func Or(conditions ...Condition) Condition {
+	return orCondition(conditions)
+}
+
+func (c orCondition) Match(headers Headers) bool {
+	for _, condition := range c {
+		if condition.Match(headers) {
+			return true
+		}
+	}
+	return false
+}
+
+// Not matches when condition does not match (client side only)
+func Not(condition Condition) Condition {
+	return ConditionFunc(func(headers Headers) bool {
+		return !condition.Match(headers)
+	})
+}
+
+// ServerFilter is a single filter of Freeswitch ("filter <header> <value>")
+type ServerFilter struct {
+	Header string
+	Value  string
+}
+
+func (f ServerFilter) String() string {
+	return f.Header + " " + f.Value
+}
+
+// serverFilters returns the filters that let through at least all the events
+// that match condition. ok is false when the server can not narrow the events
+// for the condition at all.
+func serverFilters(condition Condition) (filters []ServerFilter, ok bool) {
+	switch c := condition.(type) {
+	case equalsCondition:
+		return []ServerFilter{{Header: c.header, Value: c.value}}, true
+	case regexCondition:
+		return []ServerFilter{{Header: c.header, Value: "/" + c.pattern.String() + "/"}}, true
+	case orCondition:
+		// Every branch must be narrowed, or everything is needed
+		for _, child := range c {
+			childFilters, ok := serverFilters(child)
+			if !ok {
+				return nil, false
+			}
+			filters = append(filters, childFilters...)
+		}
+		return filters, len(filters) > 0
+	case andCondition:
+		// Any branch is a superset of the conjunction, the rest is done at the
+		// client side
+		for _, child := range c {
+			childFilters, ok := serverFilters(child)
+			if ok {
+				return childFilters, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// FilterID identifies a condition that was added to EventFilter
+type FilterID uint64
+
+type filterRule struct {
+	id        FilterID
+	condition Condition
+}
+
+// EventFilter keeps a list of conditions. It sends to the server the filters
+// that narrow the events as much as possible, and Match does the exact
+// matching at the client side. An event matches when any of the conditions
+// match, as done by Freeswitch.
+//
+// Since Freeswitch sends only events that match a filter once any filter
+// exists, no server filters are kept while a condition that the server can
+// not narrow is active.
+//
+// EventFilter only adds and deletes its own filters, filters that were added
+// directly using Socket.Filter are left untouched (unless they are exactly
+// the same header and value).
+type EventFilter struct {
+	socket *Socket
+
+	lock    sync.Mutex
+	lastID  FilterID
+	rules   []filterRule
+	applied []ServerFilter
+}
+
+// NewEventFilter creates a new EventFilter for a socket. If socket is nil, the
+// conditions are matched only at the client side.
+func NewEventFilter(socket *Socket) *EventFilter {
+	return &EventFilter{socket: socket}
+}
+
+// Add a condition, and update the server filters. If the server filters
+// could not be updated, the condition is not added, and a zero id is
+// returned.
+func (f *EventFilter) Add(condition Condition) (FilterID, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.lastID++
+	id := f.lastID
+	f.rules = append(f.rules, filterRule{id: id, condition: condition})
+
+	err := f.sync()
+	if err != nil {
+		f.rules = f.rules[:len(f.rules)-1]
+		// Delete the filters that were added for the condition, if possible
+		f.sync()
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Remove a condition that was added, and remove the server filters that are
+// no longer needed. ErrFilterNotFound is returned for an unknown id.
+func (f *EventFilter) Remove(id FilterID) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for idx, rule := range f.rules {
+		if rule.id == id {
+			f.rules = append(f.rules[:idx], f.rules[idx+1:]...)
+			return f.sync()
+		}
+	}
+
+	return fmt.Errorf("%w: %d", ErrFilterNotFound, id)
+}
+
+// Clear removes all the conditions, and all the server filters that were added
+func (f *EventFilter) Clear() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.rules = nil
+	return f.sync()
+}
+
+// Active returns the server filters that are active, in the order they were
+// added
+func (f *EventFilter) Active() []ServerFilter {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]ServerFilter(nil), f.applied...)
+}
+
+// Match returns true if the event matches any of the conditions, or if there
+// are no conditions at all.
+func (f *EventFilter) Match(msg *Message) bool {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return false
+	}
+	return f.MatchHeaders(headers)
+}
+
+// MatchHeaders is like Match, but for headers of an event
+func (f *EventFilter) MatchHeaders(headers Headers) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.rules) == 0 {
+		return true
+	}
+
+	for _, rule := range f.rules {
+		if rule.condition.Match(headers) {
+			return true
+		}
+	}
+	return false
+}
+
+// wanted returns the server filters that are needed for the current rules
+func (f *EventFilter) wanted() []ServerFilter {
+	var wanted []ServerFilter
+	seen := make(map[ServerFilter]bool)
+
+	for _, rule := range f.rules {
+		filters, ok := serverFilters(rule.condition)
+		if !ok {
+			return nil
+		}
+
+		for _, filter := range filters {
+			if !seen[filter] {
+				seen[filter] = true
+				wanted = append(wanted, filter)
+			}
+		}
+	}
+
+	return wanted
+}
+
+// sync adds the missing server filters and deletes the ones that are no
+// longer needed. New filters are added first, so the events are not widened
+// in between. Must be called while the lock is held.
+func (f *EventFilter) sync() error {
+	if f.socket == nil {
+		return nil
+	}
+
+	wanted := f.wanted()
+	isWanted := make(map[ServerFilter]bool, len(wanted))
+	for _, filter := range wanted {
+		isWanted[filter] = true
+	}
+
+	isApplied := make(map[ServerFilter]bool, len(f.applied))
+	for _, filter := range f.applied {
+		isApplied[filter] = true
+	}
+
+	for _, filter := range wanted {
+		if isApplied[filter] {
+			continue
+		}
+
+		msg, err := f.socket.Filter(filter.Header, filter.Value)
+		if err == nil && msg.HasError() {
+			err = msg.Error()
+		}
+		if err != nil {
+			return err
+		}
+
+		f.applied = append(f.applied, filter)
+	}
+
+	var kept []ServerFilter
+	for idx, filter := range f.applied {
+		if isWanted[filter] {
+			kept = append(kept, filter)
+			continue
+		}
+
+		msg, err := f.socket.FilterDelete(filter.Header, filter.Value)
+		if err == nil && msg.HasError() {
+			err = msg.Error()
+		}
+		if err != nil {
+			f.applied = append(kept, f.applied[idx:]...)
+			return err
+		}
+	}
+	f.applied = kept
+
+	return nil
+}
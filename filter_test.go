@@ -0,0 +1,223 @@
+package esl
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestConditions(t *testing.T) {
+	event := fakeEventMessage(t, "Event-Name: CHANNEL_HANGUP", "Unique-ID: a",
+		"Hangup-Cause: NORMAL_CLEARING",
+		"variable_billsec: 42",
+		"Caller-Destination-Number: 1001",
+	)
+
+	headers, err := eventHeaders(event)
+	if err != nil {
+		t.Fatalf("Unable to parse event: %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		condition Condition
+		expected  bool
+	}{
+		{"equals", HeaderEquals("Event-Name", "channel_hangup"), true},
+		{"equals other", HeaderEquals("Event-Name", "CHANNEL_ANSWER"), false},
+		{"equals missing", HeaderEquals("Missing", ""), false},
+		{"regex", HeaderMatches("Caller-Destination-Number", regexp.MustCompile(`^10\d\d$`)), true},
+		{"exists", HeaderExists("Hangup-Cause"), true},
+		{"not exists", Not(HeaderExists("Hangup-Cause")), false},
+		{"greater than", HeaderGreaterThan("variable_billsec", 30), true},
+		{"greater or equal", HeaderGreaterOrEqual("variable_billsec", 42), true},
+		{"less than", HeaderLessThan("variable_billsec", 42), false},
+		{"less or equal", HeaderLessOrEqual("variable_billsec", 42), true},
+		{"numeric not a number", HeaderGreaterThan("Hangup-Cause", 0), false},
+		{"and", And(HeaderEquals("Event-Name", "CHANNEL_HANGUP"), HeaderGreaterThan("variable_billsec", 60)), false},
+		{"or", Or(HeaderEquals("Event-Name", "CHANNEL_ANSWER"), HeaderEquals("Event-Name", "CHANNEL_HANGUP")), true},
+	}
+
+	for _, test := range tests {
+		if result := test.condition.Match(headers); result != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, result)
+		}
+	}
+}
+
+func TestServerFilters(t *testing.T) {
+	answer := HeaderEquals("Event-Name", "CHANNEL_ANSWER")
+	hangup := HeaderEquals("Event-Name", "CHANNEL_HANGUP")
+
+	tests := []struct {
+		name      string
+		condition Condition
+		expected  []ServerFilter
+		ok        bool
+	}{
+		{"equals", answer, []ServerFilter{{"Event-Name", "CHANNEL_ANSWER"}}, true},
+		{"regex", HeaderMatches("Unique-ID", regexp.MustCompile(`^a`)), []ServerFilter{{"Unique-ID", "/^a/"}}, true},
+		{"or", Or(answer, hangup), []ServerFilter{{"Event-Name", "CHANNEL_ANSWER"}, {"Event-Name", "CHANNEL_HANGUP"}}, true},
+		{"or with client side", Or(answer, HeaderExists("Hangup-Cause")), nil, false},
+		{"and", And(HeaderExists("Hangup-Cause"), hangup), []ServerFilter{{"Event-Name", "CHANNEL_HANGUP"}}, true},
+		{"and client side", And(HeaderExists("Hangup-Cause")), nil, false},
+		{"not", Not(answer), nil, false},
+	}
+
+	for _, test := range tests {
+		filters, ok := serverFilters(test.condition)
+		if ok != test.ok || !reflect.DeepEqual(filters, test.expected) {
+			t.Errorf("%s: unexpected filters: %v (%t)", test.name, filters, ok)
+		}
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeCommandReply("+OK filter")
+	})
+	socket := connectFakeServer(t, server)
+
+	filter := NewEventFilter(socket)
+	if !filter.Match(fakeEventMessage(t, "Event-Name: CHANNEL_ANSWER", "Unique-ID: a")) {
+		t.Errorf("Expected an empty filter to match everything")
+	}
+
+	answered, err := filter.Add(And(
+		HeaderEquals("Event-Name", "CHANNEL_ANSWER"),
+		HeaderEquals("Call-Direction", "inbound"),
+	))
+	if err != nil {
+		t.Errorf("Unable to add filter: %s", err)
+	}
+
+	long, err := filter.Add(Or(
+		HeaderEquals("Event-Name", "CHANNEL_ANSWER"),
+		HeaderEquals("Event-Name", "CHANNEL_HANGUP"),
+	))
+	if err != nil {
+		t.Errorf("Unable to add filter: %s", err)
+	}
+
+	expected := []ServerFilter{{"Event-Name", "CHANNEL_ANSWER"}, {"Event-Name", "CHANNEL_HANGUP"}}
+	if active := filter.Active(); !reflect.DeepEqual(active, expected) {
+		t.Errorf("Unexpected active filters: %v", active)
+	}
+
+	if !filter.Match(fakeEventMessage(t, "Event-Name: CHANNEL_HANGUP", "Unique-ID: a")) || filter.Match(fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: a")) {
+		t.Errorf("Unexpected client side matching")
+	}
+
+	// CHANNEL_ANSWER is still needed by the first condition
+	err = filter.Remove(long)
+	if err != nil {
+		t.Errorf("Unable to remove filter: %s", err)
+	}
+
+	expected = []ServerFilter{{"Event-Name", "CHANNEL_ANSWER"}}
+	if active := filter.Active(); !reflect.DeepEqual(active, expected) {
+		t.Errorf("Unexpected active filters: %v", active)
+	}
+
+	if filter.Match(fakeEventMessage(t, "Event-Name: CHANNEL_ANSWER", "Unique-ID: a", "Call-Direction: outbound")) {
+		t.Errorf("Expected outbound answer not to match")
+	}
+
+	// A condition that the server can not narrow removes the server filters
+	present, err := filter.Add(HeaderExists("Hangup-Cause"))
+	if err != nil {
+		t.Errorf("Unable to add filter: %s", err)
+	}
+
+	if active := filter.Active(); len(active) != 0 {
+		t.Errorf("Expected no active filters, got: %v", active)
+	}
+
+	filter.Remove(present)
+	filter.Remove(answered)
+
+	expectedCommands := []string{
+		"filter Event-Name CHANNEL_ANSWER",
+		"filter Event-Name CHANNEL_HANGUP",
+		"filter delete Event-Name CHANNEL_HANGUP",
+		"filter delete Event-Name CHANNEL_ANSWER",
+		"filter Event-Name CHANNEL_ANSWER",
+		"filter delete Event-Name CHANNEL_ANSWER",
+	}
+
+	var commands []string
+	for _, cmd := range server.Commands() {
+		commands = append(commands, strings.TrimSpace(cmd))
+	}
+
+	if !reflect.DeepEqual(commands, expectedCommands) {
+		t.Errorf("Unexpected commands:\n%s", strings.Join(commands, "\n"))
+	}
+}
+
+func TestEventFilterServerError(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeCommandReply("-ERR invalid")
+	})
+	socket := connectFakeServer(t, server)
+
+	filter := NewEventFilter(socket)
+	_, err := filter.Add(HeaderEquals("Event-Name", "CHANNEL_ANSWER"))
+	if err == nil {
+		t.Errorf("Expected an error")
+	}
+
+	if active := filter.Active(); len(active) != 0 {
+		t.Errorf("Expected no active filters, got: %v", active)
+	}
+}
+
+func TestEventFilterRollback(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "filter Event-Name CHANNEL_BAD" {
+			return fakeCommandReply("-ERR invalid")
+		}
+		return fakeCommandReply("+OK filter")
+	})
+	socket := connectFakeServer(t, server)
+
+	filter := NewEventFilter(socket)
+	answered, err := filter.Add(HeaderEquals("Event-Name", "CHANNEL_ANSWER"))
+	if err != nil {
+		t.Fatalf("Unable to add filter: %s", err)
+	}
+
+	id, err := filter.Add(Or(
+		HeaderEquals("Event-Name", "CHANNEL_HANGUP"),
+		HeaderEquals("Event-Name", "CHANNEL_BAD"),
+	))
+	if err == nil || id != 0 {
+		t.Errorf("Expected an error and a zero id, got: %d %v", id, err)
+	}
+
+	expected := []ServerFilter{{"Event-Name", "CHANNEL_ANSWER"}}
+	if active := filter.Active(); !reflect.DeepEqual(active, expected) {
+		t.Errorf("Unexpected active filters: %v", active)
+	}
+
+	if filter.Match(fakeEventMessage(t, "Event-Name: CHANNEL_HANGUP", "Unique-ID: a")) {
+		t.Errorf("The condition that failed was kept")
+	}
+
+	err = filter.Remove(answered + 10)
+	if !errors.Is(err, ErrFilterNotFound) {
+		t.Errorf("Expected ErrFilterNotFound, got: %v", err)
+	}
+
+	err = filter.Remove(answered)
+	if err != nil {
+		t.Errorf("Unable to remove filter: %s", err)
+	}
+
+	err = filter.Remove(answered)
+	if !errors.Is(err, ErrFilterNotFound) {
+		t.Errorf("Expected ErrFilterNotFound, got: %v", err)
+	}
+}
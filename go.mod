@@ -1,5 +1,5 @@
 module github.com/ik5/esl
 
-go 1.15
+go 1.21
 
 require github.com/cenkalti/backoff/v4 v4.0.0
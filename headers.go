@@ -1,69 +1,271 @@
 package esl
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
-// Headers holds information regarding given header
+// Headers holds information regarding given header.
+//
+// Keys are case insensitive (the canonical key is the lower case of the
+// name), and they are kept in the order they were first added, using the
+// name that they were first added with. A header can hold more than one
+// value (see Append and Values).
+//
+// Copies of Headers share the same content, use Snapshot for an independent
+// copy.
 type Headers struct {
-	header map[string]interface{}
-	lock   *sync.RWMutex
+	state *headersState
+}
+
+type headersState struct {
+	lock sync.RWMutex
+	data *headersData
+	// raw holds header lines that were not decoded yet (see newLazyHeaders)
+	raw    []byte
+	decode sync.Once
+	// shared is true when data is shared with a snapshot, and must be copied
+	// before it is modified
+	shared bool
+}
+
+type headersData struct {
+	names  []string                 // names by the insertion order
+	values map[string][]interface{} // values by canonical key
 }
 
 // NewHeaders initialize the given headers
 func NewHeaders() Headers {
 	headers := Headers{
-		header: make(map[string]interface{}),
-		lock:   &sync.RWMutex{},
+		state: &headersState{
+			data: &headersData{values: make(map[string][]interface{})},
+		},
 	}
 
 	return headers
 }
 
-func (h Headers) String() string {
-	h.lock.RLock()
-	defer h.lock.RUnlock()
+// newLazyHeaders creates headers from raw "Name: value" lines, that are
+// decoded only when first accessed
+func newLazyHeaders(raw []byte) Headers {
+	headers := NewHeaders()
+	headers.state.raw = raw
+	return headers
+}
+
+// decodeRaw decodes the raw header lines, if any
+func (s *headersState) decodeRaw() {
+	s.decode.Do(func() {
+		raw := s.raw
+		s.raw = nil
+
+		for len(raw) > 0 {
+			end := bytes.IndexByte(raw, '\n') + 1
+			if end == 0 {
+				end = len(raw)
+			}
+
+			name, value, ok := splitHeaderLine(trimEOL(raw[:end]))
+			if ok {
+				canonical := canonicalKey(string(name))
+				if _, found := s.data.values[canonical]; !found {
+					s.data.names = append(s.data.names, string(name))
+				}
+				s.data.values[canonical] = append(s.data.values[canonical], string(value))
+			}
+			raw = raw[end:]
+		}
+	})
+}
+
+// canonicalKey returns the key that a header is stored with
+func canonicalKey(key string) string {
+	return strings.ToLower(key)
+}
+
+// read returns the content for reading, and a function to release it
+func (h Headers) read() (*headersData, func()) {
+	if h.state == nil {
+		return &headersData{}, func() {}
+	}
 
-	var headers []string = h.Keys()
+	h.state.decodeRaw()
+	h.state.lock.RLock()
+	return h.state.data, h.state.lock.RUnlock
+}
+
+// write returns the content for writing (copying it if shared), and a
+// function to release it
+func (h *Headers) write() (*headersData, func()) {
+	if h.state == nil {
+		*h = NewHeaders()
+	}
+
+	h.state.decodeRaw()
+	h.state.lock.Lock()
+	if h.state.shared {
+		h.state.data = h.state.data.clone()
+		h.state.shared = false
+	}
+	return h.state.data, h.state.lock.Unlock
+}
+
+func (d *headersData) clone() *headersData {
+	cloned := &headersData{
+		names:  append([]string(nil), d.names...),
+		values: make(map[string][]interface{}, len(d.values)),
+	}
+
+	for key, values := range d.values {
+		cloned.values[key] = append([]interface{}(nil), values...)
+	}
+
+	return cloned
+}
+
+// remove the name of key from the insertion order
+func (d *headersData) removeName(key string) {
+	for idx, name := range d.names {
+		if canonicalKey(name) == key {
+			d.names = append(d.names[:idx], d.names[idx+1:]...)
+			return
+		}
+	}
+}
 
+func (h Headers) String() string {
 	var full []string
 
-	for _, key := range headers {
-		val := h.GetString(key)
-		full = append(full, fmt.Sprintf("%s=%s", key, val))
+	for _, key := range h.Keys() {
+		for _, val := range h.Values(key) {
+			full = append(full, fmt.Sprintf("%s=%s", key, val))
+		}
 	}
 
-	return fmt.Sprintf("%s", strings.Join(full, " | "))
+	return strings.Join(full, " | ")
 }
 
-// Add a new header, or update an existed one
+// Add a new header, or update an existed one (replacing all of its values).
+// A []string value is added as multiple values.
 func (h *Headers) Add(key string, value interface{}) {
-	h.lock.Lock()
-	defer h.lock.Unlock()
+	data, release := h.write()
+	defer release()
+
+	canonical := canonicalKey(key)
+	if _, found := data.values[canonical]; !found {
+		data.names = append(data.names, key)
+	}
+	data.values[canonical] = expandValue(nil, value)
+}
+
+// Append adds another value to a header, or adds the header if it does not
+// exist. A []string value is appended as multiple values.
+func (h *Headers) Append(key string, value interface{}) {
+	data, release := h.write()
+	defer release()
+
+	canonical := canonicalKey(key)
+	if _, found := data.values[canonical]; !found {
+		data.names = append(data.names, key)
+	}
+	data.values[canonical] = expandValue(data.values[canonical], value)
+}
+
+func expandValue(values []interface{}, value interface{}) []interface{} {
+	items, ok := value.([]string)
+	if !ok {
+		return append(values, value)
+	}
 
-	h.header[key] = value
+	for _, item := range items {
+		values = append(values, item)
+	}
+	return values
+}
+
+// appendDecoded appends a value that arrived from Freeswitch, decoding
+// ARRAY:: values into multiple values
+func (h *Headers) appendDecoded(key, value string) {
+	if items, ok := DecodeArray(value); ok {
+		h.Append(key, items)
+		return
+	}
+	h.Append(key, value)
 }
 
-// Get a header, if not found, return nil
+// encoded returns the value of a header as sent to Freeswitch, multiple values
+// are encoded as ARRAY::
+func (h Headers) encoded(key string) string {
+	values := h.Values(key)
+	if len(values) > 1 {
+		return EncodeArray(values)
+	}
+	return h.GetString(key)
+}
+
+// Freeswitch encoding of multi valued variables: ARRAY::a|:b|:c
+const (
+	arrayPrefix    = "ARRAY::"
+	arraySeparator = "|:"
+)
+
+// DecodeArray decodes a Freeswitch ARRAY:: value (e.g. "ARRAY::a|:b|:c") into
+// its items. ok is false if value is not an ARRAY:: value.
+func DecodeArray(value string) (items []string, ok bool) {
+	if !strings.HasPrefix(value, arrayPrefix) {
+		return nil, false
+	}
+
+	return strings.Split(strings.TrimPrefix(value, arrayPrefix), arraySeparator), true
+}
+
+// EncodeArray encodes items as a Freeswitch ARRAY:: value.
+// Freeswitch has no escaping, so items must not contain "|:".
+func EncodeArray(items []string) string {
+	return arrayPrefix + strings.Join(items, arraySeparator)
+}
+
+// Get a header, if not found, return nil.
+// If the header has more than one value, the first one is returned.
 func (h Headers) Get(key string) interface{} {
-	h.lock.RLock()
-	defer h.lock.RUnlock()
+	data, release := h.read()
+	defer release()
+
+	values := data.values[canonicalKey(key)]
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
 
-	value, found := h.header[key]
-	if !found {
+// Values returns all the values of a header as strings, or nil if not found
+func (h Headers) Values(key string) []string {
+	data, release := h.read()
+	defer release()
+
+	values := data.values[canonicalKey(key)]
+	if len(values) == 0 {
 		return nil
 	}
-	return value
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, toString(value))
+	}
+	return result
 }
 
 // GetString return string value from headers. If not found, returns empty string
 func (h Headers) GetString(key string) string {
-	value := h.Get(key)
+	return toString(h.Get(key))
+}
 
+func toString(value interface{}) string {
 	val := reflect.ValueOf(value)
 	switch val.Kind() {
 	case reflect.String:
@@ -78,6 +280,8 @@ func (h Headers) GetString(key string) string {
 		f := val.Float()
 		s := strconv.FormatFloat(f, 'f', 3, 64)
 		return s
+	case reflect.Bool:
+		return strconv.FormatBool(val.Bool())
 	default:
 		return ""
 	}
@@ -110,40 +314,217 @@ func (h Headers) GetInt(key string) int64 {
 	}
 }
 
+// lookup returns the string value of a header, or ErrHeaderNotFound
+func (h Headers) lookup(key string) (string, error) {
+	value := h.Get(key)
+	if value == nil {
+		return "", fmt.Errorf("%w: %s", ErrHeaderNotFound, key)
+	}
+	return strings.TrimSpace(toString(value)), nil
+}
+
+func invalidHeader(key, value, expected string) error {
+	return fmt.Errorf("%w: %s is not %s: %q", ErrInvalidHeaderValue, key, expected, value)
+}
+
+// GetInt64 returns the value of a header as int64
+func (h Headers) GetInt64(key string) (int64, error) {
+	value, err := h.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, invalidHeader(key, value, "an integer")
+	}
+	return i, nil
+}
+
+// GetBool returns the value of a header as bool. Besides the values that
+// strconv.ParseBool accepts, yes/no, on/off, enabled/disabled and
+// active/inactive are accepted (case insensitive), as used by Freeswitch.
+func (h Headers) GetBool(key string) (bool, error) {
+	value, err := h.lookup(key)
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(value) {
+	case "yes", "on", "enabled", "active", "allow":
+		return true, nil
+	case "no", "off", "disabled", "inactive", "deny":
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, invalidHeader(key, value, "a boolean")
+	}
+	return b, nil
+}
+
+// GetDuration returns the value of a header as duration.
+// A number is multiplied by unit (e.g. time.Second for billsec, or
+// time.Microsecond for *_usec variables), other values are parsed using
+// time.ParseDuration.
+func (h Headers) GetDuration(key string, unit time.Duration) (time.Duration, error) {
+	value, err := h.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(n * float64(unit)), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, invalidHeader(key, value, "a duration")
+	}
+	return d, nil
+}
+
+// Epoch thresholds that are used to find the unit of a timestamp
+const (
+	epochMilliThreshold = 100_000_000_000     // year 5138 in seconds
+	epochMicroThreshold = 100_000_000_000_000 // year 5138 in milliseconds
+)
+
+// headerTimeLayouts are the layouts of times that Freeswitch uses
+// (Event-Date-GMT, Event-Date-Local) and RFC 3339
+var headerTimeLayouts = []string{
+	time.RFC1123,
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+}
+
+// GetTime returns the value of a header as time.
+// Epoch numbers are accepted in seconds (e.g. *_epoch), milliseconds, or
+// microseconds (e.g. Event-Date-Timestamp and *_uepoch), based on their size.
+// Formatted times are accepted as RFC 1123 (Event-Date-GMT), RFC 3339, or
+// "2006-01-02 15:04:05" at the local time zone (Event-Date-Local).
+func (h Headers) GetTime(key string) (time.Time, error) {
+	value, err := h.lookup(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		switch {
+		case n >= epochMicroThreshold:
+			return time.UnixMicro(n), nil
+		case n >= epochMilliThreshold:
+			return time.UnixMilli(n), nil
+		default:
+			return time.Unix(n, 0), nil
+		}
+	}
+
+	for _, layout := range headerTimeLayouts {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, invalidHeader(key, value, "a time")
+}
+
+// GetUUID returns the value of a header if it is a valid UUID
+// (xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx), in lower case
+func (h Headers) GetUUID(key string) (string, error) {
+	value, err := h.lookup(key)
+	if err != nil {
+		return "", err
+	}
+
+	if !isUUID(value) {
+		return "", invalidHeader(key, value, "a UUID")
+	}
+	return strings.ToLower(value), nil
+}
+
+func isUUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+
+	for idx, c := range value {
+		switch idx {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			isHex := (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+			if !isHex {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // Remove a given key
 func (h *Headers) Remove(key string) {
-	h.lock.Lock()
-	defer h.lock.Unlock()
+	if h.state == nil {
+		return
+	}
+
+	data, release := h.write()
+	defer release()
 
-	delete(h.header, key)
+	canonical := canonicalKey(key)
+	if _, found := data.values[canonical]; !found {
+		return
+	}
+	delete(data.values, canonical)
+	data.removeName(canonical)
 }
 
 // Len returns the length of current headers
 func (h Headers) Len() int {
-	h.lock.RLock()
-	defer h.lock.RUnlock()
-	return len(h.header)
+	data, release := h.read()
+	defer release()
+
+	return len(data.names)
 }
 
 // Exists a given key at the headers
-func (h *Headers) Exists(key string) bool {
-	h.lock.RLock()
-	defer h.lock.RUnlock()
+func (h Headers) Exists(key string) bool {
+	data, release := h.read()
+	defer release()
 
-	_, exists := h.header[key]
+	_, exists := data.values[canonicalKey(key)]
 	return exists
 }
 
-// Keys returns a list of all existed keys available
-func (h *Headers) Keys() []string {
-	h.lock.RLock()
-	defer h.lock.RUnlock()
+// Keys returns a list of all existed keys available, by the order they were
+// added
+func (h Headers) Keys() []string {
+	data, release := h.read()
+	defer release()
 
-	var keys []string
+	return append([]string(nil), data.names...)
+}
 
-	for key := range h.header {
-		keys = append(keys, key)
+// Snapshot returns an independent copy of the headers. The content is copied
+// only when either of them is modified, so taking a snapshot is cheap.
+func (h Headers) Snapshot() Headers {
+	if h.state == nil {
+		return NewHeaders()
 	}
 
-	return keys
+	h.state.decodeRaw()
+	h.state.lock.Lock()
+	defer h.state.lock.Unlock()
+
+	h.state.shared = true
+	return Headers{
+		state: &headersState{
+			data:   h.state.data,
+			shared: true,
+		},
+	}
 }
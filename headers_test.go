@@ -1,8 +1,10 @@
 package esl
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHeadersGetString(t *testing.T) {
@@ -218,3 +220,163 @@ func TestHeadersLen(t *testing.T) {
 		return
 	}
 }
+
+func TestHeadersCaseInsensitive(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("Unique-ID", "a")
+
+	if headers.GetString("unique-id") != "a" || !headers.Exists("UNIQUE-ID") {
+		t.Errorf("Expected keys to be case insensitive")
+	}
+
+	headers.Add("unique-id", "b")
+	if headers.Len() != 1 || headers.GetString("Unique-ID") != "b" {
+		t.Errorf("Expected the value to be replaced: %s", headers)
+	}
+
+	if keys := headers.Keys(); len(keys) != 1 || keys[0] != "Unique-ID" {
+		t.Errorf("Expected the first name to be kept, got: %v", keys)
+	}
+
+	headers.Remove("UNIQUE-id")
+	if headers.Len() != 0 || len(headers.Keys()) != 0 {
+		t.Errorf("Expected the header to be removed: %s", headers)
+	}
+}
+
+func TestHeadersOrderAndValues(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("c", "1")
+	headers.Add("a", "2")
+	headers.Append("b", "3")
+	headers.Append("a", "4")
+
+	if s := headers.String(); s != "c=1 | a=2 | a=4 | b=3" {
+		t.Errorf("Unexpected order: %s", s)
+	}
+
+	if values := headers.Values("A"); strings.Join(values, ",") != "2,4" {
+		t.Errorf("Unexpected values: %v", values)
+	}
+
+	if headers.GetString("a") != "2" {
+		t.Errorf("Expected the first value, got: %s", headers.GetString("a"))
+	}
+
+	if headers.Values("missing") != nil {
+		t.Errorf("Expected no values for missing header")
+	}
+}
+
+func TestHeadersSnapshot(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("foo", "bar")
+
+	snapshot := headers.Snapshot()
+	headers.Add("foo", "baz")
+	headers.Add("new", "value")
+
+	if snapshot.GetString("foo") != "bar" || snapshot.Exists("new") {
+		t.Errorf("Snapshot was modified: %s", snapshot)
+	}
+
+	snapshot.Append("foo", "qux")
+	if headers.Len() != 2 || len(headers.Values("foo")) != 1 {
+		t.Errorf("Original was modified by snapshot: %s", headers)
+	}
+
+	var empty Headers
+	empty.Add("foo", "bar")
+	if empty.GetString("foo") != "bar" {
+		t.Errorf("Expected zero value headers to be usable")
+	}
+}
+
+func TestHeadersTypedGetters(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("int", "42")
+	headers.Add("bad", "forty two")
+	headers.Add("bool", "Yes")
+	headers.Add("billsec", "90")
+	headers.Add("timeout", "1m30s")
+	headers.Add("Event-Date-Timestamp", "1600000000123456")
+	headers.Add("start_epoch", "1600000000")
+	headers.Add("Event-Date-GMT", "Sun, 13 Sep 2020 12:26:40 GMT")
+	headers.Add("Unique-ID", "7F4DE4BC-17D7-11EB-9D1E-5B6D79A3A8F4")
+
+	i, err := headers.GetInt64("int")
+	if err != nil || i != 42 {
+		t.Errorf("Unexpected int: %d %v", i, err)
+	}
+
+	_, err = headers.GetInt64("bad")
+	if !errors.Is(err, ErrInvalidHeaderValue) {
+		t.Errorf("Expected ErrInvalidHeaderValue, got: %v", err)
+	}
+
+	_, err = headers.GetInt64("missing")
+	if !errors.Is(err, ErrHeaderNotFound) {
+		t.Errorf("Expected ErrHeaderNotFound, got: %v", err)
+	}
+
+	b, err := headers.GetBool("bool")
+	if err != nil || !b {
+		t.Errorf("Unexpected bool: %t %v", b, err)
+	}
+
+	d, err := headers.GetDuration("billsec", time.Second)
+	if err != nil || d != 90*time.Second {
+		t.Errorf("Unexpected duration: %s %v", d, err)
+	}
+
+	d, err = headers.GetDuration("timeout", time.Second)
+	if err != nil || d != 90*time.Second {
+		t.Errorf("Unexpected duration: %s %v", d, err)
+	}
+
+	expected := time.Unix(1600000000, 0)
+	for _, key := range []string{"Event-Date-Timestamp", "start_epoch", "Event-Date-GMT"} {
+		ts, err := headers.GetTime(key)
+		if err != nil || !ts.Truncate(time.Second).Equal(expected) {
+			t.Errorf("Unexpected time of %s: %s %v", key, ts, err)
+		}
+	}
+
+	uuid, err := headers.GetUUID("Unique-ID")
+	if err != nil || uuid != "7f4de4bc-17d7-11eb-9d1e-5b6d79a3a8f4" {
+		t.Errorf("Unexpected uuid: %s %v", uuid, err)
+	}
+
+	_, err = headers.GetUUID("int")
+	if !errors.Is(err, ErrInvalidHeaderValue) {
+		t.Errorf("Expected ErrInvalidHeaderValue, got: %v", err)
+	}
+}
+
+func TestHeadersArray(t *testing.T) {
+	items, ok := DecodeArray("ARRAY::a|:b|:c")
+	if !ok || strings.Join(items, ",") != "a,b,c" {
+		t.Errorf("Unexpected items: %v %t", items, ok)
+	}
+
+	_, ok = DecodeArray("a|:b")
+	if ok {
+		t.Errorf("Expected a plain value not to be decoded")
+	}
+
+	if s := EncodeArray([]string{"a", "b"}); s != "ARRAY::a|:b" {
+		t.Errorf("Unexpected encoding: %s", s)
+	}
+
+	headers := NewHeaders()
+	headers.Add("codecs", []string{"PCMU", "PCMA"})
+	headers.Add("single", "PCMU")
+
+	if s := headers.encoded("codecs"); s != "ARRAY::PCMU|:PCMA" {
+		t.Errorf("Unexpected encoded value: %s", s)
+	}
+
+	if s := headers.encoded("single"); s != "PCMU" {
+		t.Errorf("Unexpected encoded value: %s", s)
+	}
+}
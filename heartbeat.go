@@ -0,0 +1,364 @@
+package esl
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Current file contains the liveness watchdog of a connection.
+//
+// TCP keep-alive does not detect a Freeswitch that hangs while its socket is
+// still open. Freeswitch fires a HEARTBEAT event every 20 seconds (see
+// event-heartbeat-interval at switch.conf.xml), so a connection that is
+// subscribed to HEARTBEAT and did not receive it for a while is dead. A
+// connection without events can send "api status" instead.
+
+// HeartbeatEvent is the name of the event that Freeswitch fires periodically
+const HeartbeatEvent = "HEARTBEAT"
+
+// Heartbeat holds the status of a server, as arrived by a HEARTBEAT event or
+// by the "status" api command
+type Heartbeat struct {
+	// Hostname and CoreUUID are set only by HEARTBEAT events
+	Hostname string
+	CoreUUID string
+	Version  string
+	// Ready is false while Freeswitch is shutting down
+	Ready  bool
+	Uptime time.Duration
+
+	// Sessions is the number of active sessions (channels)
+	Sessions             int64
+	MaxSessions          int64
+	SessionsSinceStartup int64
+	SessionsPeak         int64
+	SessionsPeakFiveMin  int64
+	SessionsPerSecond    int64
+	// SessionsPerSecondPeak is the max sessions per second since startup
+	SessionsPerSecondPeak int64
+
+	// IdleCPU is the idle CPU percentage
+	IdleCPU float64
+	// Interval is the interval of HEARTBEAT events, set only by events
+	Interval time.Duration
+}
+
+// HeartbeatFromEvent decodes a HEARTBEAT event into a Heartbeat.
+func HeartbeatFromEvent(msg *Message) (Heartbeat, error) {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return Heartbeat{}, err
+	}
+
+	name := headers.GetString("Event-Name")
+	if name != HeartbeatEvent {
+		return Heartbeat{}, fmt.Errorf("%w: expected %s, got %s", ErrUnexpectedEvent, HeartbeatEvent, name)
+	}
+
+	idleCPU, _ := strconv.ParseFloat(headers.GetString("Idle-CPU"), 64)
+
+	return Heartbeat{
+		Hostname:              headers.GetString("FreeSWITCH-Hostname"),
+		CoreUUID:              headers.GetString("Core-UUID"),
+		Version:               headers.GetString("FreeSWITCH-Version"),
+		Ready:                 headers.GetString("Event-Info") == "System Ready",
+		Uptime:                time.Duration(headers.GetInt("Uptime-msec")) * time.Millisecond,
+		Sessions:              headers.GetInt("Session-Count"),
+		MaxSessions:           headers.GetInt("Max-Sessions"),
+		SessionsSinceStartup:  headers.GetInt("Session-Since-Startup"),
+		SessionsPeak:          headers.GetInt("Session-Peak-Max"),
+		SessionsPeakFiveMin:   headers.GetInt("Session-Peak-FiveMin"),
+		SessionsPerSecond:     headers.GetInt("Session-Per-Sec"),
+		SessionsPerSecondPeak: headers.GetInt("Session-Per-Sec-Max"),
+		IdleCPU:               idleCPU,
+		Interval:              time.Duration(headers.GetInt("Heartbeat-Interval")) * time.Second,
+	}, nil
+}
+
+// uptimeUnits are the units of the "UP" line of "status"
+var uptimeUnits = map[string]time.Duration{
+	"year":        365 * 24 * time.Hour,
+	"day":         24 * time.Hour,
+	"hour":        time.Hour,
+	"minute":      time.Minute,
+	"second":      time.Second,
+	"millisecond": time.Millisecond,
+	"microsecond": time.Microsecond,
+}
+
+// ParseStatus parses the output of the "status" api command:
+//
+//	UP 0 years, 0 days, 20 hours, 20 minutes, 31 seconds, 571 milliseconds, 694 microseconds
+//	FreeSWITCH (Version 1.10.7 -release 64bit) is ready
+//	7 session(s) since startup
+//	0 session(s) - peak 2, last 5min 0
+//	0 session(s) per Sec out of max 30, peak 1, last 5min 0
+//	1000 session(s) max
+//	min idle cpu 0.00/99.00
+func ParseStatus(body string) (Heartbeat, error) {
+	var heartbeat Heartbeat
+	found := false
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		var n, peak, fiveMin, limit int64
+		var minIdle float64
+
+		switch {
+		case strings.HasPrefix(line, "UP "):
+			uptime, err := parseUptime(strings.TrimPrefix(line, "UP "))
+			if err != nil {
+				return Heartbeat{}, err
+			}
+			heartbeat.Uptime = uptime
+			found = true
+		case strings.HasPrefix(line, "FreeSWITCH (Version "):
+			version, state, _ := strings.Cut(strings.TrimPrefix(line, "FreeSWITCH (Version "), ")")
+			heartbeat.Version = version
+			heartbeat.Ready = strings.TrimSpace(state) == "is ready"
+		case strings.HasSuffix(line, "session(s) since startup"):
+			fmt.Sscanf(line, "%d", &heartbeat.SessionsSinceStartup)
+		case strings.Contains(line, "session(s) per Sec"):
+			_, err := fmt.Sscanf(line, "%d session(s) per Sec out of max %d, peak %d, last 5min %d", &n, &limit, &peak, &fiveMin)
+			if err == nil {
+				heartbeat.SessionsPerSecond = n
+				heartbeat.SessionsPerSecondPeak = peak
+			}
+		case strings.Contains(line, "session(s) - peak"):
+			_, err := fmt.Sscanf(line, "%d session(s) - peak %d, last 5min %d", &n, &peak, &fiveMin)
+			if err == nil {
+				heartbeat.Sessions = n
+				heartbeat.SessionsPeak = peak
+				heartbeat.SessionsPeakFiveMin = fiveMin
+			}
+		case strings.HasSuffix(line, "session(s) max"):
+			fmt.Sscanf(line, "%d", &heartbeat.MaxSessions)
+		case strings.HasPrefix(line, "min idle cpu "):
+			fmt.Sscanf(line, "min idle cpu %f/%f", &minIdle, &heartbeat.IdleCPU)
+		}
+	}
+
+	if !found {
+		return Heartbeat{}, fmt.Errorf("%w: missing uptime", ErrInvalidStatus)
+	}
+
+	return heartbeat, nil
+}
+
+// parseUptime parses "0 years, 0 days, 20 hours, ..."
+func parseUptime(s string) (time.Duration, error) {
+	var uptime time.Duration
+
+	for _, part := range strings.Split(s, ",") {
+		value, unit, found := strings.Cut(strings.TrimSpace(part), " ")
+		n, err := strconv.ParseInt(value, 10, 64)
+		if !found || err != nil {
+			return 0, fmt.Errorf("%w: invalid uptime %q", ErrInvalidStatus, s)
+		}
+
+		d, known := uptimeUnits[strings.TrimSuffix(unit, "s")]
+		if !known {
+			return 0, fmt.Errorf("%w: unknown uptime unit %q", ErrInvalidStatus, unit)
+		}
+		uptime += time.Duration(n) * d
+	}
+
+	return uptime, nil
+}
+
+// Status returns the status of the server using the "status" api command
+func (s *Socket) Status() (Heartbeat, error) {
+	body, err := s.apiBody("status", "")
+	if err != nil {
+		return Heartbeat{}, err
+	}
+
+	return ParseStatus(body)
+}
+
+// HeartbeatConfig is the config of the liveness watchdog
+type HeartbeatConfig struct {
+	// Timeout is the max time without a heartbeat before the connection is
+	// declared dead, 0 disables the watchdog. It should be longer than the
+	// heartbeat interval of Freeswitch (20 seconds by default).
+	Timeout time.Duration
+	// PollInterval sends "api status" every interval instead of subscribing
+	// to HEARTBEAT events, 0 uses the events
+	PollInterval time.Duration
+}
+
+// validate returns an error if the heartbeat config can not be used
+func (h HeartbeatConfig) validate() error {
+	if h.Timeout < 0 || h.PollInterval < 0 {
+		return fmt.Errorf("%w: heartbeat durations must not be negative", ErrInvalidConfig)
+	}
+	if h.Timeout > 0 && h.PollInterval >= h.Timeout {
+		return fmt.Errorf("%w: heartbeat poll interval must be shorter than its timeout", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Watchdog declares a connection dead when it did not receive a heartbeat
+// for a given timeout
+type Watchdog struct {
+	timeout time.Duration
+	onDead  func()
+
+	lock      sync.Mutex
+	timer     *time.Timer
+	last      time.Time
+	heartbeat Heartbeat
+	received  bool
+	stopped   bool
+	err       error
+
+	dead     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWatchdog starts a watchdog, that calls onDead (once) if Beat is not
+// called for timeout
+func NewWatchdog(timeout time.Duration, onDead func()) *Watchdog {
+	w := &Watchdog{
+		timeout: timeout,
+		onDead:  onDead,
+		last:    time.Now(),
+		dead:    make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+
+	w.lock.Lock()
+	w.timer = time.AfterFunc(timeout, w.expire)
+	w.lock.Unlock()
+
+	return w
+}
+
+// expire is called by the timer
+func (w *Watchdog) expire() {
+	w.lock.Lock()
+	if w.stopped || w.err != nil {
+		w.lock.Unlock()
+		return
+	}
+
+	// A heartbeat arrived while the timer fired
+	remaining := w.timeout - time.Since(w.last)
+	if remaining > 0 {
+		w.timer.Reset(remaining)
+		w.lock.Unlock()
+		return
+	}
+
+	w.err = fmt.Errorf("%w: no heartbeat for %s", ErrHeartbeatTimeout, w.timeout)
+	close(w.dead)
+	w.lock.Unlock()
+
+	if w.onDead != nil {
+		w.onDead()
+	}
+}
+
+// Beat records a heartbeat and restarts the timeout
+func (w *Watchdog) Beat(heartbeat Heartbeat) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.stopped || w.err != nil {
+		return
+	}
+
+	w.last = time.Now()
+	w.heartbeat = heartbeat
+	w.received = true
+	w.timer.Reset(w.timeout)
+}
+
+// Heartbeat returns the last heartbeat, and false if none arrived
+func (w *Watchdog) Heartbeat() (Heartbeat, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.heartbeat, w.received
+}
+
+// Err returns ErrHeartbeatTimeout if the connection was declared dead
+func (w *Watchdog) Err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.err
+}
+
+// Dead is closed when the connection was declared dead
+func (w *Watchdog) Dead() <-chan struct{} {
+	return w.dead
+}
+
+// Stop stops the watchdog without declaring the connection dead
+func (w *Watchdog) Stop() {
+	w.lock.Lock()
+	w.stopped = true
+	w.timer.Stop()
+	w.lock.Unlock()
+
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+// WatchSocket starts a watchdog that sends "api status" on the socket every
+// PollInterval (Timeout / 3 if not set), and closes the socket when it is
+// dead. The watchdog stops when the socket is closed.
+//
+// The socket must not be used for reading events, since the commands would
+// wait for the events reader.
+func WatchSocket(s *Socket, cfg HeartbeatConfig) (*Watchdog, error) {
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Timeout == 0 {
+		return nil, fmt.Errorf("%w: missing heartbeat timeout", ErrInvalidConfig)
+	}
+
+	interval := cfg.PollInterval
+	if interval == 0 {
+		interval = cfg.Timeout / 3
+	}
+
+	w := NewWatchdog(cfg.Timeout, func() { s.Close() })
+	go w.poll(interval, s.Status, func() bool { return s.State() >= StateClosing })
+
+	return w, nil
+}
+
+// poll calls status every interval until the watchdog is stopped or dead,
+// or closed returns true
+func (w *Watchdog) poll(interval time.Duration, status func() (Heartbeat, error), closed func() bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		heartbeat, err := status()
+		if err == nil {
+			w.Beat(heartbeat)
+		} else if closed() {
+			w.Stop()
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		case <-w.dead:
+			return
+		}
+	}
+}
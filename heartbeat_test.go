@@ -0,0 +1,323 @@
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testStatus = `UP 0 years, 1 day, 2 hours, 3 minutes, 4 seconds, 5 milliseconds, 6 microseconds
+FreeSWITCH (Version 1.10.7 -release 64bit) is ready
+7 session(s) since startup
+3 session(s) - peak 5, last 5min 4
+1 session(s) per Sec out of max 30, peak 2, last 5min 1
+1000 session(s) max
+min idle cpu 0.00/97.50
+Current Stack Size/Max 240K/8192K
+`
+
+// fakeHeartbeat returns a HEARTBEAT event frame
+func fakeHeartbeat(sessions int) string {
+	return fakeEvent(
+		"Event-Name: HEARTBEAT",
+		"Core-UUID: 3e1e2e2e-0000-0000-0000-000000000001",
+		"FreeSWITCH-Hostname: fs1",
+		"FreeSWITCH-Version: 1.10.7-release",
+		"Event-Info: System%20Ready",
+		"Up-Time: 0%20years,%200%20days",
+		"Uptime-msec: 93784005",
+		fmt.Sprintf("Session-Count: %d", sessions),
+		"Max-Sessions: 1000",
+		"Session-Per-Sec: 1",
+		"Session-Per-Sec-Max: 2",
+		"Session-Since-Startup: 7",
+		"Session-Peak-Max: 5",
+		"Session-Peak-FiveMin: 4",
+		"Idle-CPU: 97.500000",
+		"Heartbeat-Interval: 20",
+	)
+}
+
+func TestHeartbeatFromEvent(t *testing.T) {
+	msg, err := NewMessage([]byte(fakeHeartbeat(3)), true)
+	if err != nil {
+		t.Fatalf("Unable to parse message: %s", err)
+	}
+
+	heartbeat, err := HeartbeatFromEvent(msg)
+	if err != nil {
+		t.Fatalf("Unable to decode event: %s", err)
+	}
+
+	expected := Heartbeat{
+		Hostname:              "fs1",
+		CoreUUID:              "3e1e2e2e-0000-0000-0000-000000000001",
+		Version:               "1.10.7-release",
+		Ready:                 true,
+		Uptime:                93784005 * time.Millisecond,
+		Sessions:              3,
+		MaxSessions:           1000,
+		SessionsSinceStartup:  7,
+		SessionsPeak:          5,
+		SessionsPeakFiveMin:   4,
+		SessionsPerSecond:     1,
+		SessionsPerSecondPeak: 2,
+		IdleCPU:               97.5,
+		Interval:              20 * time.Second,
+	}
+	if heartbeat != expected {
+		t.Errorf("Unexpected heartbeat:\n%+v\nexpected:\n%+v", heartbeat, expected)
+	}
+
+	msg, _ = NewMessage([]byte(fakeEvent("Event-Name: CHANNEL_CREATE")), true)
+	_, err = HeartbeatFromEvent(msg)
+	if !errors.Is(err, ErrUnexpectedEvent) {
+		t.Errorf("Expected ErrUnexpectedEvent, got: %v", err)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	heartbeat, err := ParseStatus(testStatus)
+	if err != nil {
+		t.Fatalf("Unable to parse status: %s", err)
+	}
+
+	expected := Heartbeat{
+		Version:               "1.10.7 -release 64bit",
+		Ready:                 true,
+		Uptime:                93784005006 * time.Microsecond,
+		Sessions:              3,
+		MaxSessions:           1000,
+		SessionsSinceStartup:  7,
+		SessionsPeak:          5,
+		SessionsPeakFiveMin:   4,
+		SessionsPerSecond:     1,
+		SessionsPerSecondPeak: 2,
+		IdleCPU:               97.5,
+	}
+	if heartbeat != expected {
+		t.Errorf("Unexpected heartbeat:\n%+v\nexpected:\n%+v", heartbeat, expected)
+	}
+
+	heartbeat, err = ParseStatus(strings.Replace(testStatus, "is ready", "is not ready", 1))
+	if err != nil || heartbeat.Ready {
+		t.Errorf("Expected a server that is not ready: %+v %v", heartbeat, err)
+	}
+
+	invalid := []string{
+		"",
+		"-ERR no reply\n",
+		"UP 1 fortnight\n",
+		"UP many years\n",
+	}
+	for _, body := range invalid {
+		_, err := ParseStatus(body)
+		if !errors.Is(err, ErrInvalidStatus) {
+			t.Errorf("Expected ErrInvalidStatus for %q, got: %v", body, err)
+		}
+	}
+}
+
+func TestHeartbeatConfigValidate(t *testing.T) {
+	fixtures := []struct {
+		config HeartbeatConfig
+		valid  bool
+	}{
+		{config: HeartbeatConfig{}, valid: true},
+		{config: HeartbeatConfig{Timeout: time.Minute}, valid: true},
+		{config: HeartbeatConfig{Timeout: time.Minute, PollInterval: 10 * time.Second}, valid: true},
+		{config: HeartbeatConfig{Timeout: -time.Minute}, valid: false},
+		{config: HeartbeatConfig{Timeout: time.Minute, PollInterval: -time.Second}, valid: false},
+		{config: HeartbeatConfig{Timeout: time.Second, PollInterval: time.Second}, valid: false},
+	}
+
+	for _, fixture := range fixtures {
+		err := NewConfig("127.0.0.1", fakePassword, WithHeartbeat(fixture.config)).Validate()
+		if fixture.valid != (err == nil) {
+			t.Errorf("Unexpected validation of %+v: %v", fixture.config, err)
+		}
+	}
+
+	cfg, err := ParseConfig([]byte(`{"host": "127.0.0.1", "heartbeat": {"timeout": "1m", "poll_interval": "15s"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cfg.Heartbeat != (HeartbeatConfig{Timeout: time.Minute, PollInterval: 15 * time.Second}) {
+		t.Errorf("Unexpected heartbeat: %+v", cfg.Heartbeat)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	var deadCount atomic.Int32
+	w := NewWatchdog(50*time.Millisecond, func() { deadCount.Add(1) })
+
+	_, received := w.Heartbeat()
+	if received {
+		t.Error("Expected no heartbeat")
+	}
+
+	// Heartbeats keep the watchdog alive
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		w.Beat(Heartbeat{Sessions: int64(i)})
+	}
+	if w.Err() != nil || deadCount.Load() != 0 {
+		t.Fatalf("Watchdog expired while receiving heartbeats: %v", w.Err())
+	}
+
+	heartbeat, received := w.Heartbeat()
+	if !received || heartbeat.Sessions != 4 {
+		t.Errorf("Unexpected heartbeat: %+v", heartbeat)
+	}
+
+	select {
+	case <-w.Dead():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watchdog did not expire")
+	}
+
+	if !errors.Is(w.Err(), ErrHeartbeatTimeout) || deadCount.Load() != 1 {
+		t.Errorf("Unexpected state: %v %d", w.Err(), deadCount.Load())
+	}
+
+	// A late heartbeat does not revive the watchdog
+	w.Beat(Heartbeat{})
+	if w.Err() == nil {
+		t.Error("Watchdog was revived")
+	}
+}
+
+func TestWatchdogStop(t *testing.T) {
+	var deadCount atomic.Int32
+	w := NewWatchdog(10*time.Millisecond, func() { deadCount.Add(1) })
+	w.Stop()
+	w.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	if w.Err() != nil || deadCount.Load() != 0 {
+		t.Errorf("Stopped watchdog expired: %v", w.Err())
+	}
+}
+
+func TestWatchSocket(t *testing.T) {
+	var hang atomic.Bool
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "api status" && !hang.Load() {
+			return fakeAPIResponse(testStatus)
+		}
+		return ""
+	})
+	socket := connectFakeServer(t, server)
+
+	_, err := WatchSocket(socket, HeartbeatConfig{})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got: %v", err)
+	}
+
+	w, err := WatchSocket(socket, HeartbeatConfig{Timeout: 100 * time.Millisecond, PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Unable to watch socket: %s", err)
+	}
+
+	waitFor(t, "heartbeat", func() bool {
+		heartbeat, received := w.Heartbeat()
+		return received && heartbeat.Sessions == 3
+	})
+
+	// The server hangs while the connection is open
+	hang.Store(true)
+
+	select {
+	case <-w.Dead():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watchdog did not expire")
+	}
+
+	waitFor(t, "close", func() bool { return socket.State() == StateClosed })
+}
+
+func TestClientHeartbeatEvents(t *testing.T) {
+	server := newFakeClientServer(t)
+	metrics := NewMetrics()
+
+	cfg := NewConfig(server.Addr(), fakePassword,
+		WithMetrics(metrics),
+		WithHeartbeat(HeartbeatConfig{Timeout: 200 * time.Millisecond}),
+		WithBackoff(BackoffConfig{InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond}),
+	)
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	found := false
+	for _, cmd := range server.Commands() {
+		if cmd == "event plain HEARTBEAT" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("HEARTBEAT was not subscribed: %v", server.Commands())
+	}
+
+	// Heartbeats keep the connections alive
+	for i := 0; i < 10; i++ {
+		server.Publish(fakeHeartbeat(3))
+		time.Sleep(40 * time.Millisecond)
+	}
+	if metrics.Snapshot().Reconnects != 0 {
+		t.Fatalf("Client reconnected while receiving heartbeats")
+	}
+
+	heartbeat, received := client.Heartbeat()
+	if !received || heartbeat.Hostname != "fs1" || heartbeat.Sessions != 3 {
+		t.Errorf("Unexpected heartbeat: %+v", heartbeat)
+	}
+
+	// Without heartbeats the connections are dead, so they are connected and
+	// subscribed again
+	waitFor(t, "reconnect", func() bool { return metrics.Snapshot().Reconnects > 0 })
+	waitFor(t, "subscription", func() bool { return server.Subscribers() == 1 })
+}
+
+func TestClientHeartbeatPoll(t *testing.T) {
+	var hang atomic.Bool
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "api status" {
+			if hang.Load() {
+				return ""
+			}
+			return fakeAPIResponse(testStatus)
+		}
+		return fakeCommandReply("+OK " + cmd)
+	})
+	metrics := NewMetrics()
+
+	cfg := NewConfig(server.Addr(), fakePassword,
+		WithMetrics(metrics),
+		WithReadTimeout(50*time.Millisecond),
+		WithHeartbeat(HeartbeatConfig{Timeout: 200 * time.Millisecond, PollInterval: 20 * time.Millisecond}),
+		WithBackoff(BackoffConfig{InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond}),
+	)
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	waitFor(t, "heartbeat", func() bool {
+		heartbeat, received := client.Heartbeat()
+		return received && heartbeat.Sessions == 3
+	})
+
+	if server.Subscribers() != 0 {
+		t.Errorf("Events were subscribed while polling: %v", server.Commands())
+	}
+
+	hang.Store(true)
+	waitFor(t, "reconnect", func() bool { return metrics.Snapshot().Reconnects > 0 })
+}
@@ -1,6 +1,7 @@
 package esl
 
 import (
+	"context"
 	"fmt"
 	"strings"
 )
@@ -8,19 +9,27 @@ import (
 // Current file contains the implementation of ESL.i interface.
 // the file is part of sockets.go but focuses only on the interface
 
-// SendRecv sends a content and wait for returns an answer
-func (s Socket) SendRecv(cmd string) (int, []byte, error) {
-	err := s.Send(cmd)
+// SendRecv sends a content and wait for returns an answer, the raw content
+// of its reply. Events that arrive meanwhile are kept for ReadMessage.
+func (s *Socket) SendRecv(cmd string) (int, []byte, error) {
+	n, msg, err := s.roundTrip(context.Background(), cmd, func() error {
+		return s.Send(cmd)
+	})
 	if err != nil {
-		return 0, nil, err
+		return n, nil, err
 	}
-
-	return s.Recv(MaxBufferSize)
+	return n, msg.buf, nil
 }
 
 // API sends the api commands
-func (s Socket) API(cmd string, args string) (*Message, error) {
-	_, msg, err := s.SendCommands("api", cmd, args)
+func (s *Socket) API(cmd string, args string) (*Message, error) {
+	return s.APIContext(context.Background(), cmd, args)
+}
+
+// APIContext sends the api commands, and traces it as a child of the span
+// that ctx holds
+func (s *Socket) APIContext(ctx context.Context, cmd string, args string) (*Message, error) {
+	_, msg, err := s.SendCommandsContext(ctx, "api", cmd, args)
 
 	if err != nil {
 		return nil, err
@@ -29,9 +38,73 @@ func (s Socket) API(cmd string, args string) (*Message, error) {
 	return msg, err
 }
 
+// apiBody sends an api command and returns its body, or the error that was
+// returned by Freeswitch
+func (s *Socket) apiBody(cmd string, args string) (string, error) {
+	msg, err := s.API(cmd, args)
+	if err != nil {
+		return "", err
+	}
+
+	if msg.HasError() {
+		return "", msg.Error()
+	}
+
+	return string(msg.Body), nil
+}
+
+// apiArgsBody is like apiBody, for an api command that is built from safe
+// arguments (see BuildCommand)
+func (s *Socket) apiArgsBody(cmd string, args ...Arg) (string, error) {
+	msg, err := s.APIArgs(cmd, args...)
+	if err != nil {
+		return "", err
+	}
+
+	if msg.HasError() {
+		return "", msg.Error()
+	}
+
+	return string(msg.Body), nil
+}
+
+// SetVar sets a channel variable using uuid_setvar. More than one value is
+// sent as an ARRAY:: value.
+func (s *Socket) SetVar(uuid, name string, values ...string) (*Message, error) {
+	value := strings.Join(values, "")
+	if len(values) > 1 {
+		value = EncodeArray(values)
+	}
+
+	return s.APIArgs("uuid_setvar", RawArg(uuid), RawArg(name), RawArg(value))
+}
+
+// Dump returns the channel data of uuid_dump as headers, ARRAY:: values are
+// decoded into multiple values (see Headers.Values)
+func (s *Socket) Dump(uuid string) (Headers, error) {
+	body, err := s.apiBody("uuid_dump", uuid)
+	if err != nil {
+		return Headers{}, err
+	}
+
+	dump := &Message{Headers: NewHeaders()}
+	err = parsePlainEvent(dump, []byte(body))
+	if err != nil {
+		return Headers{}, err
+	}
+
+	return dump.Headers, nil
+}
+
 // BgAPI sends the bgapi commands
-func (s Socket) BgAPI(cmd string, args string) (*Message, error) {
-	_, msg, err := s.SendCommands("bgapi", cmd, args)
+func (s *Socket) BgAPI(cmd string, args string) (*Message, error) {
+	return s.BgAPIContext(context.Background(), cmd, args)
+}
+
+// BgAPIContext sends the bgapi commands, and traces it as a child of the span
+// that ctx holds
+func (s *Socket) BgAPIContext(ctx context.Context, cmd string, args string) (*Message, error) {
+	_, msg, err := s.SendCommandsContext(ctx, "bgapi", cmd, args)
 
 	if err != nil {
 		return nil, err
@@ -41,14 +114,14 @@ func (s Socket) BgAPI(cmd string, args string) (*Message, error) {
 }
 
 // Filter supports the simple filter
-func (s Socket) Filter(eventName, valueToFilter string) (*Message, error) {
+func (s *Socket) Filter(eventName, valueToFilter string) (*Message, error) {
 	_, msg, err := s.SendCommands("filter", eventName, valueToFilter)
 	return msg, err
 }
 
 // FilterWithOutput execute filter command with output type (plain -
 // default, XML and JSON)
-func (s Socket) FilterWithOutput(outputType EventOutputType, eventName, valueToFilter string) (*Message, error) {
+func (s *Socket) FilterWithOutput(outputType EventOutputType, eventName, valueToFilter string) (*Message, error) {
 	_, msg, err := s.SendCommands("filter", string(outputType), fmt.Sprintf("%s %s", eventName, valueToFilter))
 	return msg, err
 }
@@ -56,33 +129,127 @@ func (s Socket) FilterWithOutput(outputType EventOutputType, eventName, valueToF
 // FilterDelete Specify the events which you want to revoke the filter.
 // filter delete can be used when some filters are applied wrongly or when
 // there is no use of the filter.
-func (s Socket) FilterDelete(eventName, valueToFilter string) (*Message, error) {
+func (s *Socket) FilterDelete(eventName, valueToFilter string) (*Message, error) {
 	_, msg, err := s.SendCommands("filter", "delete", fmt.Sprintf("%s %s", eventName, valueToFilter))
 	return msg, err
 }
 
+// Events subscribe the connection to the given events (e.g. "ALL",
+// "CHANNEL_CREATE", "CUSTOM sofia::gateway_state") using the output type.
+//
+// After subscribing, the events arrive to the connection and can be read using
+// ReadMessage.
+func (s *Socket) Events(outputType EventOutputType, events ...string) (*Message, error) {
+	err := validateArgs("event", append([]string{string(outputType)}, events...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, msg, err := s.sendCommand(fmt.Sprintf("event %s %s", outputType, strings.Join(events, " ")))
+	return msg, err
+}
+
+// Subscribe subscribe the connection to the given events using the event
+// format of the config (plain by default)
+func (s *Socket) Subscribe(events ...string) (*Message, error) {
+	format := s.eventFormat
+	if format == "" {
+		format = EOTPlain
+	}
+
+	return s.Events(format, events...)
+}
+
+// NoEvents disable all events that were subscribed by the connection
+func (s *Socket) NoEvents() (*Message, error) {
+	_, msg, err := s.sendCommand("noevents")
+	return msg, err
+}
+
+// Log enable log output at the given level (0-7 or a name such as "debug").
+// The logs arrive as log/data messages.
+func (s *Socket) Log(level string) (*Message, error) {
+	err := validateArgs("log", level)
+	if err != nil {
+		return nil, err
+	}
+
+	_, msg, err := s.sendCommand("log " + level)
+	return msg, err
+}
+
+// NoLog disable log output
+func (s *Socket) NoLog() (*Message, error) {
+	_, msg, err := s.sendCommand("nolog")
+	return msg, err
+}
+
 // SendEvent Send an event into the event system (multi line input for headers).
-func (s Socket) SendEvent(eventName string, headers Headers, body string) (*Message, error) {
+//
+// The event name, header names and values must not contain CR or LF, the
+// body can hold any content.
+func (s *Socket) SendEvent(eventName string, headers Headers, body string) (*Message, error) {
+	err := validateArgs("sendevent", eventName)
+	if err != nil {
+		return nil, err
+	}
 
 	var hdrs strings.Builder
 	for _, header := range headers.Keys() {
-		hdrs.WriteString(header + ": ")
-		hdrs.WriteString(headers.GetString(header))
-		hdrs.WriteString(EOL)
+		// Content-Length is set based on the body
+		if strings.EqualFold(header, "Content-Length") {
+			continue
+		}
+
+		value := headers.encoded(header)
+		err = validateArgs("sendevent", header, value)
+		if err != nil {
+			return nil, err
+		}
+
+		hdrs.WriteString(EOL + header + ": ")
+		hdrs.WriteString(value)
 	}
 
-	// Add content length if ther is a body, but no content length, so the
-	// action will work well.
-	if body != "" && !headers.Exists("Content-Length") {
-		hdrs.WriteString(fmt.Sprintf("Content-Length: %d%s", len(body), EOL))
+	toSend := fmt.Sprintf("sendevent %s%s", eventName, hdrs.String())
+
+	if body != "" {
+		_, msg, err := s.sendCommandBody(toSend, body)
+		return msg, err
 	}
 
-	toSend := fmt.Sprintf("%s%s", EOL, hdrs)
+	_, msg, err := s.sendCommand(toSend)
+	return msg, err
+}
 
-	if body != "" {
-		toSend = fmt.Sprintf("%s%s%s", toSend, EOL, body)
+// maxInlineAppArg is the max length of an application argument that is sent
+// as a header, longer arguments are sent in the body
+const maxInlineAppArg = 1024
+
+// Execute runs a dialplan application on a channel (sendmsg with
+// call-command execute). Long or multi line arguments are sent in the body
+// of the message, so they are never parsed as commands.
+func (s *Socket) Execute(uuid, app, arg string) (*Message, error) {
+	err := validateArgs("sendmsg", uuid, app)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ContainsAny(uuid, " \t") || strings.ContainsAny(app, " \t") {
+		return nil, &ArgumentError{Command: "sendmsg", Arg: uuid + " " + app, Reason: "contains spaces"}
+	}
+
+	cmd := fmt.Sprintf("sendmsg %s%scall-command: execute%sexecute-app-name: %s", uuid, EOL, EOL, app)
+
+	if len(arg) > maxInlineAppArg || strings.ContainsAny(arg, "\r\n") {
+		_, msg, err := s.sendCommandBody(cmd+EOL+"content-type: text/plain", arg)
+		return msg, err
+	}
+
+	if arg != "" {
+		cmd += EOL + "execute-app-arg: " + arg
 	}
 
-	_, msg, err := s.SendCommands("sendevent", eventName+EOL, toSend)
+	_, msg, err := s.sendCommand(cmd)
 	return msg, err
 }
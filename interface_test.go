@@ -211,3 +211,56 @@ func TestSocketBgAPIError(t *testing.T) {
 	}
 
 }
+
+func TestSocketArrayValues(t *testing.T) {
+	dump := "Event-Name: CHANNEL_DATA\nUnique-ID: a\nvariable_codecs: ARRAY%3A%3APCMU%7C%3APCMA\n"
+	server := newFakeServer(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "api uuid_dump") {
+			return fakeAPIResponse(dump)
+		}
+		return fakeAPIResponse("+OK")
+	})
+	socket := connectFakeServer(t, server)
+
+	headers, err := socket.Dump("a")
+	if err != nil {
+		t.Errorf("Unable to dump: %s", err)
+		return
+	}
+
+	if values := headers.Values("variable_codecs"); strings.Join(values, ",") != "PCMU,PCMA" {
+		t.Errorf("Unexpected values: %v", values)
+	}
+
+	_, err = socket.SetVar("a", "codecs", "PCMU", "PCMA")
+	if err != nil {
+		t.Errorf("Unable to set var: %s", err)
+	}
+
+	_, err = socket.SetVar("a", "foo", "bar")
+	if err != nil {
+		t.Errorf("Unable to set var: %s", err)
+	}
+
+	event := NewHeaders()
+	event.Add("Event-Subclass", "my::event")
+	event.Add("codecs", []string{"PCMU", "PCMA"})
+	_, err = socket.SendEvent("CUSTOM", event, "")
+	if err != nil {
+		t.Errorf("Unable to send event: %s", err)
+	}
+
+	commands := server.Commands()
+	expected := []string{
+		"api uuid_dump a",
+		"api uuid_setvar a codecs ARRAY::PCMU|:PCMA",
+		"api uuid_setvar a foo bar",
+		"sendevent CUSTOM\nEvent-Subclass: my::event\ncodecs: ARRAY::PCMU|:PCMA",
+	}
+	for idx, cmd := range expected {
+		if idx >= len(commands) || strings.TrimSpace(commands[idx]) != cmd {
+			t.Errorf("Expected command %q, got: %q", cmd, commands)
+			return
+		}
+	}
+}
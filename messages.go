@@ -17,15 +17,20 @@ type Message struct {
 	Body        []byte
 	Parsed      bool
 
-	buf []byte
-	tr  *textproto.Reader
+	buf     []byte
+	tr      *textproto.Reader
+	command string
 }
 
+// messageReaderSize is the max buffer size of the reader of NewMessage
+const messageReaderSize = 4096
+
 // NewMessage - Will build and execute parsing against received freeswitch message.
 // As return will give brand new Message{} for you to use it.
 func NewMessage(buf []byte, autoParse bool) (*Message, error) {
 
-	reader := bufio.NewReaderSize(bytes.NewReader(buf), int(MaxBufferSize))
+	// The reader is not larger than the message, long lines are read in parts
+	reader := bufio.NewReaderSize(bytes.NewReader(buf), min(len(buf), messageReaderSize))
 
 	msg := Message{
 		buf:     buf,
@@ -53,29 +58,34 @@ func (m *Message) String() string {
 // Parse out message received from ESL and make it Go friendly.
 func (m *Message) Parse() error {
 	var err error
-	var mime textproto.MIMEHeader
 
-	mime, err = m.tr.ReadMIMEHeader()
-	if err != nil {
-		if !errors.Is(err, io.EOF) {
-			return err
-		}
+	// Messages of Parser are already parsed
+	if m.tr == nil {
+		return nil
 	}
-	err = nil
-	m.Parsed = true
 
-	for key, values := range mime {
-		value := strings.Join(values, ";")
-		m.Headers.Add(key, value)
+	err = m.parseHeaders()
+	if err != nil {
+		return err
 	}
+	m.Parsed = true
 
 	if m.Headers.Exists("Content-Length") {
-		contentLength := m.Headers.GetInt("Content-Length")
+		contentLength, err := m.Headers.GetInt64("Content-Length")
+		if err != nil || contentLength < 0 {
+			return fmt.Errorf("%w: %q", ErrInvalidContentLength, m.Headers.GetString("Content-Length"))
+		}
 		if contentLength == 0 {
 			return ErrContentLengthZero
 		}
 
-		l := int(m.Headers.GetInt("Content-Length"))
+		// The body can not be longer than the message itself, so a huge
+		// Content-Length does not allocate more than needed. A shorter body is
+		// kept as is.
+		l := len(m.buf)
+		if contentLength < int64(l) {
+			l = int(contentLength)
+		}
 		lines := make([]byte, 0, l)
 
 		for err == nil {
@@ -100,6 +110,31 @@ func (m *Message) Parse() error {
 	return nil
 }
 
+// parseHeaders reads the headers up to an empty line, keeping their order
+// and repeated values
+func (m *Message) parseHeaders() error {
+	for {
+		line, err := m.tr.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if line == "" {
+			return nil
+		}
+
+		idx := strings.Index(line, ":")
+		if idx <= 0 {
+			return fmt.Errorf("%w: %q", ErrMalformedHeader, line)
+		}
+
+		m.Headers.Append(strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:]))
+	}
+}
+
 // ContentType returns the content type arrived, or empty string if not found
 func (m *Message) ContentType() EventContentType {
 	contentType := m.Headers.GetString("Content-Type")
@@ -116,7 +151,7 @@ func (m *Message) HasError() bool {
 	return strings.HasPrefix(err, "-ERR")
 }
 
-// Error return the message error msg or empty string if non found
+// Error returns a *CommandError if the message is an error reply, or nil
 func (m *Message) Error() error {
 	if !m.HasError() {
 		return nil
@@ -124,11 +159,20 @@ func (m *Message) Error() error {
 
 	switch m.ContentType() {
 	case ECTCommandReply:
-		err := m.Headers.GetString("Reply-Text")
-		return errors.New(err[5:])
+		return newCommandError(m.command, ECTCommandReply, m.Headers.GetString("Reply-Text"))
 	case ECTAPIResponse:
-		return errors.New(string(m.Body[5:]))
+		return newCommandError(m.command, ECTAPIResponse, string(m.Body))
 	default:
 		return nil
 	}
 }
+
+// errorText returns the text of an "-ERR <text>" reply, or the reply itself
+// if there is no text
+func errorText(reply string) string {
+	text := strings.TrimSpace(strings.TrimPrefix(reply, "-ERR"))
+	if text == "" {
+		return strings.TrimSpace(reply)
+	}
+	return text
+}
@@ -9,7 +9,7 @@ import (
 
 func TestSimpleMessageAutoParse(t *testing.T) {
 	type messageType struct {
-		Headers Headers
+		Headers map[string]string
 		Body    []byte
 		Parsed  bool
 	}
@@ -24,11 +24,9 @@ func TestSimpleMessageAutoParse(t *testing.T) {
 			expected: messageType{
 				Body:   nil,
 				Parsed: true,
-				Headers: Headers{
-					header: map[string]interface{}{
-						"Event-Name":   "SOCKET_DATA",
-						"Content-Type": "auth/request",
-					},
+				Headers: map[string]string{
+					"Event-Name":   "SOCKET_DATA",
+					"Content-Type": "auth/request",
 				},
 			},
 		},
@@ -51,7 +49,7 @@ func TestSimpleMessageAutoParse(t *testing.T) {
 			t.Errorf("Expected (%d) Body %+v is not %+v", idx, msg.Body, content.expected.Body)
 		}
 
-		for key, head := range content.expected.Headers.header {
+		for key, head := range content.expected.Headers {
 			head2 := msg.Headers.GetString(key)
 			if head != head2 {
 				t.Errorf("Expected (%d) header %s %+v is not %+v", idx, key, head2, head)
@@ -214,3 +212,60 @@ func TestMessageError(t *testing.T) {
 	}
 
 }
+
+func TestMessageErrorShortReply(t *testing.T) {
+	inputs := []string{
+		"Content-Type: command/reply\nReply-Text: -ERR\n",
+		"Content-Type: api/response\nContent-Length: 4\n\n-ERR",
+	}
+
+	for idx, input := range inputs {
+		msg, err := NewMessage([]byte(input), true)
+		if err != nil {
+			t.Errorf("Unable to parse message (%d): %s", idx, err)
+			continue
+		}
+
+		err = msg.Error()
+		if err == nil || err.Error() != "-ERR" {
+			t.Errorf("Unexpected error (%d): %v", idx, err)
+		}
+	}
+}
+
+func TestMessageParseInvalidContentLength(t *testing.T) {
+	for _, input := range []string{"Content-Length: -5\n\n", "Content-Length: abc\n\n"} {
+		_, err := NewMessage([]byte(input), true)
+		if !errors.Is(err, ErrInvalidContentLength) {
+			t.Errorf("Expected ErrInvalidContentLength for %q, got: %v", input, err)
+		}
+	}
+
+	_, err := NewMessage([]byte("no colon\n\n"), true)
+	if !errors.Is(err, ErrMalformedHeader) {
+		t.Errorf("Expected ErrMalformedHeader, got: %v", err)
+	}
+}
+
+func FuzzNewMessage(f *testing.F) {
+	f.Add([]byte("Content-Type: auth/request\n\n"))
+	f.Add([]byte("Content-Type: command/reply\nReply-Text: -ERR\n\n"))
+	f.Add([]byte("Content-Type: api/response\nContent-Length: 4\n\n-ERR"))
+	f.Add([]byte("Content-Length: 99999999999\n\n"))
+	f.Add([]byte("Content-Length: 40\nContent-Type: text/event-plain\n\nEvent-Name: X\nContent-Length: 999\n\nab"))
+	f.Add([]byte("Content-Length: 20\nContent-Type: text/event-json\n\n{\"Event-Name\":[1,2]}"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := NewMessage(data, true)
+		if err != nil {
+			return
+		}
+
+		_ = msg.String()
+		msg.HasError()
+		msg.Error()
+		if event, err := ParseEvent(msg); err == nil {
+			_ = event.Headers.String()
+		}
+	})
+}
@@ -0,0 +1,369 @@
+package esl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Current file contains the metrics support of Socket.
+// MetricsCollector is the interface that a socket reports into, and Metrics
+// is an in memory implementation that can be exported using the Prometheus
+// text format, without depending on the Prometheus client.
+
+// DefaultLatencyBuckets are the upper bounds (in seconds) of the command
+// latency histogram
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// MetricsCollector receives measurements from a socket.
+// The methods are called synchronously, so they must not block.
+type MetricsCollector interface {
+	// CommandDone is called when a reply for a command arrived (or failed).
+	// verb is the first word of the command (api, bgapi, sendmsg, filter ...),
+	// failed is true on send/receive errors and on -ERR replies.
+	CommandDone(verb string, latency time.Duration, failed bool)
+	// EventReceived is called for every event that arrived
+	EventReceived(eventName string)
+	// Reconnected is called by clients when a connection is reestablished
+	Reconnected()
+	// BytesSent is called with the number of bytes that were written
+	BytesSent(n int)
+	// BytesReceived is called with the number of bytes that were read
+	BytesReceived(n int)
+}
+
+// CommandStats holds the measurements of a single command verb
+type CommandStats struct {
+	Count   uint64
+	Errors  uint64
+	Latency time.Duration
+
+	// Buckets holds the cumulative count of commands per the upper bound of
+	// the latency buckets
+	Buckets []uint64
+}
+
+// ErrorRate returns the ratio of failed commands (0-1)
+func (c CommandStats) ErrorRate() float64 {
+	if c.Count == 0 {
+		return 0
+	}
+	return float64(c.Errors) / float64(c.Count)
+}
+
+// MetricsSnapshot is a copy of the measurements at a given time
+type MetricsSnapshot struct {
+	Commands      map[string]CommandStats
+	Events        map[string]uint64
+	Reconnects    uint64
+	BytesSent     uint64
+	BytesReceived uint64
+	Buckets       []float64
+
+	// EventsMissed and EventDuplicates are based on Event-Sequence (see
+	// SequenceTracker)
+	EventsMissed    uint64
+	EventDuplicates uint64
+	// EventsDropped is the number of events that were dropped by full queues
+	EventsDropped uint64
+}
+
+// Metrics is an in memory MetricsCollector
+type Metrics struct {
+	lock     sync.Mutex
+	buckets  []float64
+	commands map[string]*CommandStats
+	events   map[string]uint64
+
+	reconnects    uint64
+	bytesSent     uint64
+	bytesReceived uint64
+
+	eventsMissed    uint64
+	eventDuplicates uint64
+	eventsDropped   uint64
+}
+
+// NewMetrics creates a new Metrics with the given latency buckets (in
+// seconds). If no buckets are given, DefaultLatencyBuckets are used.
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return &Metrics{
+		buckets:  sorted,
+		commands: make(map[string]*CommandStats),
+		events:   make(map[string]uint64),
+	}
+}
+
+// CommandDone implements MetricsCollector
+func (m *Metrics) CommandDone(verb string, latency time.Duration, failed bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stats, found := m.commands[verb]
+	if !found {
+		stats = &CommandStats{Buckets: make([]uint64, len(m.buckets))}
+		m.commands[verb] = stats
+	}
+
+	stats.Count++
+	stats.Latency += latency
+	if failed {
+		stats.Errors++
+	}
+
+	seconds := latency.Seconds()
+	for idx, bound := range m.buckets {
+		if seconds <= bound {
+			stats.Buckets[idx]++
+		}
+	}
+}
+
+// EventReceived implements MetricsCollector
+func (m *Metrics) EventReceived(eventName string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.events[eventName]++
+}
+
+// Reconnected implements MetricsCollector
+func (m *Metrics) Reconnected() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.reconnects++
+}
+
+// EventsMissed implements SequenceCollector
+func (m *Metrics) EventsMissed(n uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.eventsMissed += n
+}
+
+// EventDuplicated implements SequenceCollector
+func (m *Metrics) EventDuplicated() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.eventDuplicates++
+}
+
+// EventsDropped implements QueueCollector
+func (m *Metrics) EventsDropped(n uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.eventsDropped += n
+}
+
+// BytesSent implements MetricsCollector
+func (m *Metrics) BytesSent(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.bytesSent += uint64(n)
+}
+
+// BytesReceived implements MetricsCollector
+func (m *Metrics) BytesReceived(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.bytesReceived += uint64(n)
+}
+
+// Snapshot returns a copy of the current measurements
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Commands:      make(map[string]CommandStats, len(m.commands)),
+		Events:        make(map[string]uint64, len(m.events)),
+		Reconnects:    m.reconnects,
+		BytesSent:     m.bytesSent,
+		BytesReceived: m.bytesReceived,
+		Buckets:       append([]float64(nil), m.buckets...),
+
+		EventsMissed:    m.eventsMissed,
+		EventDuplicates: m.eventDuplicates,
+		EventsDropped:   m.eventsDropped,
+	}
+
+	for verb, stats := range m.commands {
+		copied := *stats
+		copied.Buckets = append([]uint64(nil), stats.Buckets...)
+		snapshot.Commands[verb] = copied
+	}
+
+	for name, count := range m.events {
+		snapshot.Events[name] = count
+	}
+
+	return snapshot
+}
+
+// WritePrometheus writes the measurements using the Prometheus text
+// exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	var out strings.Builder
+
+	verbs := make([]string, 0, len(snapshot.Commands))
+	for verb := range snapshot.Commands {
+		verbs = append(verbs, verb)
+	}
+	sort.Strings(verbs)
+
+	writeHeader(&out, "esl_commands_total", "counter", "Number of commands that were sent, by verb.")
+	for _, verb := range verbs {
+		fmt.Fprintf(&out, "esl_commands_total{verb=\"%s\"} %d\n", escapeLabel(verb), snapshot.Commands[verb].Count)
+	}
+
+	writeHeader(&out, "esl_command_errors_total", "counter", "Number of commands that failed or returned -ERR, by verb.")
+	for _, verb := range verbs {
+		fmt.Fprintf(&out, "esl_command_errors_total{verb=\"%s\"} %d\n", escapeLabel(verb), snapshot.Commands[verb].Errors)
+	}
+
+	writeHeader(&out, "esl_command_duration_seconds", "histogram", "Latency of commands until a reply arrived, by verb.")
+	for _, verb := range verbs {
+		stats := snapshot.Commands[verb]
+		label := escapeLabel(verb)
+		for idx, bound := range snapshot.Buckets {
+			fmt.Fprintf(&out, "esl_command_duration_seconds_bucket{verb=\"%s\",le=\"%s\"} %d\n",
+				label, formatFloat(bound), stats.Buckets[idx])
+		}
+		fmt.Fprintf(&out, "esl_command_duration_seconds_bucket{verb=\"%s\",le=\"+Inf\"} %d\n", label, stats.Count)
+		fmt.Fprintf(&out, "esl_command_duration_seconds_sum{verb=\"%s\"} %s\n", label, formatFloat(stats.Latency.Seconds()))
+		fmt.Fprintf(&out, "esl_command_duration_seconds_count{verb=\"%s\"} %d\n", label, stats.Count)
+	}
+
+	events := make([]string, 0, len(snapshot.Events))
+	for name := range snapshot.Events {
+		events = append(events, name)
+	}
+	sort.Strings(events)
+
+	writeHeader(&out, "esl_events_total", "counter", "Number of events that were received, by Event-Name.")
+	for _, name := range events {
+		fmt.Fprintf(&out, "esl_events_total{event=\"%s\"} %d\n", escapeLabel(name), snapshot.Events[name])
+	}
+
+	writeHeader(&out, "esl_events_missed_total", "counter", "Number of events that were lost, based on Event-Sequence.")
+	fmt.Fprintf(&out, "esl_events_missed_total %d\n", snapshot.EventsMissed)
+
+	writeHeader(&out, "esl_event_duplicates_total", "counter", "Number of events that arrived more than once, based on Event-Sequence.")
+	fmt.Fprintf(&out, "esl_event_duplicates_total %d\n", snapshot.EventDuplicates)
+
+	writeHeader(&out, "esl_events_dropped_total", "counter", "Number of events that were dropped by full queues.")
+	fmt.Fprintf(&out, "esl_events_dropped_total %d\n", snapshot.EventsDropped)
+
+	writeHeader(&out, "esl_reconnects_total", "counter", "Number of reconnections.")
+	fmt.Fprintf(&out, "esl_reconnects_total %d\n", snapshot.Reconnects)
+
+	writeHeader(&out, "esl_sent_bytes_total", "counter", "Number of bytes that were sent.")
+	fmt.Fprintf(&out, "esl_sent_bytes_total %d\n", snapshot.BytesSent)
+
+	writeHeader(&out, "esl_received_bytes_total", "counter", "Number of bytes that were received.")
+	fmt.Fprintf(&out, "esl_received_bytes_total %d\n", snapshot.BytesReceived)
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// ServeHTTP exposes the measurements for Prometheus scraping
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+func writeHeader(out *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// labelEscaper escapes a label value based on the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// SetMetrics reports the measurements of the socket into a collector, or nil
+// to stop reporting.
+func (s *Socket) SetMetrics(metrics MetricsCollector) {
+	s.metrics.Store(&metrics)
+}
+
+// loadMetrics returns the metrics collector of the socket, or nil
+func (s *Socket) loadMetrics() MetricsCollector {
+	metrics := s.metrics.Load()
+	if metrics == nil {
+		return nil
+	}
+	return *metrics
+}
+
+// commandVerb returns the first word of a command
+func commandVerb(cmd string) string {
+	idx := strings.IndexAny(cmd, " \n")
+	if idx < 0 {
+		return cmd
+	}
+	return cmd[:idx]
+}
+
+func (s *Socket) measureCommand(cmd string, start time.Time, msg *Message, err error) {
+	metrics := s.loadMetrics()
+	if metrics == nil {
+		return
+	}
+
+	failed := err != nil || (msg != nil && msg.HasError())
+	metrics.CommandDone(commandVerb(cmd), time.Since(start), failed)
+}
+
+func (s *Socket) measureReceived(n int, msg *Message) {
+	metrics := s.loadMetrics()
+	if metrics == nil {
+		return
+	}
+
+	metrics.BytesReceived(n)
+
+	if msg == nil {
+		return
+	}
+
+	// Only the name is needed, the event is parsed by its reader
+	switch msg.ContentType() {
+	case ECTEventPlain, ECTEventJSON, ECTEventXML:
+		name, _ := eventName(msg)
+		metrics.EventReceived(name)
+	}
+}
+
+func (s *Socket) measureSent(n int) {
+	metrics := s.loadMetrics()
+	if metrics != nil {
+		metrics.BytesSent(n)
+	}
+}
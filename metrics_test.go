@@ -0,0 +1,147 @@
+package esl
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsCommandDone(t *testing.T) {
+	metrics := NewMetrics(0.1, 0.01, 1)
+
+	metrics.CommandDone("api", 5*time.Millisecond, false)
+	metrics.CommandDone("api", 50*time.Millisecond, true)
+	metrics.CommandDone("api", 2*time.Second, false)
+
+	snapshot := metrics.Snapshot()
+	stats := snapshot.Commands["api"]
+
+	if stats.Count != 3 || stats.Errors != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	rate := stats.ErrorRate()
+	if rate < 0.33 || rate > 0.34 {
+		t.Errorf("Expected error rate of 1/3, got %f", rate)
+	}
+
+	// buckets are sorted: 0.01, 0.1, 1
+	expected := []uint64{1, 2, 2}
+	for idx, count := range expected {
+		if stats.Buckets[idx] != count {
+			t.Errorf("Expected bucket %d to be %d, got %d", idx, count, stats.Buckets[idx])
+		}
+	}
+}
+
+func TestMetricsSocket(t *testing.T) {
+	body := "Event-Name: HEARTBEAT\n\n"
+	event := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+
+	server := newFakeServer(t, func(cmd string) string {
+		switch cmd {
+		case "api status":
+			return fakeAPIResponse("UP")
+		case "bgapi status":
+			return fakeCommandReply("+OK Job-UUID: 1")
+		case "event plain HEARTBEAT":
+			return fakeCommandReply("+OK event listener enabled plain") + event
+		}
+		return fakeAPIResponse("-ERR command not found\n")
+	})
+
+	socket := connectFakeServer(t, server)
+	metrics := NewMetrics()
+	socket.SetMetrics(metrics)
+
+	socket.API("status", "")
+	socket.API("foo", "")
+	socket.BgAPI("status", "")
+	socket.Events(EOTPlain, "HEARTBEAT")
+
+	_, err := socket.ReadMessage()
+	if err != nil {
+		t.Errorf("Unable to read event: %s", err)
+		return
+	}
+
+	snapshot := metrics.Snapshot()
+
+	if snapshot.Commands["api"].Count != 2 || snapshot.Commands["api"].Errors != 1 {
+		t.Errorf("Unexpected api stats: %+v", snapshot.Commands["api"])
+	}
+
+	if snapshot.Commands["bgapi"].Count != 1 || snapshot.Commands["event"].Count != 1 {
+		t.Errorf("Unexpected stats: %+v", snapshot.Commands)
+	}
+
+	if snapshot.Events["HEARTBEAT"] != 1 {
+		t.Errorf("Expected a single HEARTBEAT, got: %v", snapshot.Events)
+	}
+
+	if snapshot.BytesSent == 0 || snapshot.BytesReceived == 0 {
+		t.Errorf("Expected bytes to be counted: %d %d", snapshot.BytesSent, snapshot.BytesReceived)
+	}
+}
+
+func TestSocketSetMetricsWhileUsed(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeAPIResponse("+OK")
+	})
+	socket := connectFakeServer(t, server)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			socket.API("status", "")
+		}
+	}()
+
+	socket.SetMetrics(NewMetrics())
+	<-done
+	socket.SetMetrics(nil)
+}
+
+func TestMetricsWritePrometheus(t *testing.T) {
+	metrics := NewMetrics(0.1)
+	metrics.CommandDone("api", 10*time.Millisecond, true)
+	metrics.EventReceived(`CUSTOM "x"`)
+	metrics.Reconnected()
+	metrics.BytesSent(10)
+	metrics.EventsMissed(3)
+	metrics.EventDuplicated()
+	metrics.EventsDropped(4)
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	output := recorder.Body.String()
+	expected := []string{
+		"# TYPE esl_commands_total counter\n",
+		"esl_commands_total{verb=\"api\"} 1\n",
+		"esl_command_errors_total{verb=\"api\"} 1\n",
+		"# TYPE esl_command_duration_seconds histogram\n",
+		"esl_command_duration_seconds_bucket{verb=\"api\",le=\"0.1\"} 1\n",
+		"esl_command_duration_seconds_bucket{verb=\"api\",le=\"+Inf\"} 1\n",
+		"esl_command_duration_seconds_sum{verb=\"api\"} 0.01\n",
+		"esl_events_total{event=\"CUSTOM \\\"x\\\"\"} 1\n",
+		"esl_events_missed_total 3\n",
+		"esl_event_duplicates_total 1\n",
+		"esl_events_dropped_total 4\n",
+		"esl_reconnects_total 1\n",
+		"esl_sent_bytes_total 10\n",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("Missing %q in:\n%s", line, output)
+		}
+	}
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type: %s", recorder.Header().Get("Content-Type"))
+	}
+}
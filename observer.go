@@ -0,0 +1,228 @@
+package esl
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Current file contains the debug support of Socket using callbacks.
+// The package does not log anything by itself, an Observer can be attached to
+// a socket in order to trace every frame using any logger.
+
+// Observer receives notifications about the traffic of a socket.
+//
+// The password of auth commands is redacted before OnSend is called.
+// The calls are made synchronously, so an observer should not block.
+type Observer interface {
+	// OnConnect is called when a connection is established
+	OnConnect(addr string)
+	// OnSend is called for every command that was sent (without EOLs)
+	OnSend(cmd string)
+	// OnReceive is called for every frame that was received, with its
+	// message
+	OnReceive(raw []byte, msg *Message)
+	// OnError is called when sending or receiving failed
+	OnError(err error)
+	// OnDisconnect is called when the connection is closed, with the error of
+	// closing it (if any)
+	OnDisconnect(err error)
+}
+
+// ObserverFuncs implements Observer using callbacks, only callbacks that are
+// not nil are called.
+type ObserverFuncs struct {
+	Connect    func(addr string)
+	Send       func(cmd string)
+	Receive    func(raw []byte, msg *Message)
+	Error      func(err error)
+	Disconnect func(err error)
+}
+
+// OnConnect implements Observer
+func (o ObserverFuncs) OnConnect(addr string) {
+	if o.Connect != nil {
+		o.Connect(addr)
+	}
+}
+
+// OnSend implements Observer
+func (o ObserverFuncs) OnSend(cmd string) {
+	if o.Send != nil {
+		o.Send(cmd)
+	}
+}
+
+// OnReceive implements Observer
+func (o ObserverFuncs) OnReceive(raw []byte, msg *Message) {
+	if o.Receive != nil {
+		o.Receive(raw, msg)
+	}
+}
+
+// OnError implements Observer
+func (o ObserverFuncs) OnError(err error) {
+	if o.Error != nil {
+		o.Error(err)
+	}
+}
+
+// OnDisconnect implements Observer
+func (o ObserverFuncs) OnDisconnect(err error) {
+	if o.Disconnect != nil {
+		o.Disconnect(err)
+	}
+}
+
+// SlogObserver logs the traffic of a socket using log/slog.
+// Traffic is logged at Level, and errors are logged at error level.
+type SlogObserver struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+// NewSlogObserver creates a new SlogObserver that logs the traffic at debug
+// level. If logger is nil, slog.Default is used.
+func NewSlogObserver(logger *slog.Logger) *SlogObserver {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &SlogObserver{
+		Logger: logger,
+		Level:  slog.LevelDebug,
+	}
+}
+
+// OnConnect implements Observer
+func (o *SlogObserver) OnConnect(addr string) {
+	o.Logger.Log(context.Background(), o.Level, "esl connected", slog.String("addr", addr))
+}
+
+// OnSend implements Observer
+func (o *SlogObserver) OnSend(cmd string) {
+	o.Logger.Log(context.Background(), o.Level, "esl send", slog.String("cmd", cmd))
+}
+
+// OnReceive implements Observer
+func (o *SlogObserver) OnReceive(raw []byte, msg *Message) {
+	attrs := []slog.Attr{
+		slog.Int("length", len(raw)),
+		slog.String("raw", string(raw)),
+	}
+	if msg != nil {
+		attrs = append(attrs, slog.String("content_type", string(msg.ContentType())))
+	}
+
+	o.Logger.LogAttrs(context.Background(), o.Level, "esl receive", attrs...)
+}
+
+// OnError implements Observer
+func (o *SlogObserver) OnError(err error) {
+	o.Logger.Error("esl error", slog.Any("error", err))
+}
+
+// OnDisconnect implements Observer
+func (o *SlogObserver) OnDisconnect(err error) {
+	if err != nil {
+		o.Logger.Log(context.Background(), o.Level, "esl disconnected", slog.Any("error", err))
+		return
+	}
+	o.Logger.Log(context.Background(), o.Level, "esl disconnected")
+}
+
+// LogObserver logs the traffic of a socket using the standard log package
+type LogObserver struct {
+	Logger *log.Logger
+}
+
+// NewLogObserver creates a new LogObserver. If logger is nil, the standard
+// logger of the log package is used.
+func NewLogObserver(logger *log.Logger) *LogObserver {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &LogObserver{Logger: logger}
+}
+
+// OnConnect implements Observer
+func (o *LogObserver) OnConnect(addr string) {
+	o.Logger.Printf("esl: connected to %s", addr)
+}
+
+// OnSend implements Observer
+func (o *LogObserver) OnSend(cmd string) {
+	o.Logger.Printf("esl: send: %q", cmd)
+}
+
+// OnReceive implements Observer
+func (o *LogObserver) OnReceive(raw []byte, msg *Message) {
+	o.Logger.Printf("esl: receive (%d bytes): %q", len(raw), raw)
+}
+
+// OnError implements Observer
+func (o *LogObserver) OnError(err error) {
+	o.Logger.Printf("esl: error: %s", err)
+}
+
+// OnDisconnect implements Observer
+func (o *LogObserver) OnDisconnect(err error) {
+	if err != nil {
+		o.Logger.Printf("esl: disconnected: %s", err)
+		return
+	}
+	o.Logger.Printf("esl: disconnected")
+}
+
+// SetObserver attach an observer to the socket, or nil to remove it.
+// If the socket is already connected, OnConnect is called with its address.
+func (s *Socket) SetObserver(observer Observer) {
+	s.observer.Store(&observer)
+	s.observeConnect()
+}
+
+// loadObserver returns the observer of the socket, or nil
+func (s *Socket) loadObserver() Observer {
+	observer := s.observer.Load()
+	if observer == nil {
+		return nil
+	}
+	return *observer
+}
+
+func (s *Socket) observeConnect() {
+	observer := s.loadObserver()
+	if observer != nil && s.conn != nil {
+		observer.OnConnect(s.conn.RemoteAddr().String())
+	}
+}
+
+func (s *Socket) observeSend(cmd string) {
+	observer := s.loadObserver()
+	if observer != nil {
+		observer.OnSend(strings.TrimRight(redactCommand(cmd), EOL))
+	}
+}
+
+func (s *Socket) observeReceive(raw []byte, msg *Message) {
+	observer := s.loadObserver()
+	if observer != nil {
+		observer.OnReceive(raw, msg)
+	}
+}
+
+func (s *Socket) observeError(err error) {
+	observer := s.loadObserver()
+	if observer != nil && err != nil {
+		observer.OnError(err)
+	}
+}
+
+func (s *Socket) observeDisconnect(err error) {
+	observer := s.loadObserver()
+	if observer != nil {
+		observer.OnDisconnect(err)
+	}
+}
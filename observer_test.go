@@ -0,0 +1,152 @@
+package esl
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestObserverFuncs(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeAPIResponse("+OK")
+	})
+
+	var lock sync.Mutex
+	var connected, disconnected bool
+	var sent []string
+	var received []*Message
+
+	observer := ObserverFuncs{
+		Connect: func(addr string) {
+			lock.Lock()
+			defer lock.Unlock()
+			connected = addr == server.Addr()
+		},
+		Send: func(cmd string) {
+			lock.Lock()
+			defer lock.Unlock()
+			sent = append(sent, cmd)
+		},
+		Receive: func(raw []byte, msg *Message) {
+			lock.Lock()
+			defer lock.Unlock()
+			received = append(received, msg)
+		},
+		Disconnect: func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			disconnected = true
+		},
+	}
+
+	socket, err := Dial(server.Addr(), fakePassword, 0, 5*time.Second)
+	if err != nil {
+		t.Errorf("Unable to dial: %s", err)
+		return
+	}
+	socket.SetObserver(observer)
+
+	_, err = socket.Login()
+	if err != nil {
+		t.Errorf("Unable to login: %s", err)
+		return
+	}
+
+	_, err = socket.API("status", "")
+	if err != nil {
+		t.Errorf("Unable to call api: %s", err)
+		return
+	}
+	socket.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !connected {
+		t.Errorf("OnConnect was not called with the server address")
+	}
+
+	if !disconnected {
+		t.Errorf("OnDisconnect was not called")
+	}
+
+	if len(sent) != 2 || sent[0] != "auth "+redactedPassword || sent[1] != "api status " {
+		t.Errorf("Unexpected commands: %q", sent)
+	}
+
+	// auth/request, auth reply and api response
+	types := []EventContentType{ECTAuthRequest, ECTCommandReply, ECTAPIResponse}
+	if len(received) != len(types) {
+		t.Errorf("Unexpected received messages: %v", received)
+		return
+	}
+
+	for idx, contentType := range types {
+		if received[idx].ContentType() != contentType {
+			t.Errorf("Expected (%d) content type %s, got %s", idx, contentType, received[idx].ContentType())
+		}
+	}
+}
+
+func TestSocketSetObserverWhileUsed(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeAPIResponse("+OK")
+	})
+	socket := connectFakeServer(t, server)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			socket.API("status", "")
+		}
+	}()
+
+	socket.SetObserver(ObserverFuncs{})
+	<-done
+	socket.SetObserver(nil)
+}
+
+func TestLogObserver(t *testing.T) {
+	var out bytes.Buffer
+	observer := NewLogObserver(log.New(&out, "", 0))
+
+	observer.OnSend("api status")
+	observer.OnReceive([]byte("Content-Type: auth/request\n\n"), nil)
+	observer.OnDisconnect(nil)
+
+	expected := "esl: send: \"api status\"\n" +
+		"esl: receive (28 bytes): \"Content-Type: auth/request\\n\\n\"\n" +
+		"esl: disconnected\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestSlogObserver(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	observer := NewSlogObserver(logger)
+
+	msg, err := NewMessage([]byte("Content-Type: auth/request\n\n"), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	observer.OnSend("api status")
+	observer.OnReceive([]byte("Content-Type: auth/request\n\n"), msg)
+
+	result := out.String()
+	if !strings.Contains(result, `msg="esl send" cmd="api status"`) {
+		t.Errorf("Missing send log: %s", result)
+	}
+
+	if !strings.Contains(result, "content_type=auth/request") {
+		t.Errorf("Missing receive log: %s", result)
+	}
+}
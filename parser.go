@@ -0,0 +1,342 @@
+package esl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Current file contains a streaming parser of ESL frames.
+//
+// Unlike NewMessage, the parser reuses its buffers between frames, and it does
+// not decode the headers until they are needed. Frames can be inspected
+// without any allocation, and turned into a Message only when required.
+
+// Buffer sizes of the parser
+const (
+	parserReaderSize = 4096
+	parserBufferSize = 4096
+	// parserMaxPooledBuffer is the largest frame buffer that is kept in the
+	// pool, so a single huge event does not stay in memory
+	parserMaxPooledBuffer = 64 * 1024
+)
+
+var (
+	readerPool = sync.Pool{
+		New: func() interface{} {
+			return bufio.NewReaderSize(nil, parserReaderSize)
+		},
+	}
+
+	frameBufferPool = sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, 0, parserBufferSize)
+			return &buf
+		},
+	}
+)
+
+// Frame is a single raw frame (headers and body) that was read by a Parser.
+//
+// The content of a frame is valid only until the next call to Next (or
+// Release) of its parser, use Message for a copy.
+type Frame struct {
+	buf        []byte
+	headersEnd int
+}
+
+// Raw returns the whole frame as arrived
+func (f *Frame) Raw() []byte {
+	return f.buf
+}
+
+// Body returns the body of the frame, or an empty slice if there is no body
+func (f *Frame) Body() []byte {
+	return f.buf[f.headersEnd:]
+}
+
+// Header returns the value of the first header with the given name (case
+// insensitive), without decoding the other headers. The result is nil if the
+// header was not found.
+func (f *Frame) Header(name string) []byte {
+	value, _ := findHeader(f.buf[:f.headersEnd], name)
+	return value
+}
+
+// HasContentType returns true if the frame is of the given content type
+func (f *Frame) HasContentType(contentType EventContentType) bool {
+	value, found := findHeader(f.buf[:f.headersEnd], "Content-Type")
+	return found && string(value) == string(contentType)
+}
+
+// Message returns a copy of the frame as a Message. The headers are decoded
+// only when they are first accessed. Like NewMessage, the last EOL of the body
+// is dropped.
+func (f *Frame) Message() *Message {
+	raw := make([]byte, len(f.buf))
+	copy(raw, f.buf)
+
+	return &Message{
+		Headers: newLazyHeaders(raw[:f.headersEnd]),
+		Body:    bytes.TrimSuffix(raw[f.headersEnd:], []byte{'\n'}),
+		Parsed:  true,
+		buf:     raw,
+	}
+}
+
+// Parser reads ESL frames from a stream
+type Parser struct {
+	reader       *bufio.Reader
+	pooled       bool
+	buf          *[]byte
+	frame        Frame
+	maxFrameSize int
+}
+
+// NewParser creates a new Parser for r. If r is a *bufio.Reader, it is used
+// directly, otherwise a pooled reader is used. Call Release when the parser
+// is no longer needed.
+func NewParser(r io.Reader) *Parser {
+	if reader, ok := r.(*bufio.Reader); ok {
+		return &Parser{reader: reader, maxFrameSize: DefaultMaxFrameSize}
+	}
+
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(r)
+
+	return &Parser{reader: reader, pooled: true, maxFrameSize: DefaultMaxFrameSize}
+}
+
+// SetMaxFrameSize sets the max size of a frame (headers and body), larger
+// frames fail with ErrFrameTooLarge. The default is DefaultMaxFrameSize.
+//
+// After ErrFrameTooLarge the stream is no longer in sync, and the connection
+// should be closed.
+func (p *Parser) SetMaxFrameSize(size int) {
+	p.maxFrameSize = size
+}
+
+// Next reads the next frame. Empty lines between frames are skipped.
+//
+// Malformed frames fail with ErrInvalidContentLength, ErrFrameTooLarge, or
+// io.ErrUnexpectedEOF when the stream ends in the middle of a frame.
+//
+// The returned frame is reused by the next call, so it must not be kept.
+func (p *Parser) Next() (*Frame, error) {
+	if p.buf == nil {
+		p.buf = frameBufferPool.Get().(*[]byte)
+	}
+
+	buf := (*p.buf)[:0]
+	contentLength := 0
+	hasLength := false
+
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			*p.buf = buf
+			if errors.Is(err, io.EOF) && len(buf) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		if isEmptyLine(line) {
+			// Skip empty lines between messages
+			if len(buf) == 0 {
+				continue
+			}
+			buf = append(buf, line...)
+			break
+		}
+
+		if len(buf)+len(line) > p.maxFrameSize {
+			*p.buf = buf
+			return nil, fmt.Errorf("%w: headers exceed %d bytes", ErrFrameTooLarge, p.maxFrameSize)
+		}
+		buf = append(buf, line...)
+
+		name, value, ok := splitHeaderLine(trimEOL(line))
+		if ok && equalFold(name, "Content-Length") {
+			length, ok := parseContentLength(value)
+			// Conflicting lengths make the frame boundary ambiguous
+			if !ok || (hasLength && length != contentLength) {
+				*p.buf = buf
+				return nil, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+			}
+			contentLength = length
+			hasLength = true
+		}
+	}
+
+	headersEnd := len(buf)
+	if contentLength > p.maxFrameSize-headersEnd {
+		*p.buf = buf
+		return nil, fmt.Errorf("%w: Content-Length %d exceeds %d bytes", ErrFrameTooLarge, contentLength, p.maxFrameSize)
+	}
+
+	if contentLength > 0 {
+		if cap(buf)-len(buf) < contentLength {
+			grown := make([]byte, len(buf), len(buf)+contentLength)
+			copy(grown, buf)
+			buf = grown
+		}
+
+		buf = buf[:headersEnd+contentLength]
+		_, err := io.ReadFull(p.reader, buf[headersEnd:])
+		if err != nil {
+			*p.buf = buf[:headersEnd]
+			if errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+
+	*p.buf = buf
+	p.frame = Frame{buf: buf, headersEnd: headersEnd}
+
+	return &p.frame, nil
+}
+
+// ReadMessage reads the next frame, and returns it as a Message
+func (p *Parser) ReadMessage() (*Message, error) {
+	frame, err := p.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return frame.Message(), nil
+}
+
+// Release returns the buffers of the parser into the pools. The parser and
+// the last frame must not be used afterwards.
+func (p *Parser) Release() {
+	if p.buf != nil {
+		if cap(*p.buf) <= parserMaxPooledBuffer {
+			*p.buf = (*p.buf)[:0]
+			frameBufferPool.Put(p.buf)
+		}
+		p.buf = nil
+	}
+
+	if p.pooled && p.reader != nil {
+		p.reader.Reset(nil)
+		readerPool.Put(p.reader)
+	}
+	p.reader = nil
+	p.frame = Frame{}
+}
+
+// readLine reads a line including its EOL. The returned slice is valid until
+// the next read.
+func (p *Parser) readLine() ([]byte, error) {
+	line, err := p.reader.ReadSlice('\n')
+	if !errors.Is(err, bufio.ErrBufferFull) {
+		return line, err
+	}
+
+	// A line that is longer than the reader buffer
+	long := append([]byte(nil), line...)
+	for errors.Is(err, bufio.ErrBufferFull) {
+		if len(long) > p.maxFrameSize {
+			return nil, fmt.Errorf("%w: header line exceeds %d bytes", ErrFrameTooLarge, p.maxFrameSize)
+		}
+		line, err = p.reader.ReadSlice('\n')
+		long = append(long, line...)
+	}
+	return long, err
+}
+
+func isEmptyLine(line []byte) bool {
+	return len(trimEOL(line)) == 0
+}
+
+func trimEOL(line []byte) []byte {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func trimSpaces(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == '\t') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// splitHeaderLine splits a "Name: value" line without allocating
+func splitHeaderLine(line []byte) (name, value []byte, ok bool) {
+	for idx, c := range line {
+		if c == ':' {
+			if idx == 0 {
+				return nil, nil, false
+			}
+			return trimSpaces(line[:idx]), trimSpaces(line[idx+1:]), true
+		}
+	}
+	return nil, nil, false
+}
+
+// findHeader finds a header in raw header lines
+func findHeader(headers []byte, name string) ([]byte, bool) {
+	for len(headers) > 0 {
+		end := bytes.IndexByte(headers, '\n') + 1
+		if end == 0 {
+			end = len(headers)
+		}
+
+		key, value, ok := splitHeaderLine(trimEOL(headers[:end]))
+		if ok && equalFold(key, name) {
+			return value, true
+		}
+		headers = headers[end:]
+	}
+
+	return nil, false
+}
+
+// equalFold compares ASCII b and s case insensitive, without allocating
+func equalFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+
+	for idx := range b {
+		x, y := b[idx], s[idx]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// parseContentLength parses a non negative decimal number without allocating
+func parseContentLength(value []byte) (int, bool) {
+	// 18 digits can not overflow
+	if len(value) == 0 || len(value) > 18 {
+		return 0, false
+	}
+
+	n := 0
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
@@ -0,0 +1,305 @@
+package esl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// largeEventFrame is a text/event-plain frame similar to a CHANNEL_CREATE
+// event with its channel variables
+func largeEventFrame() []byte {
+	lines := []string{"Event-Name: CHANNEL_CREATE", "Unique-ID: 7f4de4bc-17d7-11eb-9d1e-5b6d79a3a8f4"}
+	for idx := 0; idx < 150; idx++ {
+		lines = append(lines, fmt.Sprintf("variable_var_%d: value%%20number%%20%d", idx, idx))
+	}
+	return []byte(fakeEvent(lines...))
+}
+
+var smallReplyFrame = []byte("Content-Type: command/reply\nReply-Text: +OK accepted\n\n")
+
+// loopReader returns data over and over
+type loopReader struct {
+	data []byte
+	pos  int
+}
+
+func (l *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, l.data[l.pos:])
+	l.pos = (l.pos + n) % len(l.data)
+	return n, nil
+}
+
+func TestParserNext(t *testing.T) {
+	input := "\n" + string(smallReplyFrame) +
+		"Content-Type: api/response\nContent-Length: 11\n\nhello world" +
+		"\n\nContent-Type: text/disconnect-notice\n\n"
+
+	parser := NewParser(strings.NewReader(input))
+	defer parser.Release()
+
+	frame, err := parser.Next()
+	if err != nil {
+		t.Fatalf("Unable to read frame: %s", err)
+	}
+
+	if !frame.HasContentType(ECTCommandReply) || string(frame.Header("reply-text")) != "+OK accepted" {
+		t.Errorf("Unexpected frame: %q", frame.Raw())
+	}
+
+	if len(frame.Body()) != 0 || frame.Header("Missing") != nil {
+		t.Errorf("Unexpected body or header: %q", frame.Raw())
+	}
+
+	msg, err := parser.ReadMessage()
+	if err != nil {
+		t.Fatalf("Unable to read message: %s", err)
+	}
+
+	if msg.ContentType() != ECTAPIResponse || string(msg.Body) != "hello world" {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+
+	frame, err = parser.Next()
+	if err != nil || !frame.HasContentType(ECTDisconnectNotice) {
+		t.Errorf("Unexpected frame: %v %v", frame, err)
+	}
+
+	// msg must not change after the buffer was reused
+	if string(msg.Body) != "hello world" || msg.Headers.GetString("Content-Length") != "11" {
+		t.Errorf("Message was modified: %s", msg)
+	}
+
+	_, err = parser.Next()
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF, got: %v", err)
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	inputs := map[string]string{
+		"invalid length":   "Content-Length: abc\n\n",
+		"negative length":  "Content-Length: -1\n\n",
+		"truncated body":   "Content-Length: 10\n\nhello",
+		"truncated header": "Content-Type: command/reply\n",
+	}
+
+	for name, input := range inputs {
+		parser := NewParser(strings.NewReader(input))
+		_, err := parser.Next()
+		if err == nil || errors.Is(err, io.EOF) {
+			t.Errorf("%s: expected an error, got: %v", name, err)
+		}
+		parser.Release()
+	}
+}
+
+func TestParserLongLine(t *testing.T) {
+	value := strings.Repeat("x", 3*parserReaderSize)
+	parser := NewParser(strings.NewReader("Content-Type: text/plain\nLong: " + value + "\n\n"))
+	defer parser.Release()
+
+	msg, err := parser.ReadMessage()
+	if err != nil {
+		t.Fatalf("Unable to read message: %s", err)
+	}
+
+	if msg.Headers.GetString("Long") != value {
+		t.Errorf("Unexpected long header")
+	}
+}
+
+func TestParserMatchesNewMessage(t *testing.T) {
+	frames := map[string][]byte{
+		"command reply":       smallReplyFrame,
+		"api response":        []byte(fakeAPIResponse("+OK\n")),
+		"api response no eol": []byte(fakeAPIResponse("+OK")),
+		"api error":           []byte(fakeAPIResponse("-ERR no such channel\n")),
+		"event plain":         largeEventFrame(),
+		"event json":          []byte(fakeJSONEvent(`{"Event-Name":"HEARTBEAT","_body":"text"}`)),
+		"disconnect notice":   []byte("Content-Type: text/disconnect-notice\nContent-Length: 9\n\nGoodbye\n\n"),
+		"log data":            []byte("Content-Type: log/data\nContent-Length: 6\nLog-Level: 7\n\nhello\n"),
+		"long header":         []byte("Content-Type: text/plain\nLong: " + strings.Repeat("x", 3*messageReaderSize) + "\n\n"),
+	}
+
+	for name, frame := range frames {
+		expected, err := NewMessage(frame, true)
+		if err != nil {
+			t.Fatalf("%s: unable to parse message: %s", name, err)
+		}
+
+		parser := NewParser(bytes.NewReader(frame))
+		msg, err := parser.ReadMessage()
+		parser.Release()
+		if err != nil {
+			t.Fatalf("%s: unable to read message: %s", name, err)
+		}
+
+		if msg.Headers.String() != expected.Headers.String() {
+			t.Errorf("%s: expected headers %s, got %s", name, expected.Headers, msg.Headers)
+		}
+		if !bytes.Equal(msg.Body, expected.Body) {
+			t.Errorf("%s: expected body %q, got %q", name, expected.Body, msg.Body)
+		}
+
+		expectedEvent, _ := eventHeaders(expected)
+		event, _ := eventHeaders(msg)
+		if event.String() != expectedEvent.String() {
+			t.Errorf("%s: unexpected event headers: %s", name, event)
+		}
+	}
+}
+
+func TestParserNextAllocations(t *testing.T) {
+	parser := NewParser(&loopReader{data: largeEventFrame()})
+	defer parser.Release()
+
+	// warm up the buffers
+	parser.Next()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		frame, err := parser.Next()
+		if err != nil || frame.Header("Content-Type") == nil {
+			t.Fatalf("Unable to read frame: %v", err)
+		}
+	})
+
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, got: %f", allocs)
+	}
+}
+
+func benchmarkNewMessage(b *testing.B, frame []byte) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+
+	for idx := 0; idx < b.N; idx++ {
+		msg, err := NewMessage(frame, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		msg.ContentType()
+	}
+}
+
+func benchmarkParser(b *testing.B, frame []byte, message bool) {
+	parser := NewParser(&loopReader{data: frame})
+	defer parser.Release()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+
+	for idx := 0; idx < b.N; idx++ {
+		f, err := parser.Next()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if message {
+			f.Message().ContentType()
+			continue
+		}
+		f.Header("Content-Type")
+	}
+}
+
+func BenchmarkNewMessageSmallReply(b *testing.B) {
+	benchmarkNewMessage(b, smallReplyFrame)
+}
+
+func BenchmarkNewMessageLargeEvent(b *testing.B) {
+	benchmarkNewMessage(b, largeEventFrame())
+}
+
+func BenchmarkParserSmallReply(b *testing.B) {
+	benchmarkParser(b, smallReplyFrame, false)
+}
+
+func BenchmarkParserLargeEvent(b *testing.B) {
+	benchmarkParser(b, largeEventFrame(), false)
+}
+
+func BenchmarkParserMessageSmallReply(b *testing.B) {
+	benchmarkParser(b, smallReplyFrame, true)
+}
+
+func BenchmarkParserMessageLargeEvent(b *testing.B) {
+	benchmarkParser(b, largeEventFrame(), true)
+}
+
+func TestParserMaxFrameSize(t *testing.T) {
+	inputs := map[string]string{
+		"body":        "Content-Length: 200\n\n" + strings.Repeat("x", 200),
+		"headers":     strings.Repeat("Header: value\n", 20) + "\n",
+		"long header": "Header: " + strings.Repeat("x", 2*parserReaderSize) + "\n\n",
+	}
+
+	for name, input := range inputs {
+		parser := NewParser(strings.NewReader(input))
+		parser.SetMaxFrameSize(128)
+
+		_, err := parser.Next()
+		if !errors.Is(err, ErrFrameTooLarge) {
+			t.Errorf("%s: expected ErrFrameTooLarge, got: %v", name, err)
+		}
+		parser.Release()
+	}
+
+	parser := NewParser(strings.NewReader("Content-Length: 5\n\nhello"))
+	defer parser.Release()
+	parser.SetMaxFrameSize(128)
+
+	_, err := parser.Next()
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func parseLength(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func FuzzParser(f *testing.F) {
+	f.Add(smallReplyFrame)
+	f.Add([]byte("Content-Type: api/response\nContent-Length: 11\n\nhello world\n\nContent-Type: text/disconnect-notice\n\n"))
+	f.Add([]byte("Content-Length: -1\n\n"))
+	f.Add([]byte("Content-Length: 99999999999999999999\n\n"))
+	f.Add([]byte(fakeEvent("Event-Name: HEARTBEAT", "Content-Length: 3", "", "abc")))
+
+	const maxFrameSize = 4096
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parser := NewParser(bytes.NewReader(data))
+		defer parser.Release()
+		parser.SetMaxFrameSize(maxFrameSize)
+
+		for {
+			frame, err := parser.Next()
+			if err != nil {
+				return
+			}
+
+			if len(frame.Raw()) > maxFrameSize {
+				t.Fatalf("Frame of %d bytes exceeds the max frame size", len(frame.Raw()))
+			}
+
+			if length := frame.Header("Content-Length"); length != nil && parseLength(string(length)) != len(frame.Body()) {
+				t.Fatalf("Body of %d bytes does not match Content-Length %q", len(frame.Body()), length)
+			}
+
+			msg := frame.Message()
+			_ = msg.Headers.String()
+			msg.HasError()
+			msg.Error()
+			if event, err := ParseEvent(msg); err == nil {
+				_ = event.Headers.String()
+			}
+		}
+	})
+}
@@ -0,0 +1,308 @@
+package esl
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Current file contains the bounded queues of event subscribers.
+//
+// Every subscriber of a Client has its own queue and goroutine, so a slow
+// subscriber does not stall the others. When a queue is full, its policy
+// decides whether the events connection waits, events are dropped, or the
+// subscriber is disconnected.
+
+// QueuePolicy is the behavior of a full event queue
+type QueuePolicy int
+
+// The policies of a full event queue
+const (
+	// QueueBlock waits until the subscriber handled an event. Reading events
+	// stops meanwhile, for all the subscribers.
+	QueueBlock QueuePolicy = iota
+	// QueueDropOldest drops the oldest queued event
+	QueueDropOldest
+	// QueueDropNewest drops the event that arrived
+	QueueDropNewest
+	// QueueDisconnect removes the subscriber, its queued events are dropped
+	QueueDisconnect
+)
+
+var queuePolicyNames = map[QueuePolicy]string{
+	QueueBlock:      "block",
+	QueueDropOldest: "drop-oldest",
+	QueueDropNewest: "drop-newest",
+	QueueDisconnect: "disconnect",
+}
+
+func (p QueuePolicy) String() string {
+	name, found := queuePolicyNames[p]
+	if !found {
+		return fmt.Sprintf("QueuePolicy(%d)", int(p))
+	}
+	return name
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (p QueuePolicy) MarshalText() ([]byte, error) {
+	_, found := queuePolicyNames[p]
+	if !found {
+		return nil, fmt.Errorf("%w: unknown queue policy %d", ErrInvalidConfig, int(p))
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (p *QueuePolicy) UnmarshalText(text []byte) error {
+	for policy, name := range queuePolicyNames {
+		if strings.EqualFold(name, string(text)) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown queue policy %q", ErrInvalidConfig, text)
+}
+
+// DefaultQueueSize is the size of an event queue if not set
+const DefaultQueueSize = 1024
+
+// QueueConfig is the config of the queue of a subscriber
+type QueueConfig struct {
+	// Size is the max number of queued events
+	Size   int
+	Policy QueuePolicy
+	// HighWatermark is the length of the queue that calls OnHighWatermark,
+	// 0 disables it
+	HighWatermark int
+	// OnHighWatermark is called when the queue reached the high watermark, and
+	// again only after it was drained below it. It is called by the goroutine
+	// that reads events, so it must not block.
+	OnHighWatermark func(length int)
+}
+
+// validate returns an error if the queue config can not be used
+func (q QueueConfig) validate() error {
+	if q.Size <= 0 {
+		return fmt.Errorf("%w: queue size must be positive", ErrInvalidConfig)
+	}
+	if _, found := queuePolicyNames[q.Policy]; !found {
+		return fmt.Errorf("%w: unknown queue policy %d", ErrInvalidConfig, int(q.Policy))
+	}
+	return nil
+}
+
+// QueueStats holds the counters of a subscription
+type QueueStats struct {
+	// Length is the number of queued events
+	Length    int
+	Delivered uint64
+	Dropped   uint64
+	// Disconnected is true if the subscriber was removed by QueueDisconnect
+	Disconnected bool
+}
+
+// QueueCollector is an optional interface of a MetricsCollector, that is
+// notified about events that were dropped by full queues
+type QueueCollector interface {
+	// EventsDropped is called with the number of events that were dropped
+	EventsDropped(n uint64)
+}
+
+// Subscription is a handler of events with its own queue
+type Subscription struct {
+	handler EventHandler
+	config  QueueConfig
+	remove  func()
+	metrics MetricsCollector
+
+	lock     sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	// queue is a ring buffer of count events starting at head
+	queue  []*Message
+	head   int
+	count  int
+	closed bool
+	high   bool
+	stats  QueueStats
+
+	done chan struct{}
+}
+
+// newSubscription creates a subscription and starts delivering its events.
+// remove is called when the subscription is closed, and dropped events are
+// reported into metrics (if it implements QueueCollector).
+func newSubscription(handler EventHandler, config QueueConfig, remove func(), metrics MetricsCollector) *Subscription {
+	s := &Subscription{
+		handler: handler,
+		config:  config,
+		remove:  remove,
+		metrics: metrics,
+		queue:   make([]*Message, config.Size),
+		done:    make(chan struct{}),
+	}
+	s.notEmpty = sync.NewCond(&s.lock)
+	s.notFull = sync.NewCond(&s.lock)
+
+	go s.run()
+
+	return s
+}
+
+// push queues an event based on the policy of the queue
+func (s *Subscription) push(event *Message) {
+	s.lock.Lock()
+
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+
+	if s.count == len(s.queue) {
+		switch s.config.Policy {
+		case QueueBlock:
+			for s.count == len(s.queue) && !s.closed {
+				s.notFull.Wait()
+			}
+			if s.closed {
+				s.lock.Unlock()
+				return
+			}
+		case QueueDropOldest:
+			s.pop()
+			s.stats.Dropped++
+			defer s.dropped(1)
+		case QueueDropNewest:
+			s.stats.Dropped++
+			s.lock.Unlock()
+			s.dropped(1)
+			return
+		case QueueDisconnect:
+			dropped := uint64(s.count) + 1
+			s.stats.Dropped += dropped
+			s.stats.Disconnected = true
+			s.close()
+			s.lock.Unlock()
+			s.dropped(dropped)
+			s.remove()
+			return
+		}
+	}
+
+	s.queue[(s.head+s.count)%len(s.queue)] = event
+	s.count++
+	s.notEmpty.Signal()
+
+	reached := false
+	if s.config.HighWatermark > 0 && s.count >= s.config.HighWatermark && !s.high {
+		s.high = true
+		reached = true
+	}
+	length := s.count
+	s.lock.Unlock()
+
+	if reached && s.config.OnHighWatermark != nil {
+		s.config.OnHighWatermark(length)
+	}
+}
+
+// dropped reports dropped events into the metrics
+func (s *Subscription) dropped(n uint64) {
+	collector, ok := s.metrics.(QueueCollector)
+	if ok {
+		collector.EventsDropped(n)
+	}
+}
+
+// pop removes the oldest event, the lock must be held
+func (s *Subscription) pop() *Message {
+	event := s.queue[s.head]
+	s.queue[s.head] = nil
+	s.head = (s.head + 1) % len(s.queue)
+	s.count--
+
+	if s.high && s.count < s.config.HighWatermark {
+		s.high = false
+	}
+
+	return event
+}
+
+// run delivers the queued events until the subscription is closed
+func (s *Subscription) run() {
+	defer close(s.done)
+
+	for {
+		s.lock.Lock()
+		for s.count == 0 && !s.closed {
+			s.notEmpty.Wait()
+		}
+		if s.closed {
+			s.lock.Unlock()
+			return
+		}
+
+		event := s.pop()
+		s.notFull.Signal()
+		s.lock.Unlock()
+
+		s.handler(event)
+
+		s.lock.Lock()
+		s.stats.Delivered++
+		s.lock.Unlock()
+	}
+}
+
+// close stops the delivery and drops the queued events, the lock must be
+// held
+func (s *Subscription) close() {
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	for s.count > 0 {
+		s.pop()
+	}
+	s.notEmpty.Broadcast()
+	s.notFull.Broadcast()
+}
+
+// Close removes the subscription. Queued events are dropped, and an event
+// that is being handled is not waited for.
+func (s *Subscription) Close() {
+	s.lock.Lock()
+	s.close()
+	s.lock.Unlock()
+
+	s.remove()
+}
+
+// Stats returns the counters of the subscription
+func (s *Subscription) Stats() QueueStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := s.stats
+	stats.Length = s.count
+	return stats
+}
+
+// Err returns ErrQueueOverflow if the subscription was removed by
+// QueueDisconnect
+func (s *Subscription) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stats.Disconnected {
+		return ErrQueueOverflow
+	}
+	return nil
+}
+
+// Done is closed when the subscription stopped delivering events
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
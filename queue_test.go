@@ -0,0 +1,305 @@
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingHandler records the events, and blocks until release is closed
+type blockingHandler struct {
+	lock     sync.Mutex
+	received []string
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (h *blockingHandler) handle(event *Message) {
+	h.started <- struct{}{}
+	<-h.release
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.received = append(h.received, event.Headers.GetString("Event-Sequence"))
+}
+
+func (h *blockingHandler) Received() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return fmt.Sprint(h.received)
+}
+
+func queueEvent(sequence int) *Message {
+	event := &Message{Headers: NewHeaders()}
+	event.Headers.Add("Event-Name", "HEARTBEAT")
+	event.Headers.Add("Event-Sequence", fmt.Sprint(sequence))
+	return event
+}
+
+// fillQueue pushes event 1 and waits until it is handled, then pushes the
+// rest of the events
+func fillQueue(t *testing.T, s *Subscription, h *blockingHandler, count int) {
+	t.Helper()
+
+	s.push(queueEvent(1))
+	select {
+	case <-h.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler was not called")
+	}
+
+	for i := 2; i <= count; i++ {
+		s.push(queueEvent(i))
+	}
+}
+
+func TestSubscriptionDropPolicies(t *testing.T) {
+	fixtures := []struct {
+		policy   QueuePolicy
+		expected string
+	}{
+		{policy: QueueDropOldest, expected: "[1 4 5]"},
+		{policy: QueueDropNewest, expected: "[1 2 3]"},
+	}
+
+	for _, fixture := range fixtures {
+		metrics := NewMetrics()
+		handler := newBlockingHandler()
+		s := newSubscription(handler.handle, QueueConfig{Size: 2, Policy: fixture.policy}, func() {}, metrics)
+
+		fillQueue(t, s, handler, 5)
+
+		stats := s.Stats()
+		if stats.Length != 2 || stats.Dropped != 2 {
+			t.Errorf("%s: unexpected stats: %+v", fixture.policy, stats)
+		}
+
+		close(handler.release)
+		waitFor(t, "delivery", func() bool { return s.Stats().Delivered == 3 })
+
+		if handler.Received() != fixture.expected {
+			t.Errorf("%s: expected %s, got %s", fixture.policy, fixture.expected, handler.Received())
+		}
+
+		if metrics.Snapshot().EventsDropped != 2 {
+			t.Errorf("%s: expected 2 dropped events, got %d", fixture.policy, metrics.Snapshot().EventsDropped)
+		}
+
+		s.Close()
+	}
+}
+
+func TestSubscriptionBlock(t *testing.T) {
+	handler := newBlockingHandler()
+	s := newSubscription(handler.handle, QueueConfig{Size: 1, Policy: QueueBlock}, func() {}, nil)
+	defer s.Close()
+
+	fillQueue(t, s, handler, 2)
+
+	pushed := make(chan struct{})
+	go func() {
+		s.push(queueEvent(3))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("Push did not block on a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(handler.release)
+	select {
+	case <-pushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Push was not released")
+	}
+
+	waitFor(t, "delivery", func() bool { return s.Stats().Delivered == 3 })
+	if handler.Received() != "[1 2 3]" || s.Stats().Dropped != 0 {
+		t.Errorf("Unexpected delivery: %s %+v", handler.Received(), s.Stats())
+	}
+}
+
+func TestSubscriptionBlockClose(t *testing.T) {
+	handler := newBlockingHandler()
+	defer close(handler.release)
+	s := newSubscription(handler.handle, QueueConfig{Size: 1, Policy: QueueBlock}, func() {}, nil)
+
+	fillQueue(t, s, handler, 2)
+
+	pushed := make(chan struct{})
+	go func() {
+		s.push(queueEvent(3))
+		close(pushed)
+	}()
+
+	s.Close()
+	select {
+	case <-pushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not release a blocked push")
+	}
+}
+
+func TestSubscriptionDisconnect(t *testing.T) {
+	handler := newBlockingHandler()
+	defer close(handler.release)
+
+	removed := make(chan struct{})
+	s := newSubscription(handler.handle, QueueConfig{Size: 2, Policy: QueueDisconnect},
+		func() { close(removed) }, nil)
+
+	fillQueue(t, s, handler, 4)
+
+	select {
+	case <-removed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscription was not removed")
+	}
+
+	stats := s.Stats()
+	if !stats.Disconnected || stats.Dropped != 3 || stats.Length != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	if !errors.Is(s.Err(), ErrQueueOverflow) {
+		t.Errorf("Expected ErrQueueOverflow, got: %v", s.Err())
+	}
+
+	// Events after the disconnect are ignored
+	s.push(queueEvent(5))
+	if s.Stats().Dropped != 3 {
+		t.Errorf("Unexpected stats after disconnect: %+v", s.Stats())
+	}
+}
+
+func TestSubscriptionHighWatermark(t *testing.T) {
+	var lock sync.Mutex
+	var lengths []int
+
+	handler := newBlockingHandler()
+	config := QueueConfig{
+		Size:          10,
+		HighWatermark: 3,
+		OnHighWatermark: func(length int) {
+			lock.Lock()
+			defer lock.Unlock()
+			lengths = append(lengths, length)
+		},
+	}
+	s := newSubscription(handler.handle, config, func() {}, nil)
+	defer s.Close()
+
+	// 1 is handled, 2-5 are queued
+	fillQueue(t, s, handler, 5)
+
+	close(handler.release)
+	waitFor(t, "delivery", func() bool { return s.Stats().Delivered == 5 })
+
+	s.push(queueEvent(6))
+	waitFor(t, "delivery", func() bool { return s.Stats().Delivered == 6 })
+
+	lock.Lock()
+	defer lock.Unlock()
+	if fmt.Sprint(lengths) != "[3]" {
+		t.Errorf("Expected a single high watermark at 3, got %v", lengths)
+	}
+}
+
+func TestQueuePolicyText(t *testing.T) {
+	for policy, name := range queuePolicyNames {
+		text, err := policy.MarshalText()
+		if err != nil || string(text) != name {
+			t.Errorf("Unexpected text of %d: %s %v", policy, text, err)
+		}
+
+		var parsed QueuePolicy
+		err = parsed.UnmarshalText([]byte(name))
+		if err != nil || parsed != policy {
+			t.Errorf("Unexpected policy of %s: %d %v", name, parsed, err)
+		}
+	}
+
+	_, err := QueuePolicy(10).MarshalText()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got: %v", err)
+	}
+
+	cfg, err := ParseConfig([]byte(`{"host": "127.0.0.1", "event_queue": {"policy": "drop-oldest", "high_watermark": 10}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cfg.EventQueue.Size != DefaultQueueSize || cfg.EventQueue.Policy != QueueDropOldest || cfg.EventQueue.HighWatermark != 10 {
+		t.Errorf("Unexpected queue: %+v", cfg.EventQueue)
+	}
+
+	_, err = ParseConfig([]byte(`{"host": "127.0.0.1", "event_queue": {"policy": "wait"}}`))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got: %v", err)
+	}
+}
+
+func TestClientSlowSubscriber(t *testing.T) {
+	server := newFakeClientServer(t)
+
+	client, err := NewClient(NewConfig(server.Addr(), fakePassword))
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	slow := newBlockingHandler()
+	defer close(slow.release)
+	subscription, err := client.HandleQueue("HEARTBEAT", slow.handle, QueueConfig{Size: 1, Policy: QueueDropNewest})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	fast := make(chan *Message, 10)
+	client.Handle("HEARTBEAT", func(event *Message) { fast <- event })
+
+	_, err = client.HandleQueue("HEARTBEAT", slow.handle, QueueConfig{})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got: %v", err)
+	}
+
+	err = client.Subscribe("HEARTBEAT")
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err)
+	}
+
+	// The slow subscriber is stuck on event 1, so its queue holds event 2
+	server.Publish(fakeEvent("Event-Name: HEARTBEAT", "Event-Sequence: 1"))
+	select {
+	case <-slow.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Slow handler was not called")
+	}
+	for i := 2; i <= 5; i++ {
+		server.Publish(fakeEvent("Event-Name: HEARTBEAT", fmt.Sprintf("Event-Sequence: %d", i)))
+	}
+
+	// The fast subscriber gets every event, while the slow one is stuck
+	for i := 1; i <= 5; i++ {
+		select {
+		case <-fast:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for event %d", i)
+		}
+	}
+
+	waitFor(t, "slow subscriber drops", func() bool {
+		stats := subscription.Stats()
+		return stats.Length == 1 && stats.Dropped == 3
+	})
+}
@@ -0,0 +1,266 @@
+package esl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Current file contains a recorder that captures the traffic of a Socket, and
+// a replayer that plays a recording back as a fake ESL server.
+//
+// The recording format is NDJSON, each line holds a single frame:
+//
+//	{"time":"2020-10-10T10:10:10.123456789Z","direction":"in","data":"Q29udGVudC1UeXBlOiBhdXRoL3JlcXVlc3QKCg=="}
+//
+// direction is "in" for frames that were received from Freeswitch, and "out"
+// for frames that were sent to it. data holds the raw bytes of the frame in
+// base64, since frames are not always valid UTF-8. Passwords of auth commands
+// are redacted.
+
+// RecordDirection is the direction of a recorded frame
+type RecordDirection string
+
+// Recorded frames directions
+const (
+	RecordIn  RecordDirection = "in"
+	RecordOut RecordDirection = "out"
+)
+
+// redactedPassword replaces passwords when a command is recorded or logged
+const redactedPassword = "********"
+
+// RecordEntry is a single recorded frame
+type RecordEntry struct {
+	Time      time.Time       `json:"time"`
+	Direction RecordDirection `json:"direction"`
+	Data      []byte          `json:"data"`
+}
+
+// Recorder writes every frame that is sent or received by a Socket as NDJSON.
+// Use Socket.SetRecorder before Login in order to capture the full session.
+type Recorder struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	now     func() time.Time
+}
+
+// NewRecorder creates a new recorder that writes into w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		encoder: json.NewEncoder(w),
+		now:     time.Now,
+	}
+}
+
+// Record writes a single frame
+func (r *Recorder) Record(direction RecordDirection, data []byte) error {
+	if r == nil {
+		return nil
+	}
+
+	content := data
+	if direction == RecordOut {
+		content = []byte(redactCommand(string(data)))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.encoder.Encode(RecordEntry{
+		Time:      r.now(),
+		Direction: direction,
+		Data:      content,
+	})
+}
+
+// LoadRecording reads all the entries of a recording
+func LoadRecording(r io.Reader) ([]RecordEntry, error) {
+	var entries []RecordEntry
+
+	decoder := json.NewDecoder(r)
+	for {
+		var entry RecordEntry
+		err := decoder.Decode(&entry)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+
+		if entry.Direction != RecordIn && entry.Direction != RecordOut {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRecordDirection, entry.Direction)
+		}
+
+		entries = append(entries, entry)
+	}
+}
+
+// Replayer plays a recording back as a fake ESL server.
+//
+// The replayer accepts a single connection, writes the "in" frames in the
+// recorded timing, and waits for a command from the client on every "out"
+// frame. When the recording ends, the connection is closed.
+type Replayer struct {
+	entries  []RecordEntry
+	speed    float64
+	listener net.Listener
+
+	done chan struct{}
+	err  error
+}
+
+// NewReplayer starts to listen on a local address for a connection that the
+// recording will be played to.
+//
+// speed of 1 plays the recording at real time, 10 plays it 10 times faster,
+// and 0 (or less) plays it without any delay.
+func NewReplayer(entries []RecordEntry, speed float64) (*Replayer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	replayer := &Replayer{
+		entries:  entries,
+		speed:    speed,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+
+	go replayer.serve()
+
+	return replayer, nil
+}
+
+// Addr returns the address that the replayer listen to
+func (r *Replayer) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// Wait until the recording was played, and return an error if the playback
+// failed
+func (r *Replayer) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Close stops the replayer
+func (r *Replayer) Close() error {
+	return r.listener.Close()
+}
+
+func (r *Replayer) serve() {
+	defer close(r.done)
+
+	conn, err := r.listener.Accept()
+	r.listener.Close()
+	if err != nil {
+		r.err = err
+		return
+	}
+	defer conn.Close()
+
+	r.err = r.play(conn)
+}
+
+func (r *Replayer) play(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+
+	var last time.Time
+	for idx, entry := range r.entries {
+		if idx > 0 && r.speed > 0 {
+			delay := time.Duration(float64(entry.Time.Sub(last)) / r.speed)
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+		}
+		last = entry.Time
+
+		switch entry.Direction {
+		case RecordIn:
+			_, err := conn.Write(entry.Data)
+			if err != nil {
+				return err
+			}
+		case RecordOut:
+			_, err := readCommand(reader)
+			if err != nil {
+				return fmt.Errorf("Waiting for command #%d: %w", idx, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readCommand reads a command that was sent by a client: lines up to an empty
+// line, and a body if a Content-Length header exists
+func readCommand(reader *bufio.Reader) (string, error) {
+	var command strings.Builder
+	contentLength := 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		command.WriteString(line)
+
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "" {
+			if command.Len() == len(line) {
+				// Empty line before the command
+				command.Reset()
+				continue
+			}
+			break
+		}
+
+		idx := strings.Index(trimmed, ":")
+		if idx > 0 && strings.EqualFold(trimmed[:idx], "Content-Length") {
+			contentLength, _ = strconv.Atoi(strings.TrimSpace(trimmed[idx+1:]))
+		}
+	}
+
+	if contentLength > 0 {
+		body := make([]byte, contentLength)
+		_, err := io.ReadFull(reader, body)
+		if err != nil {
+			return "", err
+		}
+		command.Write(body)
+	}
+
+	return command.String(), nil
+}
+
+// redactCommand hides the password of auth and userauth commands
+func redactCommand(cmd string) string {
+	switch {
+	case strings.HasPrefix(cmd, "auth "):
+		return "auth " + redactedPassword + trailingEOL(cmd)
+	case strings.HasPrefix(cmd, "userauth "):
+		user := strings.TrimSpace(strings.TrimPrefix(cmd, "userauth "))
+		idx := strings.Index(user, ":")
+		if idx >= 0 {
+			user = user[:idx]
+		}
+		return "userauth " + user + ":" + redactedPassword + trailingEOL(cmd)
+	}
+	return cmd
+}
+
+// trailingEOL returns the EOL characters at the end of s
+func trailingEOL(s string) string {
+	trimmed := strings.TrimRight(s, "\r\n")
+	return s[len(trimmed):]
+}
@@ -0,0 +1,200 @@
+package esl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorderRecordAndReplay(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "api echo hello" {
+			return fakeAPIResponse("hello")
+		}
+		return fakeAPIResponse("-ERR command not found\n")
+	})
+
+	var recording bytes.Buffer
+
+	socket, err := Dial(server.Addr(), fakePassword, 0, 5*time.Second)
+	if err != nil {
+		t.Errorf("Unable to dial: %s", err)
+		return
+	}
+	socket.SetRecorder(NewRecorder(&recording))
+
+	_, err = socket.Login()
+	if err != nil {
+		t.Errorf("Unable to login: %s", err)
+		return
+	}
+
+	msg, err := socket.API("echo", "hello")
+	if err != nil || string(msg.Body) != "hello" {
+		t.Errorf("Unexpected reply: %v %v", msg, err)
+		return
+	}
+	socket.Close()
+
+	if strings.Contains(recording.String(), fakePassword) {
+		t.Errorf("Password was recorded: %s", recording.String())
+	}
+
+	entries, err := LoadRecording(&recording)
+	if err != nil {
+		t.Errorf("Unable to load recording: %s", err)
+		return
+	}
+
+	directions := []RecordDirection{RecordIn, RecordOut, RecordIn, RecordOut, RecordIn}
+	if len(entries) != len(directions) {
+		t.Errorf("Expected %d entries, got %d: %+v", len(directions), len(entries), entries)
+		return
+	}
+
+	for idx, direction := range directions {
+		if entries[idx].Direction != direction {
+			t.Errorf("Expected (%d) direction %s, got %s", idx, direction, entries[idx].Direction)
+		}
+	}
+
+	if string(entries[1].Data) != "auth "+redactedPassword+"\n\n" {
+		t.Errorf("Unexpected auth entry: %q", entries[1].Data)
+	}
+
+	replayer, err := NewReplayer(entries, 0)
+	if err != nil {
+		t.Errorf("Unable to start replayer: %s", err)
+		return
+	}
+	defer replayer.Close()
+
+	// The replayer does not validate the password
+	replayed, err := Connect(replayer.Addr(), "other", 0, 5*time.Second)
+	if err != nil {
+		t.Errorf("Unable to connect to replayer: %s", err)
+		return
+	}
+	defer replayed.Close()
+
+	msg, err = replayed.API("echo", "hello")
+	if err != nil || string(msg.Body) != "hello" {
+		t.Errorf("Unexpected replayed reply: %v %v", msg, err)
+	}
+
+	err = replayer.Wait()
+	if err != nil {
+		t.Errorf("Unexpected replay error: %s", err)
+	}
+
+	_, err = replayed.ReadMessage()
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Expected EOF at the end of the recording, got: %v", err)
+	}
+}
+
+func TestReplayerSpeed(t *testing.T) {
+	start := time.Now()
+	entries := []RecordEntry{
+		{Time: start, Direction: RecordIn, Data: []byte("Content-Type: auth/request\n\n")},
+		{Time: start.Add(time.Second), Direction: RecordIn, Data: []byte("Content-Type: text/disconnect-notice\nContent-Length: 3\n\nbye")},
+	}
+
+	replayer, err := NewReplayer(entries, 20)
+	if err != nil {
+		t.Errorf("Unable to start replayer: %s", err)
+		return
+	}
+	defer replayer.Close()
+
+	socket, err := Dial(replayer.Addr(), "", 0, 5*time.Second)
+	if err != nil {
+		t.Errorf("Unable to dial: %s", err)
+		return
+	}
+	defer socket.Close()
+
+	begin := time.Now()
+	for i := 0; i < 2; i++ {
+		_, err = socket.ReadMessage()
+		if err != nil {
+			t.Errorf("Unable to read message (%d): %s", i, err)
+			return
+		}
+	}
+
+	elapsed := time.Since(begin)
+	if elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected about 50ms of delay at speed 20, got %s", elapsed)
+	}
+}
+
+func TestRecorderBinaryData(t *testing.T) {
+	data := []byte("Content-Type: api/response\nContent-Length: 3\n\n\xff\xfe\x00")
+
+	var recording bytes.Buffer
+	err := NewRecorder(&recording).Record(RecordIn, data)
+	if err != nil {
+		t.Fatalf("Unable to record: %s", err)
+	}
+
+	entries, err := LoadRecording(&recording)
+	if err != nil {
+		t.Fatalf("Unable to load recording: %s", err)
+	}
+	if len(entries) != 1 || !bytes.Equal(entries[0].Data, data) {
+		t.Errorf("Expected %q, got %+v", data, entries)
+	}
+}
+
+func TestSocketSetRecorderWhileUsed(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeAPIResponse("+OK")
+	})
+	socket := connectFakeServer(t, server)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			socket.API("status", "")
+		}
+	}()
+
+	var recording bytes.Buffer
+	socket.SetRecorder(NewRecorder(&recording))
+	<-done
+	socket.SetRecorder(nil)
+}
+
+func TestLoadRecordingInvalidDirection(t *testing.T) {
+	input := `{"time":"2020-10-10T10:10:10Z","direction":"sideways","data":""}`
+
+	_, err := LoadRecording(strings.NewReader(input))
+	if !errors.Is(err, ErrInvalidRecordDirection) {
+		t.Errorf("Expected ErrInvalidRecordDirection, got: %v", err)
+	}
+}
+
+func TestRedactCommand(t *testing.T) {
+	type fixture struct {
+		input    string
+		expected string
+	}
+
+	fixtures := []fixture{
+		{input: "auth ClueCon\n\n", expected: "auth ********\n\n"},
+		{input: "userauth admin@default:secret", expected: "userauth admin@default:********"},
+		{input: "api status\n\n", expected: "api status\n\n"},
+	}
+
+	for idx, test := range fixtures {
+		result := redactCommand(test.input)
+		if result != test.expected {
+			t.Errorf("Expected (%d) %q, got %q", idx, test.expected, result)
+		}
+	}
+}
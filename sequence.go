@@ -0,0 +1,146 @@
+package esl
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Current file contains the detection of lost events.
+//
+// Freeswitch numbers every event using the Event-Sequence header, so a gap
+// in the sequence means that events were lost (e.g. during a reconnect), and
+// that a state that is built from events (such as a Tracker) is stale.
+//
+// The sequence counts every event of the server, so gaps are meaningful only
+// on a connection that is subscribed to all the events, without filters.
+
+// SequenceGap describes events that were missed or duplicated
+type SequenceGap struct {
+	// Expected is the sequence that should have arrived
+	Expected uint64
+	// Received is the sequence that arrived
+	Received uint64
+	// Missed is the number of events that were lost, 0 for a duplicate
+	Missed uint64
+	// Duplicate is true if an event with an older sequence arrived
+	Duplicate bool
+	// Restarted is true if the server was restarted (Core-UUID changed), so
+	// the sequence started over and events could have been lost
+	Restarted bool
+}
+
+// SequenceStats holds the counters of a SequenceTracker
+type SequenceStats struct {
+	Last       uint64
+	Gaps       uint64
+	Missed     uint64
+	Duplicates uint64
+	Restarts   uint64
+}
+
+// SequenceCollector is an optional interface of a MetricsCollector, that is
+// notified about lost and duplicated events
+type SequenceCollector interface {
+	// EventsMissed is called with the number of events that were lost
+	EventsMissed(n uint64)
+	// EventDuplicated is called when an event arrived more than once
+	EventDuplicated()
+}
+
+// SequenceTracker tracks the Event-Sequence of the events of a connection
+type SequenceTracker struct {
+	lock     sync.Mutex
+	coreUUID string
+	last     uint64
+	started  bool
+	stats    SequenceStats
+}
+
+// NewSequenceTracker creates a new SequenceTracker
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{}
+}
+
+// Track checks the sequence of an event, and returns the gap if events were
+// missed or duplicated. Events without Event-Sequence are ignored.
+func (t *SequenceTracker) Track(headers Headers) (SequenceGap, bool) {
+	sequence, err := strconv.ParseUint(headers.GetString("Event-Sequence"), 10, 64)
+	if err != nil {
+		return SequenceGap{}, false
+	}
+	coreUUID := headers.GetString("Core-UUID")
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.started {
+		t.started = true
+		t.coreUUID = coreUUID
+		t.last = sequence
+		t.stats.Last = sequence
+		return SequenceGap{}, false
+	}
+
+	expected := t.last + 1
+
+	if coreUUID != "" && t.coreUUID != "" && coreUUID != t.coreUUID {
+		t.coreUUID = coreUUID
+		t.last = sequence
+		t.stats.Last = sequence
+		t.stats.Restarts++
+		return SequenceGap{Expected: expected, Received: sequence, Restarted: true}, true
+	}
+	if coreUUID != "" {
+		t.coreUUID = coreUUID
+	}
+
+	switch {
+	case sequence == expected:
+		t.last = sequence
+		t.stats.Last = sequence
+		return SequenceGap{}, false
+	case sequence < expected:
+		t.stats.Duplicates++
+		return SequenceGap{Expected: expected, Received: sequence, Duplicate: true}, true
+	default:
+		missed := sequence - expected
+		t.last = sequence
+		t.stats.Last = sequence
+		t.stats.Gaps++
+		t.stats.Missed += missed
+		return SequenceGap{Expected: expected, Received: sequence, Missed: missed}, true
+	}
+}
+
+// Stats returns the counters of the tracker
+func (t *SequenceTracker) Stats() SequenceStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.stats
+}
+
+// Reset forgets the last sequence, so the next event is not compared with it
+func (t *SequenceTracker) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.started = false
+	t.coreUUID = ""
+	t.last = 0
+}
+
+// reportGap reports a gap into a collector that implements SequenceCollector
+func reportGap(metrics MetricsCollector, gap SequenceGap) {
+	collector, ok := metrics.(SequenceCollector)
+	if !ok {
+		return
+	}
+
+	switch {
+	case gap.Duplicate:
+		collector.EventDuplicated()
+	case gap.Missed > 0:
+		collector.EventsMissed(gap.Missed)
+	}
+}
@@ -0,0 +1,79 @@
+package esl
+
+import "testing"
+
+func sequenceHeaders(sequence, coreUUID string) Headers {
+	headers := NewHeaders()
+	headers.Add("Event-Name", "HEARTBEAT")
+	if sequence != "" {
+		headers.Add("Event-Sequence", sequence)
+	}
+	if coreUUID != "" {
+		headers.Add("Core-UUID", coreUUID)
+	}
+	return headers
+}
+
+func TestSequenceTracker(t *testing.T) {
+	type fixture struct {
+		sequence string
+		coreUUID string
+		gap      *SequenceGap
+	}
+
+	fixtures := []fixture{
+		{sequence: "10", coreUUID: "core-1"},
+		{sequence: "11", coreUUID: "core-1"},
+		{sequence: "", coreUUID: "core-1"},
+		{sequence: "invalid", coreUUID: "core-1"},
+		{sequence: "12"},
+		{sequence: "15", coreUUID: "core-1", gap: &SequenceGap{Expected: 13, Received: 15, Missed: 2}},
+		{sequence: "14", coreUUID: "core-1", gap: &SequenceGap{Expected: 16, Received: 14, Duplicate: true}},
+		{sequence: "15", coreUUID: "core-1", gap: &SequenceGap{Expected: 16, Received: 15, Duplicate: true}},
+		{sequence: "16", coreUUID: "core-1"},
+		{sequence: "3", coreUUID: "core-2", gap: &SequenceGap{Expected: 17, Received: 3, Restarted: true}},
+		{sequence: "4", coreUUID: "core-2"},
+	}
+
+	tracker := NewSequenceTracker()
+	for idx, f := range fixtures {
+		gap, found := tracker.Track(sequenceHeaders(f.sequence, f.coreUUID))
+
+		if f.gap == nil {
+			if found {
+				t.Errorf("Unexpected gap (%d): %+v", idx, gap)
+			}
+			continue
+		}
+
+		if !found || gap != *f.gap {
+			t.Errorf("Expected gap (%d) %+v, got %+v (%t)", idx, *f.gap, gap, found)
+		}
+	}
+
+	expected := SequenceStats{Last: 4, Gaps: 1, Missed: 2, Duplicates: 2, Restarts: 1}
+	if tracker.Stats() != expected {
+		t.Errorf("Expected stats %+v, got %+v", expected, tracker.Stats())
+	}
+
+	tracker.Reset()
+	_, found := tracker.Track(sequenceHeaders("100", "core-3"))
+	if found {
+		t.Errorf("Unexpected gap after reset")
+	}
+}
+
+func TestReportGap(t *testing.T) {
+	metrics := NewMetrics()
+
+	reportGap(metrics, SequenceGap{Missed: 3})
+	reportGap(metrics, SequenceGap{Duplicate: true})
+	reportGap(metrics, SequenceGap{Restarted: true})
+	// Collectors that do not implement SequenceCollector are ignored
+	reportGap(nil, SequenceGap{Missed: 1})
+
+	snapshot := metrics.Snapshot()
+	if snapshot.EventsMissed != 3 || snapshot.EventDuplicates != 1 {
+		t.Errorf("Unexpected snapshot: %d %d", snapshot.EventsMissed, snapshot.EventDuplicates)
+	}
+}
@@ -0,0 +1,284 @@
+package esl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakePassword = "ClueCon"
+
+// fakeServer is a minimal ESL server, used to test commands without a running
+// Freeswitch.
+//
+// handler receives every command (except for auth) and returns the raw
+// content to write back.
+type fakeServer struct {
+	listener net.Listener
+	handler  func(cmd string) string
+
+	lock     sync.Mutex
+	commands []string
+	conns    map[*fakeConn]struct{}
+}
+
+// fakeConn is a connection of the fake server
+type fakeConn struct {
+	conn net.Conn
+
+	lock       sync.Mutex
+	subscribed bool
+}
+
+func (c *fakeConn) write(raw string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.conn.Write([]byte(raw))
+	return err
+}
+
+func newFakeServer(t *testing.T, handler func(cmd string) string) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+
+	server := &fakeServer{
+		listener: listener,
+		handler:  handler,
+	}
+
+	go server.serve()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (f *fakeServer) Addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeServer) Close() {
+	f.listener.Close()
+	f.Drop()
+}
+
+// Drop closes all the open connections, while new connections are still
+// accepted
+func (f *fakeServer) Drop() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for conn := range f.conns {
+		conn.conn.Close()
+	}
+}
+
+// Publish writes raw to every connection that subscribed to events, and
+// returns the number of connections
+func (f *fakeServer) Publish(raw string) int {
+	f.lock.Lock()
+	var conns []*fakeConn
+	for conn := range f.conns {
+		conn.lock.Lock()
+		if conn.subscribed {
+			conns = append(conns, conn)
+		}
+		conn.lock.Unlock()
+	}
+	f.lock.Unlock()
+
+	for _, conn := range conns {
+		conn.write(raw)
+	}
+	return len(conns)
+}
+
+// Subscribers returns the number of connections that subscribed to events
+func (f *fakeServer) Subscribers() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	count := 0
+	for conn := range f.conns {
+		conn.lock.Lock()
+		if conn.subscribed {
+			count++
+		}
+		conn.lock.Unlock()
+	}
+	return count
+}
+
+// Commands returns all the commands that the server received
+func (f *fakeServer) Commands() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakeServer) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	fc := &fakeConn{conn: conn}
+	f.lock.Lock()
+	if f.conns == nil {
+		f.conns = make(map[*fakeConn]struct{})
+	}
+	f.conns[fc] = struct{}{}
+	f.lock.Unlock()
+
+	defer func() {
+		f.lock.Lock()
+		delete(f.conns, fc)
+		f.lock.Unlock()
+	}()
+
+	err := fc.write("Content-Type: auth/request\n\n")
+	if err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		cmd, err := readFakeCommand(reader)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		if strings.HasPrefix(cmd, "auth ") {
+			if cmd == "auth "+fakePassword {
+				reply = fakeCommandReply("+OK accepted")
+			} else {
+				reply = fakeCommandReply("-ERR invalid")
+			}
+		} else {
+			f.lock.Lock()
+			f.commands = append(f.commands, cmd)
+			f.lock.Unlock()
+
+			if strings.HasPrefix(cmd, "event ") {
+				fc.lock.Lock()
+				fc.subscribed = true
+				fc.lock.Unlock()
+			}
+
+			reply = f.handler(strings.TrimSpace(cmd))
+		}
+
+		if reply == "" {
+			continue
+		}
+
+		err = fc.write(reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readFakeCommand reads a command up to an empty line, and its body (after
+// an empty line) if it has Content-Length
+func readFakeCommand(reader *bufio.Reader) (string, error) {
+	var lines []string
+	length := 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" && len(lines) > 0 {
+			break
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+		}
+
+		if value, found := strings.CutPrefix(line, "Content-Length: "); found {
+			length, _ = strconv.Atoi(value)
+		}
+	}
+
+	cmd := strings.Join(lines, "\n")
+	if length <= 0 {
+		return cmd, nil
+	}
+
+	body := make([]byte, length)
+	_, err := io.ReadFull(reader, body)
+	if err != nil {
+		return "", err
+	}
+
+	return cmd + "\n\n" + string(body), nil
+}
+
+func fakeCommandReply(text string) string {
+	return fmt.Sprintf("Content-Type: command/reply\nReply-Text: %s\n\n", text)
+}
+
+func fakeAPIResponse(body string) string {
+	return fmt.Sprintf("Content-Type: api/response\nContent-Length: %d\n\n%s", len(body), body)
+}
+
+// connectFakeServer connects and login into a fake server
+func connectFakeServer(t *testing.T, server *fakeServer) *Socket {
+	t.Helper()
+
+	socket, err := Connect(server.Addr(), fakePassword, 0, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Unable to connect to fake server: %s", err)
+	}
+	t.Cleanup(func() { socket.Close() })
+
+	return socket
+}
+
+// fakeEvent returns a text/event-plain frame with the given header lines
+// (e.g. "Event-Name: HEARTBEAT"), the values must be url encoded.
+func fakeEvent(lines ...string) string {
+	body := strings.Join(lines, "\n") + "\n\n"
+	return fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+}
+
+// fakeJSONEvent returns a text/event-json frame with the given body
+func fakeJSONEvent(body string) string {
+	return fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-json\n\n%s", len(body), body)
+}
+
+// fakeEventMessage returns the parsed message of a fakeEvent frame
+func fakeEventMessage(t testing.TB, lines ...string) *Message {
+	t.Helper()
+
+	msg, err := NewMessage([]byte(fakeEvent(lines...)), true)
+	if err != nil {
+		t.Fatalf("Unable to parse event: %s", err)
+	}
+	return msg
+}
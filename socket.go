@@ -3,68 +3,158 @@ package esl
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 )
 
+// ConnState is the lifecycle state of a Socket
+type ConnState int32
+
+// The states of a Socket. A socket moves forward only: new, connected,
+// authenticated (after Login), closing and closed.
+const (
+	StateNew ConnState = iota
+	StateConnected
+	StateAuthenticated
+	StateClosing
+	StateClosed
+)
+
+func (c ConnState) String() string {
+	switch c {
+	case StateNew:
+		return "new"
+	case StateConnected:
+		return "connected"
+	case StateAuthenticated:
+		return "authenticated"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int32(c))
+	}
+}
+
 // Socket is low level ESL connection.
 // Socket will generate keep-alive for a connection, to keep it open in order for
 // a single connection will not be dropped after sending/receiving a payload.
+//
+// A Socket is safe for concurrent use: a command holds the socket until its
+// reply arrives, so replies are never mixed. Events can be read using
+// ReadMessage while commands are sent on the same socket: a command takes
+// only its reply, and the events that arrived while it waited are kept for
+// ReadMessage. A Socket must not be copied.
 type Socket struct {
-	conn       *net.TCPConn
-	host       string
-	password   string
-	maxRetries uint64
-	timeout    time.Duration
-	ctx        *context.Context
-	loggedin   bool
-	reader     *bufio.Reader
-	writer     *bufio.Writer
-	lock       *sync.RWMutex
+	conn         net.Conn
+	host         string
+	password     string
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	eventFormat  EventOutputType
+	state        atomic.Int32
+	reader       *bufio.Reader
+	parser       *Parser
+	writer       *bufio.Writer
+	// lock is held by a command from sending it until its reply was read
+	lock sync.Mutex
+	// reading is held by the goroutine that reads from the connection
+	reading chan struct{}
+	// replies passes a reply that ReadMessage read to the command that waits
+	// for it
+	replies chan reply
+	// waiting is true while a command waits for its reply
+	waiting atomic.Bool
+	// pending holds the messages that a command read while it waited for its
+	// reply, until they are read using ReadMessage
+	pendingLock sync.Mutex
+	pending     []*Message
+	// writeLock is held while writing a frame
+	writeLock sync.Mutex
+	// recorder, observer, metrics and tracer can be set while the socket is
+	// used (see SetRecorder, SetObserver, SetMetrics and SetTracer)
+	recorder atomic.Pointer[Recorder]
+	observer atomic.Pointer[Observer]
+	metrics  atomic.Pointer[MetricsCollector]
+	tracer   atomic.Pointer[Tracer]
 }
 
 // Dial open an new connection for Freeswitch, with retries until it maxRetries
 // is due.
 // If host does not contain port (e.g. freeswitch.example.com:8021), the default
-// port will be assigned (8021).
+// port will be assigned (8021). An empty host connects to localhost.
 // password is a clear text password that is sent to the ESL auth request.
 // timeout is the amount of waiting until dialing to ESL will fail if no answer was provided.
 //
 // If maxRetries is 0, it will not retry if failed.
 // The retry is using Backoff algorithm.
+//
+// Use DialConfig for more settings.
 func Dial(host string, password string, maxRetries uint64, timeout time.Duration) (*Socket, error) {
-	socket := Socket{
-		host:       setPort(host, DefaultPort),
-		password:   password,
-		maxRetries: maxRetries,
-		timeout:    timeout,
-		lock:       &sync.RWMutex{},
+	return DialConfig(legacyConfig(host, password, maxRetries, timeout))
+}
+
+// legacyConfig returns the config of the Dial arguments: timeout limits the
+// whole dial (including retries), and it is the keep-alive period. An empty
+// host is localhost, as it was before Config required a host.
+func legacyConfig(host string, password string, maxRetries uint64, timeout time.Duration) Config {
+	if host == "" {
+		host = DefaultHost
 	}
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
-	socket.ctx = &ctx
 
-	var conn *net.TCPConn
-	var remoteAddr *net.TCPAddr
-	var err error
+	return NewConfig(host, password,
+		WithDialTimeout(timeout),
+		WithKeepAlive(timeout),
+		WithBackoff(BackoffConfig{MaxRetries: maxRetries, MaxElapsedTime: timeout}),
+	)
+}
 
-	remoteAddr, err = net.ResolveTCPAddr("tcp", socket.host)
+// DialConfig open a new connection for Freeswitch using cfg, with retries
+// based on its backoff policy.
+func DialConfig(cfg Config) (*Socket, error) {
+	err := cfg.validateSocket()
 	if err != nil {
 		return nil, err
 	}
 
-	bo := backoff.WithContext(
-		backoff.WithMaxRetries(
-			backoff.NewExponentialBackOff(), maxRetries,
-		), *socket.ctx)
+	socket := &Socket{
+		host:         setPort(cfg.Host, DefaultPort),
+		password:     cfg.Password,
+		readTimeout:  cfg.ReadTimeout,
+		writeTimeout: cfg.WriteTimeout,
+		eventFormat:  cfg.EventFormat,
+	}
+	socket.observer.Store(&cfg.Observer)
+	socket.metrics.Store(&cfg.Metrics)
+	socket.tracer.Store(&cfg.Tracer)
+
+	ctx := context.Background()
+	if cfg.Backoff.MaxElapsedTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Backoff.MaxElapsedTime)
+		defer cancel()
+	}
+
+	dialer := cfg.dialer()
+	bo := backoff.WithContext(cfg.Backoff.newBackOff(), ctx)
 
 	err = backoff.Retry(func() error {
-		// conn, err = net.DialTimeout("tcp", socket.host, socket.timeout)
-		conn, err = net.DialTCP("tcp", nil, remoteAddr)
+		attemptCtx := ctx
+		if cfg.DialTimeout > 0 {
+			var cancel context.CancelFunc
+			attemptCtx, cancel = context.WithTimeout(ctx, cfg.DialTimeout)
+			defer cancel()
+		}
+
+		conn, err := dialer.DialContext(attemptCtx, "tcp", socket.host)
 		if err == nil {
 			socket.conn = conn
 		}
@@ -75,19 +165,29 @@ func Dial(host string, password string, maxRetries uint64, timeout time.Duration
 		return nil, err
 	}
 
-	socket.reader = bufio.NewReader(conn)
-	socket.writer = bufio.NewWriter(conn)
-	// make sure the connection stay open if possible
-	socket.conn.SetKeepAlive(true)
-	socket.conn.SetKeepAlivePeriod(timeout)
+	socket.reader = bufio.NewReaderSize(socket.conn, cfg.ReadBufferSize)
+	socket.parser = NewParser(socket.reader)
+	socket.parser.SetMaxFrameSize(cfg.MaxFrameSize)
+	socket.writer = bufio.NewWriterSize(socket.conn, cfg.WriteBufferSize)
+	socket.reading = make(chan struct{}, 1)
+	socket.replies = make(chan reply, 1)
+	socket.state.Store(int32(StateConnected))
 
-	return &socket, nil
+	socket.observeConnect()
+
+	return socket, nil
 }
 
 // Connect Connect to ESL and does a login.
 // If an error occurs, it will disconnect and return an error
 func Connect(host string, password string, maxRetries uint64, timeout time.Duration) (*Socket, error) {
-	socket, err := Dial(host, password, maxRetries, timeout)
+	return ConnectConfig(legacyConfig(host, password, maxRetries, timeout))
+}
+
+// ConnectConfig connect to ESL using cfg and does a login.
+// If an error occurs, it will disconnect and return an error
+func ConnectConfig(cfg Config) (*Socket, error) {
+	socket, err := DialConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -110,137 +210,515 @@ func Connect(host string, password string, maxRetries uint64, timeout time.Durat
 	return socket, nil
 }
 
-// Close a connection
-func (s Socket) Close() error {
-	err := s.writer.Flush()
+// State returns the lifecycle state of the socket
+func (s *Socket) State() ConnState {
+	return ConnState(s.state.Load())
+}
+
+// Close a connection. Close can be called more than once, and on a socket
+// that was never connected. Reads that are blocked fail with ErrClosed.
+func (s *Socket) Close() error {
+	for {
+		state := s.State()
+		if state == StateClosing || state == StateClosed {
+			return nil
+		}
+		if s.state.CompareAndSwap(int32(state), int32(StateClosing)) {
+			break
+		}
+	}
+
+	if s.conn == nil {
+		s.state.Store(int32(StateClosed))
+		return nil
+	}
+
+	// Frames are flushed when written, and the locks are not taken, so a
+	// blocked read does not block closing
+	err := s.conn.Close()
+	s.state.Store(int32(StateClosed))
+	s.observeDisconnect(err)
+	return err
+}
+
+// usable returns an error if the socket can not be used for I/O
+func (s *Socket) usable() error {
+	if s.conn == nil {
+		return ErrConnectionIsNotInitialized
+	}
+	if s.State() >= StateClosing {
+		return ErrClosed
+	}
+	return nil
+}
+
+// ioError wraps an error of reading or writing, based on the state of the
+// socket
+func (s *Socket) ioError(err error) error {
+	if s.State() >= StateClosing {
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	return timeoutError(err)
+}
+
+// Send a request to ESL.
+// If cmd ends with EOL, or contains an empty line (that would end the
+// command and start another one), ErrCmdEOL is returned.
+func (s *Socket) Send(cmd string) error {
+	err := s.usable()
 	if err != nil {
 		return err
 	}
 
-	err = s.conn.SetKeepAlive(false)
+	if strings.HasSuffix(cmd, EOL) || strings.Contains(cmd, EOL+EOL) || strings.Contains(cmd, EOL+"\r"+EOL) {
+		return ErrCmdEOL
+	}
+
+	return s.write(cmd, cmd+EOL+EOL)
+}
+
+// write a whole frame to the connection, cmd is the part of the frame that
+// is reported to the observer
+func (s *Socket) write(cmd, buf string) error {
+	err := s.usable()
 	if err != nil {
 		return err
 	}
 
-	err = s.conn.CloseRead()
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if s.writeTimeout > 0 {
+		err := s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		if err != nil {
+			return err
+		}
+		defer s.conn.SetWriteDeadline(time.Time{})
+	}
+
+	l := len(buf)
+
+	n, err := s.writer.WriteString(buf)
+	if err == nil {
+		err = s.writer.Flush()
+	}
 	if err != nil {
+		err = s.ioError(err)
+		s.observeError(err)
 		return err
 	}
 
-	err = s.conn.CloseWrite()
-	if err != nil {
+	if n < l {
+		err = fmt.Errorf("Wrote %d bytes, expected %d", l, n)
+		s.observeError(err)
 		return err
 	}
-	return s.conn.Close()
+
+	s.recorder.Load().Record(RecordOut, []byte(buf))
+	s.observeSend(cmd)
+	s.measureSent(n)
+
+	return nil
 }
 
-// Send a request to ESL.
-// If cmd contains EOL
-func (s Socket) Send(cmd string) error {
-	if s.conn == nil {
-		return ErrConnectionIsNotInitialized
+// Recv reads a single message from the server, and returns its raw content.
+// A message that is longer than maxBuff is dropped, and ErrFrameTooLarge is
+// returned.
+func (s *Socket) Recv(maxBuff int64) (int, []byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	msg := s.popPending()
+	if msg == nil {
+		err := s.acquireReader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		_, msg, err = s.readMessageDeadline()
+		s.releaseReader()
+		if err != nil {
+			return 0, nil, err
+		}
 	}
 
-	if strings.HasSuffix(cmd, EOL) {
-		return ErrCmdEOL
+	if int64(len(msg.buf)) > maxBuff {
+		return 0, nil, fmt.Errorf("%w: message length %d is bigger than %d", ErrFrameTooLarge, len(msg.buf), maxBuff)
 	}
+	return len(msg.buf), msg.buf, nil
+}
 
-	s.lock.Lock()
-	defer s.lock.Unlock()
+// SetRecorder records every frame that is sent and received by the socket.
+// Set it before Login in order to record the whole session, or nil to stop
+// recording.
+func (s *Socket) SetRecorder(recorder *Recorder) {
+	s.recorder.Store(recorder)
+}
 
-	buf := cmd + EOL + EOL
-	l := len(buf)
+// ReadMessage reads a single message (headers and body based on
+// Content-Length) from the server and parse it.
+//
+// Unlike Recv, ReadMessage never reads more then a single message, so it can
+// be used in a loop for reading events.
+func (s *Socket) ReadMessage() (*Message, error) {
+	for {
+		msg := s.popPending()
+		if msg != nil {
+			return msg, nil
+		}
 
-	n, err := s.writer.WriteString(buf)
+		err := s.acquireReader()
+		if err != nil {
+			return nil, err
+		}
+
+		// A command could keep messages while ReadMessage waited
+		msg = s.popPending()
+		if msg != nil {
+			s.releaseReader()
+			return msg, nil
+		}
+
+		n, msg, err := s.readMessage()
+		s.releaseReader()
+		if err != nil {
+			return nil, err
+		}
+
+		if isReply(msg) && s.waiting.Load() {
+			select {
+			case s.replies <- reply{n: n, msg: msg}:
+				continue
+			default:
+			}
+		}
+
+		return msg, nil
+	}
+}
+
+// reply is a reply of a command, and its raw size
+type reply struct {
+	n   int
+	msg *Message
+}
+
+// maxPendingMessages is the max number of messages that are kept for
+// ReadMessage, when more arrive the oldest are dropped
+const maxPendingMessages = 1024
+
+// isReply is true for a message that replies to a command
+func isReply(msg *Message) bool {
+	switch msg.ContentType() {
+	case ECTCommandReply, ECTAPIResponse:
+		return true
+	}
+	return false
+}
+
+// acquireReader waits until no other goroutine reads from the connection
+func (s *Socket) acquireReader() error {
+	err := s.usable()
 	if err != nil {
 		return err
 	}
-	defer s.writer.Flush()
 
-	if n < l && s.writer.Buffered() == 0 {
-		return fmt.Errorf("Wrote %d bytes, expected %d", l, n)
+	s.reading <- struct{}{}
+	return nil
+}
+
+func (s *Socket) releaseReader() {
+	<-s.reading
+}
+
+// pushPending keeps a message that is not a reply for ReadMessage
+func (s *Socket) pushPending(msg *Message) {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	if len(s.pending) >= maxPendingMessages {
+		s.pending = s.pending[1:]
 	}
+	s.pending = append(s.pending, msg)
+}
 
-	return nil
+// popPending returns the oldest message that was kept for ReadMessage, or nil
+func (s *Socket) popPending() *Message {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	msg := s.pending[0]
+	s.pending[0] = nil
+	s.pending = s.pending[1:]
+	return msg
 }
 
-// Recv a content from the server
-func (s Socket) Recv(maxBuff int64) (int, []byte, error) {
-	if s.conn == nil {
-		return 0, nil, ErrConnectionIsNotInitialized
+// readMessage reads a single message, and returns its raw size as well
+func (s *Socket) readMessage() (int, *Message, error) {
+	err := s.usable()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	frame, err := s.parser.Next()
+	if err != nil {
+		err = s.ioError(err)
+		s.observeError(err)
+		return 0, nil, err
+	}
+
+	raw := frame.Raw()
+	s.recorder.Load().Record(RecordIn, raw)
+
+	msg := frame.Message()
+	s.observeReceive(raw, msg)
+	s.measureReceived(len(raw), msg)
+
+	return len(raw), msg, nil
+}
+
+// SetMaxFrameSize sets the max size of a message that is read using
+// ReadMessage and commands (see Parser.SetMaxFrameSize).
+func (s *Socket) SetMaxFrameSize(size int) {
+	if s.parser != nil {
+		s.parser.SetMaxFrameSize(size)
 	}
-	buf := make([]byte, maxBuff)
-	n, err := s.reader.Read(buf)
-	return n, buf, err
 }
 
 // Login into the ESL server
 func (s *Socket) Login() (bool, error) {
-	if s.loggedin {
-		return true, nil
-	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	n, content, err := s.Recv(AuthRequestBufferSize)
+	err := s.acquireReader()
 	if err != nil {
 		return false, err
 	}
-	if int64(n) >= AuthRequestBufferSize {
-		return false, fmt.Errorf("Auth length %d is too big", n)
+	defer s.releaseReader()
+
+	if s.LoggedIn() {
+		return true, nil
 	}
-	auth, err := NewMessage(content, true)
+
+	_, auth, err := s.readMessageDeadline()
 	if err != nil {
 		return false, err
 	}
 
 	contentType := auth.Headers.GetString("Content-Type")
 	if contentType != "auth/request" {
-		return false, fmt.Errorf("Invalid Content-Type: %s", contentType)
+		return false, &CommandError{
+			ContentType: EventContentType(contentType),
+			Reply:       strings.TrimSpace(string(auth.Body)),
+			Cause:       ErrUnexpectedReply,
+		}
 	}
 
-	n, content, err = s.SendRecv("auth " + s.password)
-	if err != nil {
-		return false, fmt.Errorf("Unable to send/recv auth: %s", err)
+	if validateArgs("auth", s.password) != nil {
+		return false, &ArgumentError{Command: "auth", Arg: redactedPassword, Reason: "contains CR/LF"}
 	}
 
-	if int64(n) <= AuthRequestBufferSize {
-		return false, fmt.Errorf("Invalid msg length: %d for %s", n, content)
+	var msg *Message
+	err = s.Send("auth " + s.password)
+	if err == nil {
+		_, msg, err = s.readMessageDeadline()
 	}
-
-	msg, err := NewMessage(content, true)
 	if err != nil {
-		return false, err
+		return false, fmt.Errorf("Unable to send/recv auth: %w", err)
 	}
 
 	if msg.HasError() {
-		return false, fmt.Errorf("Login error: %s", msg.Error())
+		cmdErr := newCommandError("auth "+s.password, msg.ContentType(), msg.Headers.GetString("Reply-Text"))
+		// Freeswitch replies "-ERR invalid" for a wrong password
+		if cmdErr.Cause == nil {
+			cmdErr.Cause = ErrPermissionDenied
+		}
+		return false, cmdErr
 	}
 
 	headers := msg.Headers
 
 	answer := headers.GetString("Reply-Text")
-	loggedIn := strings.Compare("+OK accepted", answer)
+	if answer != "+OK accepted" {
+		return false, nil
+	}
 
-	s.loggedin = loggedIn == 0
+	if !s.state.CompareAndSwap(int32(StateConnected), int32(StateAuthenticated)) {
+		return false, ErrClosed
+	}
 
-	return s.loggedin, nil
+	return true, nil
 }
 
 // LoggedIn is true if a login was made successfully
 func (s *Socket) LoggedIn() bool {
-	return s.loggedin
+	return s.State() == StateAuthenticated
 }
 
 // SendCommands execute an ESL command and return number of bytes, messages or
 // an error back.
 //
+// action, cmd and args must not contain CR or LF, otherwise an ArgumentError
+// is returned. Values that arrive from users should be encoded using
+// BuildCommand.
+//
 // This function is used by all intercaces (such as API, BgAPI etc...)
-func (s Socket) SendCommands(action, cmd, args string) (int, *Message, error) {
-	n, buffer, err := s.SendRecv(fmt.Sprintf("%s %s %s", action, cmd, args))
+func (s *Socket) SendCommands(action, cmd, args string) (int, *Message, error) {
+	return s.SendCommandsContext(context.Background(), action, cmd, args)
+}
+
+// SendCommandsContext is like SendCommands, the span of the command (see
+// SetTracer) is started as a child of a span that ctx holds.
+func (s *Socket) SendCommandsContext(ctx context.Context, action, cmd, args string) (int, *Message, error) {
+	err := validateArgs(action, action, cmd, args)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return s.sendCommandContext(ctx, fmt.Sprintf("%s %s %s", action, cmd, args))
+}
+
+func (s *Socket) sendCommand(cmd string) (int, *Message, error) {
+	return s.sendCommandContext(context.Background(), cmd)
+}
+
+func (s *Socket) sendCommandContext(ctx context.Context, cmd string) (int, *Message, error) {
+	return s.roundTrip(ctx, cmd, func() error {
+		return s.Send(cmd)
+	})
+}
 
+// sendCommandBody sends a command with a body, that can hold any content
+// (including EOLs), as the body is read based on its Content-Length
+func (s *Socket) sendCommandBody(cmd, body string) (int, *Message, error) {
+	err := s.usable()
 	if err != nil {
 		return 0, nil, err
 	}
 
-	message, err := NewMessage(buffer, true)
-	return n, message, err
+	frame := fmt.Sprintf("%s%sContent-Length: %d%s%s%s", cmd, EOL, len(body), EOL, EOL, body)
+	return s.roundTrip(context.Background(), cmd, func() error {
+		return s.write(cmd, frame)
+	})
+}
+
+// roundTrip sends a command using send, and reads its reply. The socket is
+// held until the reply arrives, so concurrent commands get their own replies.
+func (s *Socket) roundTrip(ctx context.Context, cmd string, send func() error) (int, *Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	start := time.Now()
+	endSpan := s.traceCommand(ctx, cmd)
+
+	err := s.usable()
+	if err != nil {
+		s.measureCommand(cmd, start, nil, err)
+		endSpan(nil, err)
+		return 0, nil, err
+	}
+
+	// Drop a late reply of a previous command
+	select {
+	case <-s.replies:
+	default:
+	}
+
+	s.waiting.Store(true)
+	defer s.waiting.Store(false)
+
+	err = send()
+	if err != nil {
+		s.measureCommand(cmd, start, nil, err)
+		endSpan(nil, err)
+		return 0, nil, err
+	}
+
+	n, msg, err := s.readReply()
+	if msg != nil {
+		msg.command = cmd
+	}
+	s.measureCommand(cmd, start, msg, err)
+	endSpan(msg, err)
+	return n, msg, err
+}
+
+// readReply waits for the reply of a command. It reads the reply on its own,
+// unless ReadMessage reads the connection, that passes the reply to it.
+// Messages that are not replies (such as events) are kept for ReadMessage.
+func (s *Socket) readReply() (int, *Message, error) {
+	var timeout <-chan time.Time
+	if s.readTimeout > 0 {
+		timer := time.NewTimer(s.readTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case r := <-s.replies:
+			return r.n, r.msg, nil
+		case <-timeout:
+			return 0, nil, fmt.Errorf("%w: no reply after %s", ErrTimeout, s.readTimeout)
+		case s.reading <- struct{}{}:
+		}
+
+		// ReadMessage could pass the reply before it stopped reading
+		select {
+		case r := <-s.replies:
+			s.releaseReader()
+			return r.n, r.msg, nil
+		default:
+		}
+
+		n, msg, err := s.readMessageDeadline()
+		s.releaseReader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if isReply(msg) {
+			return n, msg, nil
+		}
+		s.pushPending(msg)
+	}
+}
+
+// readMessageDeadline reads a single message, using the read timeout
+func (s *Socket) readMessageDeadline() (int, *Message, error) {
+	stop, err := s.readDeadline()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer stop()
+
+	return s.readMessage()
+}
+
+// readDeadline sets the read deadline of a reply, and returns a function
+// that clears it
+func (s *Socket) readDeadline() (func(), error) {
+	if s.readTimeout <= 0 {
+		return func() {}, nil
+	}
+
+	err := s.conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { s.conn.SetReadDeadline(time.Time{}) }, nil
+}
+
+// timeoutError wraps network timeouts with ErrTimeout
+func timeoutError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
 }
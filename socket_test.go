@@ -1,10 +1,15 @@
 package esl
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -224,15 +229,14 @@ func TestAuthenticationBadCredentials(t *testing.T) {
 		return
 	}
 
-	strErr := err.Error()
-
-	if strings.HasPrefix("Unable to send/recv auth:", strErr) {
-		t.Errorf("Error sending connection: %s", strErr)
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Unexpected error: %s", err)
 		return
 	}
 
-	if strErr != "Login error: invalid" {
-		t.Errorf("Unexpected error: %s", strErr)
+	if cmdErr.Reply != "-ERR invalid" {
+		t.Errorf("Unexpected reply: %q", cmdErr.Reply)
 		return
 	}
 
@@ -286,3 +290,392 @@ func TestSocketConnectAddressError(t *testing.T) {
 	}
 
 }
+
+func TestLegacyConfigDefaultHost(t *testing.T) {
+	cfg := legacyConfig("", eslPasword, 0, time.Second)
+	if cfg.Host != DefaultHost {
+		t.Errorf("Expected %s, got: %q", DefaultHost, cfg.Host)
+	}
+
+	err := cfg.Validate()
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// Dial of an empty host connects to the local port
+	socket, err := Dial("", eslPasword, 0, 100*time.Millisecond)
+	if errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Empty host was rejected: %s", err)
+	}
+	if socket != nil {
+		socket.Close()
+	}
+}
+
+func TestSocketReadMessage(t *testing.T) {
+	body := "Event-Name: HEARTBEAT\n\n"
+	event := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "event plain HEARTBEAT" {
+			// The reply and the events arrive together
+			return fakeCommandReply("+OK event listener enabled plain") + event + event
+		}
+		return fakeCommandReply("-ERR command not found")
+	})
+
+	socket := connectFakeServer(t, server)
+
+	msg, err := socket.Events(EOTPlain, "HEARTBEAT")
+	if err != nil {
+		t.Errorf("Unable to subscribe: %s", err)
+		return
+	}
+
+	if msg.ContentType() != ECTCommandReply || msg.HasError() {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+
+	for i := 0; i < 2; i++ {
+		msg, err = socket.ReadMessage()
+		if err != nil {
+			t.Errorf("Unable to read event (%d): %s", i, err)
+			return
+		}
+
+		if msg.ContentType() != ECTEventPlain {
+			t.Errorf("Expected event (%d), got: %s", i, msg)
+			continue
+		}
+
+		ev, err := ParseEvent(msg)
+		if err != nil || ev.Headers.GetString("Event-Name") != "HEARTBEAT" {
+			t.Errorf("Unexpected event (%d): %v %v", i, ev, err)
+		}
+	}
+}
+
+func TestSocketLoginFrames(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	defer listener.Close()
+
+	event := fakeEvent("Event-Name: HEARTBEAT")
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// The auth request is split, and the replies arrive with events
+		conn.Write([]byte("Content-Type: auth/"))
+		time.Sleep(20 * time.Millisecond)
+		conn.Write([]byte("request\n\n"))
+
+		reader := bufio.NewReader(conn)
+		readFakeCommand(reader)
+		conn.Write([]byte(fakeCommandReply("+OK accepted") + event))
+		readFakeCommand(reader)
+		conn.Write([]byte(event + fakeAPIResponse("+OK")))
+		io.Copy(io.Discard, reader)
+	}()
+
+	socket, err := Dial(listener.Addr().String(), fakePassword, 0, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Unable to dial: %s", err)
+	}
+	defer socket.Close()
+
+	ok, err := socket.Login()
+	if !ok || err != nil {
+		t.Fatalf("Unable to login: %t %v", ok, err)
+	}
+
+	_, content, err := socket.SendRecv("api status")
+	if err != nil || string(content) != fakeAPIResponse("+OK") {
+		t.Errorf("Unexpected reply: %q %v", content, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		msg, err := socket.ReadMessage()
+		if err != nil || msg.ContentType() != ECTEventPlain {
+			t.Errorf("Expected event (%d), got: %v %v", i, msg, err)
+		}
+	}
+}
+
+func TestSocketReadMessageNotInitialized(t *testing.T) {
+	socket := &Socket{}
+
+	_, err := socket.ReadMessage()
+	if !errors.Is(err, ErrConnectionIsNotInitialized) {
+		t.Errorf("Expected ErrConnectionIsNotInitialized, got: %v", err)
+	}
+}
+
+func TestSocketState(t *testing.T) {
+	server := newFakeServer(t, func(string) string { return fakeCommandReply("+OK") })
+
+	socket, err := Dial(server.Addr(), fakePassword, 0, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Unable to dial: %s", err)
+	}
+
+	if socket.State() != StateConnected || socket.LoggedIn() {
+		t.Errorf("Expected connected, got %s", socket.State())
+	}
+
+	loggedIn, err := socket.Login()
+	if err != nil || !loggedIn || socket.State() != StateAuthenticated {
+		t.Errorf("Expected authenticated, got %s (%v)", socket.State(), err)
+	}
+
+	err = socket.Close()
+	if err != nil || socket.State() != StateClosed {
+		t.Errorf("Expected closed, got %s (%v)", socket.State(), err)
+	}
+
+	// Close is idempotent
+	err = socket.Close()
+	if err != nil {
+		t.Errorf("Unexpected error of a second close: %s", err)
+	}
+
+	err = socket.Send("api status")
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got: %v", err)
+	}
+
+	_, err = socket.ReadMessage()
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got: %v", err)
+	}
+
+	loggedIn, err = socket.Login()
+	if loggedIn || !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got: %t, %v", loggedIn, err)
+	}
+}
+
+func TestSocketCloseNeverConnected(t *testing.T) {
+	socket := &Socket{}
+
+	if socket.State() != StateNew {
+		t.Errorf("Expected new, got %s", socket.State())
+	}
+
+	for i := 0; i < 2; i++ {
+		err := socket.Close()
+		if err != nil {
+			t.Errorf("Unexpected error (%d): %s", i, err)
+		}
+	}
+
+	if socket.State() != StateClosed {
+		t.Errorf("Expected closed, got %s", socket.State())
+	}
+}
+
+func TestSocketCloseUnblocksRead(t *testing.T) {
+	server := newFakeServer(t, func(string) string { return "" })
+	socket := connectFakeServer(t, server)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := socket.ReadMessage()
+		result <- err
+	}()
+
+	// Let the reader block
+	time.Sleep(20 * time.Millisecond)
+
+	err := socket.Close()
+	if err != nil {
+		t.Errorf("Unable to close: %s", err)
+	}
+
+	select {
+	case err = <-result:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadMessage was not unblocked by Close")
+	}
+}
+
+func TestSocketConcurrentCommands(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "api echo ") {
+			return fakeAPIResponse(strings.TrimPrefix(cmd, "api echo "))
+		}
+		return fakeCommandReply("+OK " + cmd)
+	})
+	socket := connectFakeServer(t, server)
+
+	const workers = 8
+	const commands = 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*commands)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < commands; i++ {
+				expected := fmt.Sprintf("%d-%d", w, i)
+
+				if i%2 == 0 {
+					msg, err := socket.API("echo", expected)
+					if err != nil {
+						errs <- err
+						continue
+					}
+					if string(msg.Body) != expected {
+						errs <- fmt.Errorf("expected reply %q, got %q", expected, msg.Body)
+					}
+					continue
+				}
+
+				_, content, err := socket.SendRecv("log " + expected)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if !bytes.Contains(content, []byte("+OK log "+expected+"\n")) {
+					errs <- fmt.Errorf("expected reply of %q, got %q", expected, content)
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestSocketConcurrentClose(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeCommandReply("+OK " + cmd)
+	})
+	socket := connectFakeServer(t, server)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, _, err := socket.SendCommands("api", "status", "")
+				if err != nil && !errors.Is(err, ErrClosed) {
+					t.Errorf("Unexpected error: %s", err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			time.Sleep(5 * time.Millisecond)
+			socket.Close()
+		}()
+	}
+
+	wg.Wait()
+
+	if socket.State() != StateClosed {
+		t.Errorf("Expected closed, got %s", socket.State())
+	}
+}
+
+func TestSocketEventsAndCommands(t *testing.T) {
+	var lock sync.Mutex
+	sequence := 0
+	server := newFakeServer(t, func(cmd string) string {
+		if !strings.HasPrefix(cmd, "api echo ") {
+			return fakeCommandReply("+OK " + cmd)
+		}
+
+		lock.Lock()
+		sequence++
+		event := fakeEvent("Event-Name: CUSTOM", fmt.Sprintf("Event-Sequence: %d", sequence))
+		lock.Unlock()
+
+		// Every reply follows an event
+		return event + fakeAPIResponse(strings.TrimPrefix(cmd, "api echo "))
+	})
+	socket := connectFakeServer(t, server)
+
+	_, err := socket.Events(EOTPlain, "CUSTOM")
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err)
+	}
+
+	// Events that arrive before a reply are kept for ReadMessage
+	msg, err := socket.API("echo", "first")
+	if err != nil || string(msg.Body) != "first" {
+		t.Fatalf("Unexpected reply: %v %v", msg, err)
+	}
+
+	const workers = 4
+	const commands = 25
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 1; i <= workers*commands+1; i++ {
+			msg, err := socket.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+
+			event, err := ParseEvent(msg)
+			if err != nil {
+				done <- err
+				return
+			}
+			if event.Headers.GetString("Event-Sequence") != fmt.Sprint(i) {
+				done <- fmt.Errorf("expected event %d, got %+v", i, event.Headers)
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < commands; i++ {
+				expected := fmt.Sprintf("%d-%d", w, i)
+				msg, err := socket.API("echo", expected)
+				if err != nil {
+					t.Errorf("Unable to send command: %s", err)
+					return
+				}
+				if string(msg.Body) != expected {
+					t.Errorf("Expected reply %q, got %q (%s)", expected, msg.Body, msg.ContentType())
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Not all the events were read")
+	}
+}
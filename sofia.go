@@ -0,0 +1,528 @@
+package esl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Current file contains the support for mod_sofia status and control
+// commands, built on top of the API command.
+
+// SofiaGatewayStateEvent is the subclass of CUSTOM events that are fired when
+// a gateway changes its state
+const SofiaGatewayStateEvent = "sofia::gateway_state"
+
+// SofiaEntry is a single line of the "sofia status" (and "sofia xmlstatus")
+// output
+type SofiaEntry struct {
+	Name  string `xml:"name"`
+	Type  string `xml:"type"`
+	Data  string `xml:"data"`
+	State string `xml:"state"`
+}
+
+// Profile holds the status of a sofia SIP profile
+type Profile struct {
+	Name           string
+	DomainName     string
+	AutoNAT        bool
+	DBName         string
+	PresHosts      []string
+	Dialplan       string
+	Context        string
+	ChallengeRealm string
+	RTPIP          string
+	SIPIP          string
+	URL            string
+	BindURL        string
+	TLSURL         string
+	TLSBindURL     string
+	HoldMusic      string
+	OutboundProxy  string
+	CodecsIn       []string
+	CodecsOut      []string
+	TelEvent       int64
+	DTMFMode       string
+	CallsIn        int64
+	FailedCallsIn  int64
+	CallsOut       int64
+	FailedCallsOut int64
+	Registrations  int64
+
+	// Fields holds all the fields as returned by Freeswitch
+	Fields map[string]string
+}
+
+// Gateway holds the status of a sofia gateway.
+//
+// The Gateway is returned both by "sofia status gateway" and by
+// sofia::gateway_state events. Events contain only part of the information.
+type Gateway struct {
+	Name           string
+	Profile        string
+	Scheme         string
+	Realm          string
+	Username       string
+	Password       bool
+	From           string
+	Contact        string
+	Exten          string
+	To             string
+	Proxy          string
+	Context        string
+	Expires        int64
+	Freq           int64
+	Ping           int64
+	PingFreq       int64
+	PingState      string
+	State          string
+	Status         string
+	Uptime         time.Duration
+	CallsIn        int64
+	CallsOut       int64
+	FailedCallsIn  int64
+	FailedCallsOut int64
+
+	// StatusCode and Phrase are the SIP response that changed the state of the
+	// gateway, and provided only by events.
+	StatusCode int64
+	Phrase     string
+
+	// Fields holds all the fields as returned by Freeswitch
+	Fields map[string]string
+}
+
+// Registration holds information about a registered user at a profile
+type Registration struct {
+	CallID     string
+	User       string
+	Contact    string
+	Agent      string
+	Status     string
+	PingStatus string
+	PingTime   string
+	Host       string
+	IP         string
+	Port       int64
+	AuthUser   string
+	AuthRealm  string
+	MWIAccount string
+
+	// Fields holds all the fields as returned by Freeswitch
+	Fields map[string]string
+}
+
+// Sofia execute mod_sofia commands over a socket
+type Sofia struct {
+	socket *Socket
+}
+
+// NewSofia creates a new Sofia handle for the given socket
+func NewSofia(socket *Socket) *Sofia {
+	return &Sofia{socket: socket}
+}
+
+// Status returns the list of profiles, gateways and aliases ("sofia status")
+func (s *Sofia) Status() ([]SofiaEntry, error) {
+	body, err := s.socket.apiBody("sofia", "status")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSofiaStatus(body), nil
+}
+
+// XMLStatus returns the list of profiles, gateways and aliases using the
+// "sofia xmlstatus" command
+func (s *Sofia) XMLStatus() ([]SofiaEntry, error) {
+	body, err := s.socket.apiBody("sofia", "xmlstatus")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSofiaXMLStatus([]byte(body))
+}
+
+// ProfileStatus returns the status of a given profile
+func (s *Sofia) ProfileStatus(profile string) (*Profile, error) {
+	body, err := s.socket.apiArgsBody("sofia", RawArg("status"), RawArg("profile"), QuotedArg(profile))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(body, "Invalid Profile") {
+		return nil, fmt.Errorf("%w: %s", ErrSofiaInvalidProfile, profile)
+	}
+
+	fields := parseSofiaFields(body)
+	return newProfile(fields), nil
+}
+
+// GatewayStatus returns the status of a given gateway
+func (s *Sofia) GatewayStatus(gateway string) (*Gateway, error) {
+	body, err := s.socket.apiArgsBody("sofia", RawArg("status"), RawArg("gateway"), QuotedArg(gateway))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(body, "Invalid Gateway") {
+		return nil, fmt.Errorf("%w: %s", ErrSofiaInvalidGateway, gateway)
+	}
+
+	fields := parseSofiaFields(body)
+	return newGateway(fields), nil
+}
+
+// Registrations returns the list of registrations of a given profile
+func (s *Sofia) Registrations(profile string) ([]Registration, error) {
+	body, err := s.socket.apiArgsBody("sofia", RawArg("status"), RawArg("profile"), QuotedArg(profile), RawArg("reg"))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(body, "Invalid Profile") {
+		return nil, fmt.Errorf("%w: %s", ErrSofiaInvalidProfile, profile)
+	}
+
+	return parseSofiaRegistrations(body), nil
+}
+
+// StartProfile starts a given profile
+func (s *Sofia) StartProfile(profile string) error {
+	return s.profileCommand(profile, RawArg("start"))
+}
+
+// StopProfile stops a given profile
+func (s *Sofia) StopProfile(profile string) error {
+	return s.profileCommand(profile, RawArg("stop"))
+}
+
+// RestartProfile restarts a given profile
+func (s *Sofia) RestartProfile(profile string) error {
+	return s.profileCommand(profile, RawArg("restart"))
+}
+
+// RescanProfile rescans the XML of a profile, and loads new gateways
+func (s *Sofia) RescanProfile(profile string) error {
+	return s.profileCommand(profile, RawArg("rescan"))
+}
+
+// KillGateway removes a gateway from a profile
+func (s *Sofia) KillGateway(profile, gateway string) error {
+	return s.profileCommand(profile, RawArg("killgw"), QuotedArg(gateway))
+}
+
+// FlushInboundReg flushes inbound registrations of a profile.
+// target is optional, and can be a call id or user@host. If reboot is true,
+// the registered devices are also asked to reboot.
+func (s *Sofia) FlushInboundReg(profile, target string, reboot bool) error {
+	args := []Arg{RawArg("flush_inbound_reg")}
+	if target != "" {
+		args = append(args, QuotedArg(target))
+	}
+	if reboot {
+		args = append(args, RawArg("reboot"))
+	}
+	return s.profileCommand(profile, args...)
+}
+
+// profileCommand runs "sofia profile <profile>" with the given arguments
+func (s *Sofia) profileCommand(profile string, args ...Arg) error {
+	body, err := s.socket.apiArgsBody("sofia", append([]Arg{RawArg("profile"), QuotedArg(profile)}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(body, "Invalid Profile") {
+		return fmt.Errorf("%w: %s", ErrSofiaInvalidProfile, profile)
+	}
+
+	return nil
+}
+
+// GatewayFromEvent decodes sofia::gateway_state CUSTOM event into a Gateway.
+func GatewayFromEvent(msg *Message) (*Gateway, error) {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = isCustomEvent(headers, SofiaGatewayStateEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	gateway := Gateway{
+		Name:       headers.GetString("Gateway"),
+		State:      headers.GetString("State"),
+		Status:     headers.GetString("Ping-Status"),
+		StatusCode: headers.GetInt("Status"),
+		Phrase:     headers.GetString("Phrase"),
+		Fields:     make(map[string]string),
+	}
+
+	for _, key := range headers.Keys() {
+		gateway.Fields[key] = headers.GetString(key)
+	}
+
+	return &gateway, nil
+}
+
+// isSofiaSeparator returns true if the line is the "=====" line that wraps
+// sofia output
+func isSofiaSeparator(line string) bool {
+	return strings.HasPrefix(line, "===")
+}
+
+// parseSofiaStatus parse the table of "sofia status"
+func parseSofiaStatus(body string) []SofiaEntry {
+	var entries []SofiaEntry
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	inTable := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isSofiaSeparator(line) {
+			inTable = !inTable
+			continue
+		}
+
+		if !inTable {
+			continue
+		}
+
+		columns := strings.Split(line, "\t")
+		if len(columns) < 4 {
+			continue
+		}
+
+		entries = append(entries, SofiaEntry{
+			Name:  strings.TrimSpace(columns[0]),
+			Type:  strings.TrimSpace(columns[1]),
+			Data:  strings.TrimSpace(columns[2]),
+			State: strings.TrimSpace(strings.Join(columns[3:], "\t")),
+		})
+	}
+
+	return entries
+}
+
+// parseSofiaXMLStatus parse the output of "sofia xmlstatus"
+func parseSofiaXMLStatus(body []byte) ([]SofiaEntry, error) {
+	var entries []SofiaEntry
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "profile", "gateway", "alias":
+			var entry SofiaEntry
+			err = decoder.DecodeElement(&entry, &start)
+			if err != nil {
+				return nil, err
+			}
+			if entry.Type == "" {
+				entry.Type = start.Name.Local
+			}
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// parseSofiaFields parse a "key<spaces>\tvalue" list of a single item
+func parseSofiaFields(body string) map[string]string {
+	fields := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isSofiaSeparator(line) {
+			continue
+		}
+
+		key, value, found := splitSofiaField(line)
+		if !found {
+			continue
+		}
+
+		if _, exists := fields[key]; exists {
+			continue
+		}
+		fields[key] = value
+	}
+
+	return fields
+}
+
+// parseSofiaRegistrations parse a list of registrations that are separated
+// by an empty line
+func parseSofiaRegistrations(body string) []Registration {
+	var registrations []Registration
+
+	fields := make(map[string]string)
+	flush := func() {
+		if len(fields) == 0 {
+			return
+		}
+		registrations = append(registrations, newRegistration(fields))
+		fields = make(map[string]string)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isSofiaSeparator(line) || strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+
+		key, value, found := splitSofiaField(line)
+		if !found {
+			continue
+		}
+		fields[strings.TrimSuffix(key, ":")] = value
+	}
+	flush()
+
+	return registrations
+}
+
+func splitSofiaField(line string) (string, string, bool) {
+	idx := strings.Index(line, "\t")
+	if idx <= 0 {
+		return "", "", false
+	}
+
+	key := strings.TrimSpace(line[:idx])
+	value := strings.TrimSpace(line[idx+1:])
+	return key, value, key != ""
+}
+
+func newProfile(fields map[string]string) *Profile {
+	return &Profile{
+		Name:           fields["Name"],
+		DomainName:     fields["Domain Name"],
+		AutoNAT:        fields["Auto-NAT"] == "true",
+		DBName:         fields["DB Name"],
+		PresHosts:      splitList(fields["Pres Hosts"]),
+		Dialplan:       fields["Dialplan"],
+		Context:        fields["Context"],
+		ChallengeRealm: fields["Challenge Realm"],
+		RTPIP:          fields["RTP-IP"],
+		SIPIP:          fields["SIP-IP"],
+		URL:            fields["URL"],
+		BindURL:        fields["BIND-URL"],
+		TLSURL:         fields["TLS-URL"],
+		TLSBindURL:     fields["TLS-BIND-URL"],
+		HoldMusic:      fields["HOLD-MUSIC"],
+		OutboundProxy:  fields["OUTBOUND-PROXY"],
+		CodecsIn:       splitList(fields["CODECS IN"]),
+		CodecsOut:      splitList(fields["CODECS OUT"]),
+		TelEvent:       parseInt(fields["TEL-EVENT"]),
+		DTMFMode:       fields["DTMF-MODE"],
+		CallsIn:        parseInt(fields["CALLS-IN"]),
+		FailedCallsIn:  parseInt(fields["FAILED-CALLS-IN"]),
+		CallsOut:       parseInt(fields["CALLS-OUT"]),
+		FailedCallsOut: parseInt(fields["FAILED-CALLS-OUT"]),
+		Registrations:  parseInt(fields["REGISTRATIONS"]),
+		Fields:         fields,
+	}
+}
+
+func newGateway(fields map[string]string) *Gateway {
+	uptime, _ := time.ParseDuration(fields["Uptime"])
+
+	return &Gateway{
+		Name:           fields["Name"],
+		Profile:        fields["Profile"],
+		Scheme:         fields["Scheme"],
+		Realm:          fields["Realm"],
+		Username:       fields["Username"],
+		Password:       fields["Password"] == "yes",
+		From:           fields["From"],
+		Contact:        fields["Contact"],
+		Exten:          fields["Exten"],
+		To:             fields["To"],
+		Proxy:          fields["Proxy"],
+		Context:        fields["Context"],
+		Expires:        parseInt(fields["Expires"]),
+		Freq:           parseInt(fields["Freq"]),
+		Ping:           parseInt(fields["Ping"]),
+		PingFreq:       parseInt(fields["PingFreq"]),
+		PingState:      fields["PingState"],
+		State:          fields["State"],
+		Status:         fields["Status"],
+		Uptime:         uptime,
+		CallsIn:        parseInt(fields["CallsIN"]),
+		CallsOut:       parseInt(fields["CallsOUT"]),
+		FailedCallsIn:  parseInt(fields["FailedCallsIN"]),
+		FailedCallsOut: parseInt(fields["FailedCallsOUT"]),
+		Fields:         fields,
+	}
+}
+
+func newRegistration(fields map[string]string) Registration {
+	return Registration{
+		CallID:     fields["Call-ID"],
+		User:       fields["User"],
+		Contact:    fields["Contact"],
+		Agent:      fields["Agent"],
+		Status:     fields["Status"],
+		PingStatus: fields["Ping-Status"],
+		PingTime:   fields["Ping-Time"],
+		Host:       fields["Host"],
+		IP:         fields["IP"],
+		Port:       parseInt(fields["Port"]),
+		AuthUser:   fields["Auth-User"],
+		AuthRealm:  fields["Auth-Realm"],
+		MWIAccount: fields["MWI-Account"],
+		Fields:     fields,
+	}
+}
+
+// parseInt returns the int value of s, or 0 if s is not a number
+func parseInt(s string) int64 {
+	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// splitList splits a comma separated list
+func splitList(s string) []string {
+	if s == "" || s == "N/A" {
+		return nil
+	}
+
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,290 @@
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sofiaStatusFixture = `                     Name	   Type	                                       Data	State
+=================================================================================================
+            external::example.com	gateway	                    sip:joeuser@example.com	NOREG
+                         external	profile	             sip:mod_sofia@10.0.0.5:5080	RUNNING (0)
+                         internal	profile	             sip:mod_sofia@10.0.0.5:5060	RUNNING (0)
+                         10.0.0.5	  alias	                                   internal	ALIASED
+=================================================================================================
+2 profiles 1 alias
+`
+
+const sofiaXMLStatusFixture = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<profiles>
+  <profile>
+    <name>external</name>
+    <type>profile</type>
+    <data>sip:mod_sofia@10.0.0.5:5080</data>
+    <state>RUNNING (0)</state>
+  </profile>
+  <gateway>
+    <name>external::example.com</name>
+    <type>gateway</type>
+    <data>sip:joeuser@example.com</data>
+    <state>NOREG</state>
+  </gateway>
+</profiles>
+`
+
+const sofiaProfileFixture = `=================================================================================================
+Name             	internal
+Domain Name      	N/A
+Auto-NAT         	false
+DB Name          	sofia_reg_internal
+Pres Hosts       	10.0.0.5,10.0.0.5
+Dialplan         	XML
+Context          	public
+URL              	sip:mod_sofia@10.0.0.5:5060
+CODECS IN        	OPUS,G722,PCMU,PCMA
+TEL-EVENT        	101
+CALLS-IN         	7
+FAILED-CALLS-IN  	1
+REGISTRATIONS    	2
+=================================================================================================
+`
+
+const sofiaGatewayFixture = `=================================================================================================
+Name    	example.com
+Profile 	external
+Scheme  	Digest
+Realm   	example.com
+Username	joeuser
+Password	yes
+Expires 	3600
+PingState	0/0/0
+State   	REGED
+Status  	UP
+Uptime  	3553s
+CallsIN 	4
+CallsOUT	5
+=================================================================================================
+`
+
+const sofiaRegistrationsFixture = `Registrations:
+=================================================================================================
+Call-ID:    	abc@10.0.0.10
+User:       	1000@10.0.0.5
+Contact:    	"1000" <sip:1000@10.0.0.10:5060>
+Agent:      	Zoiper
+Status:     	Registered(UDP)(unknown) EXP(2020-10-10 10:10:10) EXPSECS(3595)
+Ping-Status:	Reachable
+IP:         	10.0.0.10
+Port:       	5060
+
+Call-ID:    	def@10.0.0.11
+User:       	1001@10.0.0.5
+IP:         	10.0.0.11
+Port:       	5062
+
+Total items returned: 2
+=================================================================================================
+`
+
+func TestParseSofiaStatus(t *testing.T) {
+	entries := parseSofiaStatus(sofiaStatusFixture)
+	if len(entries) != 4 {
+		t.Errorf("Expected 4 entries, got %d: %+v", len(entries), entries)
+		return
+	}
+
+	expected := SofiaEntry{
+		Name:  "internal",
+		Type:  "profile",
+		Data:  "sip:mod_sofia@10.0.0.5:5060",
+		State: "RUNNING (0)",
+	}
+	if entries[2] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, entries[2])
+	}
+
+	if entries[3].Type != "alias" {
+		t.Errorf("Expected alias, got %+v", entries[3])
+	}
+}
+
+func TestParseSofiaXMLStatus(t *testing.T) {
+	entries, err := parseSofiaXMLStatus([]byte(sofiaXMLStatusFixture))
+	if err != nil {
+		t.Errorf("Unable to parse xml: %s", err)
+		return
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %d: %+v", len(entries), entries)
+		return
+	}
+
+	if entries[1].Name != "external::example.com" || entries[1].State != "NOREG" {
+		t.Errorf("Unexpected gateway entry: %+v", entries[1])
+	}
+}
+
+func TestParseSofiaProfile(t *testing.T) {
+	profile := newProfile(parseSofiaFields(sofiaProfileFixture))
+
+	if profile.Name != "internal" {
+		t.Errorf("Expected name of internal, got '%s'", profile.Name)
+	}
+
+	if profile.AutoNAT {
+		t.Errorf("Expected Auto-NAT to be false")
+	}
+
+	if len(profile.CodecsIn) != 4 || profile.CodecsIn[0] != "OPUS" {
+		t.Errorf("Unexpected codecs: %v", profile.CodecsIn)
+	}
+
+	if profile.CallsIn != 7 || profile.FailedCallsIn != 1 || profile.Registrations != 2 {
+		t.Errorf("Unexpected counters: %+v", profile)
+	}
+
+	if profile.Fields["Domain Name"] != "N/A" {
+		t.Errorf("Expected raw field of 'Domain Name', got: %v", profile.Fields)
+	}
+}
+
+func TestParseSofiaGateway(t *testing.T) {
+	gateway := newGateway(parseSofiaFields(sofiaGatewayFixture))
+
+	if gateway.Name != "example.com" || gateway.Profile != "external" {
+		t.Errorf("Unexpected gateway: %+v", gateway)
+	}
+
+	if !gateway.Password {
+		t.Errorf("Expected Password to be true")
+	}
+
+	if gateway.Uptime != 3553*time.Second {
+		t.Errorf("Expected uptime of 3553s, got %s", gateway.Uptime)
+	}
+
+	if gateway.CallsIn != 4 || gateway.CallsOut != 5 {
+		t.Errorf("Unexpected counters: %+v", gateway)
+	}
+}
+
+func TestParseSofiaRegistrations(t *testing.T) {
+	registrations := parseSofiaRegistrations(sofiaRegistrationsFixture)
+
+	if len(registrations) != 2 {
+		t.Errorf("Expected 2 registrations, got %d: %+v", len(registrations), registrations)
+		return
+	}
+
+	if registrations[0].User != "1000@10.0.0.5" || registrations[0].Agent != "Zoiper" {
+		t.Errorf("Unexpected registration: %+v", registrations[0])
+	}
+
+	if registrations[1].Port != 5062 {
+		t.Errorf("Expected port 5062, got %d", registrations[1].Port)
+	}
+}
+
+func TestSofiaCommands(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		switch cmd {
+		case "api sofia status":
+			return fakeAPIResponse(sofiaStatusFixture)
+		case "api sofia status profile internal":
+			return fakeAPIResponse(sofiaProfileFixture)
+		case "api sofia status profile missing":
+			return fakeAPIResponse("Invalid Profile!\n")
+		case "api sofia status gateway example.com":
+			return fakeAPIResponse(sofiaGatewayFixture)
+		case "api sofia profile internal killgw example.com":
+			return fakeAPIResponse("+OK gateway marked for deletion.\n")
+		case "api sofia profile internal restart":
+			return fakeAPIResponse("-ERR restart failed\n")
+		}
+		return fakeAPIResponse("-ERR command not found\n")
+	})
+
+	socket := connectFakeServer(t, server)
+	sofia := NewSofia(socket)
+
+	entries, err := sofia.Status()
+	if err != nil || len(entries) != 4 {
+		t.Errorf("Unexpected status: %+v, %v", entries, err)
+	}
+
+	profile, err := sofia.ProfileStatus("internal")
+	if err != nil || profile.Name != "internal" {
+		t.Errorf("Unexpected profile: %+v, %v", profile, err)
+	}
+
+	_, err = sofia.ProfileStatus("missing")
+	if !errors.Is(err, ErrSofiaInvalidProfile) {
+		t.Errorf("Expected ErrSofiaInvalidProfile, got: %v", err)
+	}
+
+	gateway, err := sofia.GatewayStatus("example.com")
+	if err != nil || gateway.State != "REGED" {
+		t.Errorf("Unexpected gateway: %+v, %v", gateway, err)
+	}
+
+	err = sofia.KillGateway("internal", "example.com")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	err = sofia.RestartProfile("internal")
+	if err == nil || !strings.Contains(err.Error(), "restart failed") {
+		t.Errorf("Expected restart error, got: %v", err)
+	}
+
+	// A value with spaces is a single argument
+	sofia.FlushInboundReg("internal", "1000@example.com reboot", false)
+	commands := server.Commands()
+	if last := commands[len(commands)-1]; last != "api sofia profile internal flush_inbound_reg '1000@example.com reboot'" {
+		t.Errorf("Unexpected command: %s", last)
+	}
+}
+
+func TestGatewayFromEvent(t *testing.T) {
+	body := "Event-Name: CUSTOM\nEvent-Subclass: sofia%3A%3Agateway_state\n" +
+		"Gateway: example.com\nState: FAIL_WAIT\nPing-Status: DOWN\n" +
+		"Status: 503\nPhrase: Service%20Unavailable\n\n"
+	input := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+
+	msg, err := NewMessage([]byte(input), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	gateway, err := GatewayFromEvent(msg)
+	if err != nil {
+		t.Errorf("Unable to decode event: %s", err)
+		return
+	}
+
+	if gateway.Name != "example.com" || gateway.State != "FAIL_WAIT" || gateway.Status != "DOWN" {
+		t.Errorf("Unexpected gateway: %+v", gateway)
+	}
+
+	if gateway.StatusCode != 503 || gateway.Phrase != "Service Unavailable" {
+		t.Errorf("Unexpected status: %d %s", gateway.StatusCode, gateway.Phrase)
+	}
+}
+
+func TestGatewayFromEventWrongSubclass(t *testing.T) {
+	msg, err := NewMessage([]byte("Event-Name: HEARTBEAT\n"), true)
+	if err != nil {
+		t.Errorf("Unable to parse message: %s", err)
+		return
+	}
+
+	_, err = GatewayFromEvent(msg)
+	if !errors.Is(err, ErrUnexpectedEvent) {
+		t.Errorf("Expected ErrUnexpectedEvent, got: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package esl
+
+import (
+	"sync"
+)
+
+// Current file contains the strategies that spread new calls between the
+// nodes of a Cluster.
+
+// Strategy picks the node of a new call
+type Strategy interface {
+	// Pick returns one of the given nodes, that are all connected
+	Pick(nodes []*Node) (*Node, error)
+}
+
+// StrategyFunc is a function that implements Strategy
+type StrategyFunc func(nodes []*Node) (*Node, error)
+
+// Pick implements Strategy
+func (f StrategyFunc) Pick(nodes []*Node) (*Node, error) {
+	return f(nodes)
+}
+
+// roundRobin picks the nodes one after the other
+type roundRobin struct {
+	lock sync.Mutex
+	next int
+}
+
+// RoundRobin returns a Strategy that picks the nodes one after the other
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+func (r *roundRobin) Pick(nodes []*Node) (*Node, error) {
+	if len(nodes) == 0 {
+		return nil, ErrNoNodeAvailable
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	node := nodes[r.next%len(nodes)]
+	r.next++
+	return node, nil
+}
+
+// leastSessions picks the node with the least sessions
+type leastSessions struct{}
+
+// LeastSessions returns a Strategy that sends "api status" to every node, and
+// picks the ready node with the least sessions. Nodes that failed to reply,
+// or that reached their max sessions, are skipped.
+func LeastSessions() Strategy {
+	return leastSessions{}
+}
+
+func (leastSessions) Pick(nodes []*Node) (*Node, error) {
+	statuses := make([]Heartbeat, len(nodes))
+	errs := make([]error, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *Node) {
+			defer wg.Done()
+			statuses[i], errs[i] = node.client.Status()
+		}(i, node)
+	}
+	wg.Wait()
+
+	var best *Node
+	var bestSessions int64
+	for i, node := range nodes {
+		status := statuses[i]
+		if errs[i] != nil || !status.Ready {
+			continue
+		}
+		if status.MaxSessions > 0 && status.Sessions >= status.MaxSessions {
+			continue
+		}
+		if best == nil || status.Sessions < bestSessions {
+			best = node
+			bestSessions = status.Sessions
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNoNodeAvailable
+	}
+	return best, nil
+}
+
+// weighted picks the nodes by their weights, using smooth weighted round
+// robin
+type weighted struct {
+	weights map[string]int
+
+	lock    sync.Mutex
+	current map[string]int
+}
+
+// Weighted returns a Strategy that picks the nodes in proportion to their
+// weights, by the node name. A node without a weight has a weight of 1, and
+// a node with a weight of 0 (or less) is never picked.
+//
+// The picks are spread, e.g. weights of 2 and 1 pick a, b, a, and not a, a, b.
+func Weighted(weights map[string]int) Strategy {
+	copied := make(map[string]int, len(weights))
+	for name, weight := range weights {
+		copied[name] = weight
+	}
+
+	return &weighted{
+		weights: copied,
+		current: make(map[string]int),
+	}
+}
+
+// weight returns the weight of a node
+func (w *weighted) weight(node *Node) int {
+	weight, found := w.weights[node.Name]
+	if !found {
+		return 1
+	}
+	return weight
+}
+
+func (w *weighted) Pick(nodes []*Node) (*Node, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var best *Node
+	total := 0
+	for _, node := range nodes {
+		weight := w.weight(node)
+		if weight <= 0 {
+			continue
+		}
+
+		total += weight
+		w.current[node.Name] += weight
+		if best == nil || w.current[node.Name] > w.current[best.Name] {
+			best = node
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNoNodeAvailable
+	}
+
+	w.current[best.Name] -= total
+	return best, nil
+}
@@ -0,0 +1,89 @@
+package esl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// pickNames picks count nodes and returns their names
+func pickNames(t *testing.T, strategy Strategy, nodes []*Node, count int) string {
+	t.Helper()
+
+	var names []string
+	for i := 0; i < count; i++ {
+		node, err := strategy.Pick(nodes)
+		if err != nil {
+			t.Fatalf("Unable to pick: %s", err)
+		}
+		names = append(names, node.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestRoundRobin(t *testing.T) {
+	nodes := []*Node{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+
+	names := pickNames(t, RoundRobin(), nodes, 5)
+	if names != "a b c a b" {
+		t.Errorf("Unexpected picks: %s", names)
+	}
+
+	_, err := RoundRobin().Pick(nil)
+	if !errors.Is(err, ErrNoNodeAvailable) {
+		t.Errorf("Expected ErrNoNodeAvailable, got: %v", err)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	nodes := []*Node{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+
+	strategy := Weighted(map[string]int{"a": 3, "b": 1, "d": 0})
+	names := pickNames(t, strategy, nodes, 10)
+	if names != "a b a c a a b a c a" {
+		t.Errorf("Unexpected picks: %s", names)
+	}
+
+	_, err := Weighted(map[string]int{"a": 0}).Pick(nodes[:1])
+	if !errors.Is(err, ErrNoNodeAvailable) {
+		t.Errorf("Expected ErrNoNodeAvailable, got: %v", err)
+	}
+}
+
+func TestLeastSessions(t *testing.T) {
+	statuses := map[string]string{
+		"busy":   strings.Replace(testStatus, "3 session(s) - peak", "20 session(s) - peak", 1),
+		"idle":   strings.Replace(testStatus, "3 session(s) - peak", "1 session(s) - peak", 1),
+		"full":   strings.Replace(testStatus, "3 session(s) - peak", "1000 session(s) - peak", 1),
+		"paused": strings.Replace(testStatus, "is ready", "is not ready", 1),
+	}
+
+	var nodes []*Node
+	for _, name := range []string{"busy", "full", "idle", "paused"} {
+		status := statuses[name]
+		server := newFakeServer(t, func(cmd string) string {
+			if cmd == "api status" {
+				return fakeAPIResponse(status)
+			}
+			return fakeCommandReply("+OK")
+		})
+
+		client, err := NewClient(NewConfig(server.Addr(), fakePassword))
+		if err != nil {
+			t.Fatalf("Unable to create client: %s", err)
+		}
+		defer client.Close()
+
+		nodes = append(nodes, &Node{Name: name, client: client})
+	}
+
+	names := pickNames(t, LeastSessions(), nodes, 2)
+	if names != "idle idle" {
+		t.Errorf("Unexpected picks: %s", names)
+	}
+
+	_, err := LeastSessions().Pick([]*Node{nodes[1], nodes[3]})
+	if !errors.Is(err, ErrNoNodeAvailable) {
+		t.Errorf("Expected ErrNoNodeAvailable, got: %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+package esl
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Current file contains a minimal tracing abstraction, that follows the
+// OpenTelemetry model (tracer, spans, attributes and span events), without
+// depending on it. An adapter for OpenTelemetry (or any other tracing system)
+// only needs to implement Tracer and Span.
+
+// Span attributes that are set by the package
+const (
+	AttrCommand       = "esl.command"
+	AttrVerb          = "esl.verb"
+	AttrContentType   = "esl.content_type"
+	AttrReply         = "esl.reply"
+	AttrUniqueID      = "esl.unique_id"
+	AttrEventName     = "esl.event_name"
+	AttrCallDirection = "esl.call.direction"
+	AttrCallerNumber  = "esl.call.caller_number"
+	AttrDestination   = "esl.call.destination_number"
+	AttrHangupCause   = "esl.call.hangup_cause"
+	AttrChannelState  = "esl.channel_state"
+)
+
+// maxReplyAttribute is the max length of a reply that is kept as attribute
+const maxReplyAttribute = 256
+
+// Attribute is a key/value of a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Attr creates a new Attribute
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer creates spans
+type Tracer interface {
+	// Start a new span, as a child of a span that ctx holds (if any).
+	// The returned context holds the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	AddEvent(name string, attrs ...Attribute)
+	SetError(err error)
+	End()
+}
+
+// SetTracer creates a span for every command round trip of the socket, or
+// nil to stop tracing. Commands that are sent with a context (such as
+// APIContext) are traced as children of the span that the context holds.
+func (s *Socket) SetTracer(tracer Tracer) {
+	s.tracer.Store(&tracer)
+}
+
+// loadTracer returns the tracer of the socket, or nil
+func (s *Socket) loadTracer() Tracer {
+	tracer := s.tracer.Load()
+	if tracer == nil {
+		return nil
+	}
+	return *tracer
+}
+
+// traceCommand starts a span for a command, the returned function ends it
+func (s *Socket) traceCommand(ctx context.Context, cmd string) func(msg *Message, err error) {
+	tracer := s.loadTracer()
+	if tracer == nil {
+		return func(*Message, error) {}
+	}
+
+	verb := commandVerb(cmd)
+	line := strings.SplitN(redactCommand(cmd), EOL, 2)[0]
+
+	_, span := tracer.Start(ctx, "esl "+verb,
+		Attr(AttrVerb, verb),
+		Attr(AttrCommand, line),
+	)
+
+	return func(msg *Message, err error) {
+		defer span.End()
+
+		if err != nil {
+			span.SetError(err)
+			return
+		}
+
+		reply := msg.Headers.GetString("Reply-Text")
+		if reply == "" {
+			reply = string(msg.Body)
+		}
+		if len(reply) > maxReplyAttribute {
+			// The cut can split the last rune
+			reply = strings.ToValidUTF8(reply[:maxReplyAttribute], "")
+		}
+
+		span.SetAttributes(
+			Attr(AttrContentType, string(msg.ContentType())),
+			Attr(AttrReply, reply),
+		)
+
+		if msg.HasError() {
+			span.SetError(msg.Error())
+		}
+	}
+}
+
+// CallTracer creates a span for every call (by its Unique-ID), that starts at
+// CHANNEL_CREATE and ends at CHANNEL_HANGUP_COMPLETE, or at CHANNEL_DESTROY if
+// the former was not seen. Every event of the call is added as a span event.
+//
+// Spans of calls that their end was never seen (e.g. the events connection
+// was lost) are kept until EndAll is called.
+type CallTracer struct {
+	tracer Tracer
+
+	lock  sync.Mutex
+	calls map[string]Span
+}
+
+// NewCallTracer creates a new CallTracer
+func NewCallTracer(tracer Tracer) *CallTracer {
+	return &CallTracer{
+		tracer: tracer,
+		calls:  make(map[string]Span),
+	}
+}
+
+// HandleEvent adds an event to the trace of its call. Events without
+// Unique-ID, or of calls that their CHANNEL_CREATE was not seen, are ignored.
+func (c *CallTracer) HandleEvent(msg *Message) error {
+	return c.HandleEventContext(context.Background(), msg)
+}
+
+// HandleEventContext is like HandleEvent, a call span that is started by the
+// event is a child of the span that ctx holds.
+func (c *CallTracer) HandleEventContext(ctx context.Context, msg *Message) error {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return err
+	}
+
+	uuid := headers.GetString("Unique-ID")
+	name := headers.GetString("Event-Name")
+	if uuid == "" {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	span, found := c.calls[uuid]
+	if !found {
+		if name != "CHANNEL_CREATE" {
+			return nil
+		}
+
+		_, span = c.tracer.Start(ctx, "call",
+			Attr(AttrUniqueID, uuid),
+			Attr(AttrCallDirection, headers.GetString("Call-Direction")),
+			Attr(AttrCallerNumber, headers.GetString("Caller-Caller-ID-Number")),
+			Attr(AttrDestination, headers.GetString("Caller-Destination-Number")),
+		)
+		c.calls[uuid] = span
+	}
+
+	attrs := []Attribute{Attr(AttrEventName, name)}
+	if state := headers.GetString("Channel-State"); state != "" {
+		attrs = append(attrs, Attr(AttrChannelState, state))
+	}
+	span.AddEvent(name, attrs...)
+
+	switch name {
+	case "CHANNEL_HANGUP_COMPLETE", "CHANNEL_DESTROY":
+		if cause := headers.GetString("Hangup-Cause"); cause != "" {
+			span.SetAttributes(Attr(AttrHangupCause, cause))
+		}
+		span.End()
+		delete(c.calls, uuid)
+	}
+
+	return nil
+}
+
+// EndAll ends the spans of all the traced calls, and returns their number.
+// Use it when the events of the calls can no longer arrive, such as after
+// the events connection was lost, or on shutdown.
+func (c *CallTracer) EndAll() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ended := len(c.calls)
+	for uuid, span := range c.calls {
+		span.End()
+		delete(c.calls, uuid)
+	}
+	return ended
+}
+
+// Active returns the number of calls that are traced
+func (c *CallTracer) Active() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.calls)
+}
+
+// SpanEvent is an event that was added to a span
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{}
+}
+
+// SpanData holds a span that was recorded by InMemoryTracer
+type SpanData struct {
+	ID         uint64
+	ParentID   uint64
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Events     []SpanEvent
+	Err        error
+}
+
+// InMemoryTracer is a Tracer that keeps ended spans in memory, it is useful
+// for tests and for debugging.
+type InMemoryTracer struct {
+	lock   sync.Mutex
+	lastID uint64
+	spans  []SpanData
+}
+
+// NewInMemoryTracer creates a new InMemoryTracer
+func NewInMemoryTracer() *InMemoryTracer {
+	return &InMemoryTracer{}
+}
+
+type inMemorySpanKey struct{}
+
+// Start implements Tracer
+func (t *InMemoryTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	t.lock.Lock()
+	t.lastID++
+	id := t.lastID
+	t.lock.Unlock()
+
+	span := &inMemorySpan{
+		tracer: t,
+		data: SpanData{
+			ID:         id,
+			Name:       name,
+			Start:      time.Now(),
+			Attributes: make(map[string]interface{}),
+		},
+	}
+
+	if parent, ok := ctx.Value(inMemorySpanKey{}).(*inMemorySpan); ok {
+		span.data.ParentID = parent.data.ID
+	}
+
+	span.SetAttributes(attrs...)
+
+	return context.WithValue(ctx, inMemorySpanKey{}, span), span
+}
+
+// Spans returns the spans that ended
+func (t *InMemoryTracer) Spans() []SpanData {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return append([]SpanData(nil), t.spans...)
+}
+
+// Reset removes all the recorded spans
+func (t *InMemoryTracer) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.spans = nil
+}
+
+type inMemorySpan struct {
+	tracer *InMemoryTracer
+	lock   sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *inMemorySpan) SetAttributes(attrs ...Attribute) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, attr := range attrs {
+		s.data.Attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *inMemorySpan) AddEvent(name string, attrs ...Attribute) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	event := SpanEvent{
+		Name:       name,
+		Time:       time.Now(),
+		Attributes: make(map[string]interface{}, len(attrs)),
+	}
+	for _, attr := range attrs {
+		event.Attributes[attr.Key] = attr.Value
+	}
+
+	s.data.Events = append(s.data.Events, event)
+}
+
+func (s *inMemorySpan) SetError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Err = err
+}
+
+func (s *inMemorySpan) End() {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.lock.Unlock()
+
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+
+	s.tracer.spans = append(s.tracer.spans, data)
+}
@@ -0,0 +1,230 @@
+package esl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTraceCommands(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "api status" {
+			return fakeAPIResponse("UP 0 years")
+		}
+		return fakeAPIResponse("-ERR command not found\n")
+	})
+
+	socket := connectFakeServer(t, server)
+	tracer := NewInMemoryTracer()
+	socket.SetTracer(tracer)
+
+	socket.API("status", "")
+	socket.API("foo", "bar")
+
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Errorf("Expected 2 spans, got %d: %+v", len(spans), spans)
+		return
+	}
+
+	ok := spans[0]
+	if ok.Name != "esl api" || ok.Attributes[AttrVerb] != "api" || ok.Err != nil {
+		t.Errorf("Unexpected span: %+v", ok)
+	}
+
+	if ok.Attributes[AttrReply] != "UP 0 years" || ok.Attributes[AttrContentType] != string(ECTAPIResponse) {
+		t.Errorf("Unexpected attributes: %+v", ok.Attributes)
+	}
+
+	failed := spans[1]
+	if failed.Attributes[AttrCommand] != "api foo bar" || failed.Err == nil {
+		t.Errorf("Expected failed span: %+v", failed)
+	}
+
+	if ok.End.Before(ok.Start) {
+		t.Errorf("Span ended before it started: %+v", ok)
+	}
+}
+
+func TestCallTracer(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	calls := NewCallTracer(tracer)
+
+	events := []*Message{
+		fakeEventMessage(t, "Event-Name: CHANNEL_ANSWER", "Unique-ID: unknown"),
+		fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: call-1", "Call-Direction: inbound", "Caller-Caller-ID-Number: 1000"),
+		fakeEventMessage(t, "Event-Name: CHANNEL_ANSWER", "Unique-ID: call-1", "Channel-State: CS_EXECUTE"),
+		fakeEventMessage(t, "Event-Name: CHANNEL_HANGUP", "Unique-ID: call-1"),
+	}
+
+	for idx, event := range events {
+		err := calls.HandleEvent(event)
+		if err != nil {
+			t.Errorf("Unable to handle event (%d): %s", idx, err)
+		}
+	}
+
+	if calls.Active() != 1 {
+		t.Errorf("Expected a single active call, got %d", calls.Active())
+	}
+
+	if len(tracer.Spans()) != 0 {
+		t.Errorf("No span should end before CHANNEL_HANGUP_COMPLETE")
+	}
+
+	err := calls.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_HANGUP_COMPLETE", "Unique-ID: call-1", "Hangup-Cause: NORMAL_CLEARING"))
+	if err != nil {
+		t.Errorf("Unable to handle event: %s", err)
+	}
+
+	spans := tracer.Spans()
+	if len(spans) != 1 || calls.Active() != 0 {
+		t.Errorf("Expected a single ended span, got %d (active: %d)", len(spans), calls.Active())
+		return
+	}
+
+	span := spans[0]
+	if span.Attributes[AttrUniqueID] != "call-1" || span.Attributes[AttrCallerNumber] != "1000" {
+		t.Errorf("Unexpected attributes: %+v", span.Attributes)
+	}
+
+	if span.Attributes[AttrHangupCause] != "NORMAL_CLEARING" {
+		t.Errorf("Unexpected hangup cause: %+v", span.Attributes)
+	}
+
+	names := []string{"CHANNEL_CREATE", "CHANNEL_ANSWER", "CHANNEL_HANGUP", "CHANNEL_HANGUP_COMPLETE"}
+	if len(span.Events) != len(names) {
+		t.Errorf("Expected %d events, got %+v", len(names), span.Events)
+		return
+	}
+
+	for idx, name := range names {
+		if span.Events[idx].Name != name {
+			t.Errorf("Expected event (%d) %s, got %s", idx, name, span.Events[idx].Name)
+		}
+	}
+
+	if span.Events[1].Attributes[AttrChannelState] != "CS_EXECUTE" {
+		t.Errorf("Expected channel state attribute: %+v", span.Events[1])
+	}
+}
+
+func TestCallTracerEnd(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	calls := NewCallTracer(tracer)
+
+	calls.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: call-1"))
+	calls.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: call-2"))
+	calls.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: call-3"))
+
+	// CHANNEL_HANGUP_COMPLETE of call-1 was lost
+	calls.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_DESTROY", "Unique-ID: call-1"))
+	if calls.Active() != 2 || len(tracer.Spans()) != 1 {
+		t.Errorf("Expected CHANNEL_DESTROY to end the span, got %d active", calls.Active())
+	}
+
+	ended := calls.EndAll()
+	if ended != 2 || calls.Active() != 0 || len(tracer.Spans()) != 3 {
+		t.Errorf("Expected EndAll to end 2 spans, got %d (active: %d)", ended, calls.Active())
+	}
+}
+
+func TestTraceCommandsReplyCut(t *testing.T) {
+	// A rune of 2 bytes is split by the cut
+	reply := strings.Repeat("a", maxReplyAttribute-1) + "é"
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeAPIResponse(reply)
+	})
+
+	socket := connectFakeServer(t, server)
+	tracer := NewInMemoryTracer()
+	socket.SetTracer(tracer)
+
+	socket.API("status", "")
+
+	spans := tracer.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected a single span, got %d", len(spans))
+	}
+
+	cut, _ := spans[0].Attributes[AttrReply].(string)
+	if !utf8.ValidString(cut) || cut != reply[:maxReplyAttribute-1] {
+		t.Errorf("Unexpected reply attribute: %q", cut)
+	}
+}
+
+func TestSocketSetTracerWhileUsed(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeAPIResponse("+OK")
+	})
+	socket := connectFakeServer(t, server)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			socket.API("status", "")
+		}
+	}()
+
+	socket.SetTracer(NewInMemoryTracer())
+	<-done
+	socket.SetTracer(nil)
+}
+
+func TestInMemoryTracerParent(t *testing.T) {
+	tracer := NewInMemoryTracer()
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	parent.End()
+	parent.End()
+
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Errorf("Expected 2 spans, got %d", len(spans))
+		return
+	}
+
+	if spans[0].Name != "child" || spans[0].ParentID != spans[1].ID {
+		t.Errorf("Unexpected parent: %+v", spans)
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		return fakeAPIResponse("+OK")
+	})
+
+	socket := connectFakeServer(t, server)
+	tracer := NewInMemoryTracer()
+	socket.SetTracer(tracer)
+	calls := NewCallTracer(tracer)
+
+	ctx, parent := tracer.Start(context.Background(), "request")
+	socket.APIContext(ctx, "status", "")
+	socket.BgAPIContext(ctx, "status", "")
+	socket.API("status", "")
+
+	err := calls.HandleEventContext(ctx, fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: call-1"))
+	if err != nil {
+		t.Fatalf("Unable to handle event: %s", err)
+	}
+	calls.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_HANGUP_COMPLETE", "Unique-ID: call-1"))
+	parent.End()
+
+	spans := tracer.Spans()
+	if len(spans) != 5 {
+		t.Fatalf("Expected 5 spans, got %d: %+v", len(spans), spans)
+	}
+
+	request := spans[4].ID
+	parents := []uint64{request, request, 0, request}
+	for idx, parentID := range parents {
+		if spans[idx].ParentID != parentID {
+			t.Errorf("Unexpected parent of %s (%d): %d", spans[idx].Name, idx, spans[idx].ParentID)
+		}
+	}
+}
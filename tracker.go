@@ -0,0 +1,541 @@
+package esl
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Current file contains a tracker for live channels, that is kept up to date
+// from CHANNEL_* events.
+
+// TrackerEvents are the events that the Tracker subscribes to
+var TrackerEvents = []string{
+	"CHANNEL_CREATE",
+	"CHANNEL_PROGRESS",
+	"CHANNEL_PROGRESS_MEDIA",
+	"CHANNEL_ANSWER",
+	"CHANNEL_BRIDGE",
+	"CHANNEL_UNBRIDGE",
+	"CHANNEL_HOLD",
+	"CHANNEL_UNHOLD",
+	"CHANNEL_STATE",
+	"CHANNEL_CALLSTATE",
+	"CHANNEL_EXECUTE_COMPLETE",
+	"CHANNEL_HANGUP",
+	"CHANNEL_HANGUP_COMPLETE",
+	"CHANNEL_DESTROY",
+}
+
+// ChannelChangeType is the type of change that was made to a channel
+type ChannelChangeType int
+
+// Types of channel changes
+const (
+	ChannelAdded ChannelChangeType = iota
+	ChannelUpdated
+	ChannelRemoved
+)
+
+// variablePrefix is the prefix of channel variables headers
+const variablePrefix = "variable_"
+
+// Channel holds the state of a live channel
+type Channel struct {
+	UUID              string
+	Name              string
+	Direction         string
+	State             string
+	CallState         string
+	CallerIDName      string
+	CallerIDNumber    string
+	DestinationNumber string
+	CalleeIDName      string
+	CalleeIDNumber    string
+	BridgedUUID       string
+	HangupCause       string
+	Variables         map[string]string
+
+	CreatedAt  time.Time
+	AnsweredAt time.Time
+	BridgedAt  time.Time
+	HungupAt   time.Time
+	UpdatedAt  time.Time
+}
+
+// copy returns a deep copy of the channel
+func (c *Channel) copy() Channel {
+	copied := *c
+	copied.Variables = make(map[string]string, len(c.Variables))
+	for key, value := range c.Variables {
+		copied.Variables[key] = value
+	}
+	return copied
+}
+
+// merge updates the channel from a snapshot of "show calls", the fields that
+// the snapshot does not hold are kept
+func (c *Channel) merge(snapshot *Channel) {
+	fields := []struct {
+		value    *string
+		snapshot string
+	}{
+		{&c.Name, snapshot.Name},
+		{&c.Direction, snapshot.Direction},
+		{&c.State, snapshot.State},
+		{&c.CallState, snapshot.CallState},
+		{&c.CallerIDName, snapshot.CallerIDName},
+		{&c.CallerIDNumber, snapshot.CallerIDNumber},
+		{&c.DestinationNumber, snapshot.DestinationNumber},
+		{&c.CalleeIDName, snapshot.CalleeIDName},
+		{&c.CalleeIDNumber, snapshot.CalleeIDNumber},
+	}
+
+	for _, field := range fields {
+		if field.snapshot != "" {
+			*field.value = field.snapshot
+		}
+	}
+
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = snapshot.CreatedAt
+	}
+
+	// The snapshot knows if the channel is still bridged
+	c.BridgedUUID = snapshot.BridgedUUID
+	c.UpdatedAt = snapshot.UpdatedAt
+}
+
+// ChannelChange is sent to the change stream on every change of a channel
+type ChannelChange struct {
+	Type ChannelChangeType
+	// Event is the name of the event that made the change, or empty on
+	// bootstrap
+	Event   string
+	Channel Channel
+}
+
+// Tracker maintains an in memory model of live channels.
+//
+// Start subscribes to the events and bootstraps the channels from
+// "show calls as json". Start does not reconnect: when a connection is lost
+// it returns, and it must be called again with the new connections, so the
+// tracker will sync again. Attach does it on its own, when a reconnect of a
+// Client lost events.
+type Tracker struct {
+	lock        sync.RWMutex
+	channels    map[string]*Channel
+	subscribers map[chan ChannelChange]struct{}
+}
+
+// NewTracker creates a new empty Tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		channels:    make(map[string]*Channel),
+		subscribers: make(map[chan ChannelChange]struct{}),
+	}
+}
+
+// Start subscribes events socket to the channel events, bootstraps the
+// channels using the commands socket, and reads the events until events
+// socket is closed or failed.
+//
+// Both sockets must be logged in, they can be the same socket. It returns
+// when events socket fails, without reconnecting.
+func (t *Tracker) Start(commands, events *Socket) error {
+	msg, err := events.Events(EOTPlain, TrackerEvents...)
+	if err != nil {
+		return err
+	}
+	if msg.HasError() {
+		return msg.Error()
+	}
+
+	err = t.Bootstrap(commands)
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg, err := events.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg.ContentType() {
+		case ECTEventPlain, ECTEventJSON, ECTEventXML:
+			t.HandleEvent(msg)
+		case ECTDisconnectNotice:
+			return ErrDisconnected
+		}
+	}
+}
+
+// Attach keeps the tracker up to date from the events of a client. It
+// subscribes the client to ALL the events (other events are ignored by the
+// tracker), so the client detects lost events (see Client.OnGap), and
+// bootstraps the channels.
+//
+// The bootstraps are queued with the events, so events that arrived before a
+// bootstrap are handled before it, and the ones that arrived after it are
+// handled after it. When events were lost, including during a reconnect or
+// when the server was restarted, the channels are bootstrapped again before
+// the event that revealed it is handled. The queue of the events blocks when
+// it is full (see QueueBlock), since a dropped event would leave a stale
+// channel.
+// It returns a function that detaches the tracker from the client.
+func (t *Tracker) Attach(client *Client) (func(), error) {
+	// bootstraps holds the results of the queued bootstraps by their marker
+	var bootstraps sync.Map
+
+	queue := client.config.EventQueue
+	queue.Policy = QueueBlock
+	subscription, err := client.HandleQueue(AllEvents, func(event *Message) {
+		result, found := bootstraps.LoadAndDelete(event)
+		if found {
+			result.(chan error) <- t.Bootstrap(client.Commands())
+			return
+		}
+		t.HandleEvent(event)
+	}, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	bootstrap := func() <-chan error {
+		marker := &Message{}
+		result := make(chan error, 1)
+		bootstraps.Store(marker, result)
+		subscription.push(marker)
+		return result
+	}
+
+	removeGap := client.OnGap(func(gap SequenceGap) {
+		if !gap.Duplicate {
+			bootstrap()
+		}
+	})
+
+	detach := func() {
+		subscription.Close()
+		removeGap()
+	}
+
+	err = client.Subscribe(AllEvents)
+	if err == nil {
+		select {
+		case err = <-bootstrap():
+		case <-subscription.Done():
+			err = ErrClosed
+		}
+	}
+	if err != nil {
+		detach()
+		return nil, err
+	}
+
+	return detach, nil
+}
+
+// Bootstrap syncs the known channels with the result of "show calls as json".
+// Channels that are no longer live are removed, new channels are added, and
+// known channels are updated, while keeping what only the events carry (such
+// as the variables and the answer time).
+func (t *Tracker) Bootstrap(socket *Socket) error {
+	body, err := socket.apiBody("show", "calls as json")
+	if err != nil {
+		return err
+	}
+
+	channels, err := parseShowCalls([]byte(body))
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var changes []ChannelChange
+	for uuid, channel := range t.channels {
+		if _, found := channels[uuid]; !found {
+			delete(t.channels, uuid)
+			changes = append(changes, ChannelChange{Type: ChannelRemoved, Channel: channel.copy()})
+		}
+	}
+
+	for uuid, snapshot := range channels {
+		channel, found := t.channels[uuid]
+		if !found {
+			t.channels[uuid] = snapshot
+			changes = append(changes, ChannelChange{Type: ChannelAdded, Channel: snapshot.copy()})
+			continue
+		}
+
+		channel.merge(snapshot)
+		changes = append(changes, ChannelChange{Type: ChannelUpdated, Channel: channel.copy()})
+	}
+
+	for _, change := range changes {
+		t.notify(change)
+	}
+
+	return nil
+}
+
+// HandleEvent updates the channels based on an event, non channel events are
+// ignored.
+func (t *Tracker) HandleEvent(msg *Message) error {
+	headers, err := eventHeaders(msg)
+	if err != nil {
+		return err
+	}
+
+	name := headers.GetString("Event-Name")
+	uuid := headers.GetString("Unique-ID")
+	if uuid == "" || !strings.HasPrefix(name, "CHANNEL_") {
+		return nil
+	}
+
+	at := eventTime(headers)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	channel, found := t.channels[uuid]
+	if name == "CHANNEL_DESTROY" {
+		if found {
+			delete(t.channels, uuid)
+			channel.UpdatedAt = at
+			t.notify(ChannelChange{Type: ChannelRemoved, Event: name, Channel: channel.copy()})
+		}
+		return nil
+	}
+
+	changeType := ChannelUpdated
+	if !found {
+		changeType = ChannelAdded
+		channel = &Channel{
+			UUID:      uuid,
+			Variables: make(map[string]string),
+		}
+		t.channels[uuid] = channel
+	}
+
+	updateChannel(channel, headers)
+	channel.UpdatedAt = at
+
+	switch name {
+	case "CHANNEL_CREATE":
+		channel.CreatedAt = at
+	case "CHANNEL_ANSWER":
+		channel.AnsweredAt = at
+	case "CHANNEL_BRIDGE":
+		channel.BridgedAt = at
+		channel.BridgedUUID = bridgedPeer(uuid, headers)
+		if peer, ok := t.channels[channel.BridgedUUID]; ok {
+			peer.BridgedUUID = uuid
+			peer.BridgedAt = at
+			peer.UpdatedAt = at
+			t.notify(ChannelChange{Type: ChannelUpdated, Event: name, Channel: peer.copy()})
+		}
+	case "CHANNEL_UNBRIDGE":
+		if peer, ok := t.channels[channel.BridgedUUID]; ok && peer.BridgedUUID == uuid {
+			peer.BridgedUUID = ""
+			peer.UpdatedAt = at
+			t.notify(ChannelChange{Type: ChannelUpdated, Event: name, Channel: peer.copy()})
+		}
+		channel.BridgedUUID = ""
+	case "CHANNEL_HANGUP", "CHANNEL_HANGUP_COMPLETE":
+		if channel.HungupAt.IsZero() {
+			channel.HungupAt = at
+		}
+		if cause := headers.GetString("Hangup-Cause"); cause != "" {
+			channel.HangupCause = cause
+		}
+	}
+
+	t.notify(ChannelChange{Type: changeType, Event: name, Channel: channel.copy()})
+
+	return nil
+}
+
+// Get returns a copy of a channel by its uuid
+func (t *Tracker) Get(uuid string) (Channel, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	channel, found := t.channels[uuid]
+	if !found {
+		return Channel{}, false
+	}
+	return channel.copy(), true
+}
+
+// List returns a copy of all the live channels
+func (t *Tracker) List() []Channel {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	channels := make([]Channel, 0, len(t.channels))
+	for _, channel := range t.channels {
+		channels = append(channels, channel.copy())
+	}
+	return channels
+}
+
+// Len returns the number of live channels
+func (t *Tracker) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return len(t.channels)
+}
+
+// Changes returns a stream of changes, with a buffer of the given size.
+// When the buffer is full, changes are dropped for the subscriber, so it
+// should be read quickly. Call the returned function in order to stop the
+// stream.
+func (t *Tracker) Changes(buffer int) (<-chan ChannelChange, func()) {
+	ch := make(chan ChannelChange, buffer)
+
+	t.lock.Lock()
+	t.subscribers[ch] = struct{}{}
+	t.lock.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+
+			delete(t.subscribers, ch)
+			close(ch)
+		})
+	}
+
+	return ch, cancel
+}
+
+// notify must be called while the lock is held
+func (t *Tracker) notify(change ChannelChange) {
+	for ch := range t.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+func updateChannel(channel *Channel, headers Headers) {
+	fields := []struct {
+		header string
+		value  *string
+	}{
+		{"Channel-Name", &channel.Name},
+		{"Call-Direction", &channel.Direction},
+		{"Channel-State", &channel.State},
+		{"Channel-Call-State", &channel.CallState},
+		{"Caller-Caller-ID-Name", &channel.CallerIDName},
+		{"Caller-Caller-ID-Number", &channel.CallerIDNumber},
+		{"Caller-Destination-Number", &channel.DestinationNumber},
+		{"Caller-Callee-ID-Name", &channel.CalleeIDName},
+		{"Caller-Callee-ID-Number", &channel.CalleeIDNumber},
+	}
+
+	for _, field := range fields {
+		if value := headers.GetString(field.header); value != "" {
+			*field.value = value
+		}
+	}
+
+	for _, key := range headers.Keys() {
+		if strings.HasPrefix(key, variablePrefix) {
+			channel.Variables[strings.TrimPrefix(key, variablePrefix)] = headers.GetString(key)
+		}
+	}
+}
+
+// bridgedPeer returns the uuid of the other leg of a bridge
+func bridgedPeer(uuid string, headers Headers) string {
+	a := headers.GetString("Bridge-A-Unique-ID")
+	b := headers.GetString("Bridge-B-Unique-ID")
+
+	switch uuid {
+	case a:
+		return b
+	case b:
+		return a
+	}
+	return headers.GetString("Other-Leg-Unique-ID")
+}
+
+// eventTime returns the time of an event (Event-Date-Timestamp is in
+// microseconds), or the current time if not available
+func eventTime(headers Headers) time.Time {
+	timestamp := headers.GetInt("Event-Date-Timestamp")
+	if timestamp <= 0 {
+		return time.Now()
+	}
+	return time.Unix(0, timestamp*int64(time.Microsecond))
+}
+
+// parseShowCalls parse the result of "show calls as json". A row holds a
+// channel, and the channel that is bridged to it (if any) in the columns with
+// the "b_" prefix.
+func parseShowCalls(body []byte) (map[string]*Channel, error) {
+	var result struct {
+		RowCount int                 `json:"row_count"`
+		Rows     []map[string]string `json:"rows"`
+	}
+
+	err := json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	channels := make(map[string]*Channel, len(result.Rows))
+	for _, row := range result.Rows {
+		a := showCallsChannel(row, "", now)
+		if a == nil {
+			continue
+		}
+		channels[a.UUID] = a
+
+		b := showCallsChannel(row, "b_", now)
+		if b == nil {
+			continue
+		}
+		a.BridgedUUID = b.UUID
+		b.BridgedUUID = a.UUID
+		channels[b.UUID] = b
+	}
+
+	return channels, nil
+}
+
+// showCallsChannel returns the channel of a "show calls" row, from the columns
+// with the given prefix, or nil if there is no such channel
+func showCallsChannel(row map[string]string, prefix string, now time.Time) *Channel {
+	uuid := row[prefix+"uuid"]
+	if uuid == "" {
+		return nil
+	}
+
+	return &Channel{
+		UUID:              uuid,
+		Name:              row[prefix+"name"],
+		Direction:         row[prefix+"direction"],
+		State:             row[prefix+"state"],
+		CallState:         row[prefix+"callstate"],
+		CallerIDName:      row[prefix+"cid_name"],
+		CallerIDNumber:    row[prefix+"cid_num"],
+		DestinationNumber: row[prefix+"dest"],
+		CalleeIDName:      row[prefix+"callee_name"],
+		CalleeIDNumber:    row[prefix+"callee_num"],
+		Variables:         make(map[string]string),
+		CreatedAt:         parseEpoch(row[prefix+"created_epoch"]),
+		UpdatedAt:         now,
+	}
+}
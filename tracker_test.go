@@ -0,0 +1,321 @@
+package esl
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const showCallsFixture = `{"row_count":1,"rows":[{` +
+	`"uuid":"a-leg","direction":"inbound","created_epoch":"1600000000","name":"sofia/internal/1000@10.0.0.5","state":"CS_EXECUTE","cid_name":"Alice","cid_num":"1000","dest":"1001","callstate":"ACTIVE","callee_name":"","callee_num":"","call_uuid":"a-leg",` +
+	`"b_uuid":"b-leg","b_direction":"outbound","b_created_epoch":"1600000001","b_name":"sofia/internal/1001@10.0.0.6","b_state":"CS_EXCHANGE_MEDIA","b_cid_name":"Alice","b_cid_num":"1000","b_dest":"1001","b_callstate":"ACTIVE","b_callee_name":"","b_callee_num":""}]}`
+
+func TestTrackerHandleEvent(t *testing.T) {
+	tracker := NewTracker()
+	changes, cancel := tracker.Changes(10)
+	defer cancel()
+
+	events := []*Message{
+		fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: a", "Caller-Caller-ID-Number: 1000",
+			"Event-Date-Timestamp: 1600000000000000", "variable_sip_user_agent: Zoiper%205"),
+		fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: b", "Call-Direction: outbound"),
+		fakeEventMessage(t, "Event-Name: CHANNEL_ANSWER", "Unique-ID: a", "Channel-Call-State: ACTIVE"),
+		fakeEventMessage(t, "Event-Name: CHANNEL_BRIDGE", "Unique-ID: a", "Bridge-A-Unique-ID: a", "Bridge-B-Unique-ID: b"),
+		fakeEventMessage(t, "Event-Name: HEARTBEAT"),
+	}
+
+	for idx, event := range events {
+		err := tracker.HandleEvent(event)
+		if err != nil {
+			t.Errorf("Unable to handle event (%d): %s", idx, err)
+		}
+	}
+
+	if tracker.Len() != 2 {
+		t.Errorf("Expected 2 channels, got %d", tracker.Len())
+	}
+
+	a, found := tracker.Get("a")
+	if !found {
+		t.Errorf("Channel a was not found")
+		return
+	}
+
+	if a.CallerIDNumber != "1000" || a.CallState != "ACTIVE" || a.BridgedUUID != "b" {
+		t.Errorf("Unexpected channel: %+v", a)
+	}
+
+	if a.Variables["sip_user_agent"] != "Zoiper 5" {
+		t.Errorf("Unexpected variables: %v", a.Variables)
+	}
+
+	if a.CreatedAt.Unix() != 1600000000 || a.AnsweredAt.IsZero() || a.BridgedAt.IsZero() {
+		t.Errorf("Unexpected timestamps: %+v", a)
+	}
+
+	b, _ := tracker.Get("b")
+	if b.BridgedUUID != "a" || b.Direction != "outbound" {
+		t.Errorf("Unexpected peer: %+v", b)
+	}
+
+	// Changes to copies must not change the tracker
+	a.Variables["sip_user_agent"] = "changed"
+	a, _ = tracker.Get("a")
+	if a.Variables["sip_user_agent"] != "Zoiper 5" {
+		t.Errorf("Tracker was changed by a copy")
+	}
+
+	tracker.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_HANGUP_COMPLETE", "Unique-ID: a", "Hangup-Cause: NORMAL_CLEARING"))
+	a, _ = tracker.Get("a")
+	if a.HangupCause != "NORMAL_CLEARING" || a.HungupAt.IsZero() {
+		t.Errorf("Unexpected hangup: %+v", a)
+	}
+
+	tracker.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_DESTROY", "Unique-ID: a"))
+	if _, found = tracker.Get("a"); found {
+		t.Errorf("Channel a should be removed")
+	}
+
+	var types []ChannelChangeType
+	for len(changes) > 0 {
+		types = append(types, (<-changes).Type)
+	}
+
+	// create, create, answer, bridge (peer and channel), hangup, destroy
+	expected := []ChannelChangeType{ChannelAdded, ChannelAdded, ChannelUpdated,
+		ChannelUpdated, ChannelUpdated, ChannelUpdated, ChannelRemoved}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Errorf("Expected changes %v, got %v", expected, types)
+	}
+}
+
+func TestTrackerStart(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		switch {
+		case strings.HasPrefix(cmd, "event plain CHANNEL_CREATE"):
+			return fakeCommandReply("+OK event listener enabled plain") +
+				fakeEvent("Event-Name: CHANNEL_CREATE", "Unique-ID: c-leg") +
+				fakeEvent("Event-Name: CHANNEL_DESTROY", "Unique-ID: b-leg")
+		case cmd == "api show calls as json":
+			return fakeAPIResponse(showCallsFixture)
+		}
+		return fakeCommandReply("-ERR command not found")
+	})
+
+	commands := connectFakeServer(t, server)
+	events := connectFakeServer(t, server)
+
+	tracker := NewTracker()
+	changes, cancel := tracker.Changes(10)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- tracker.Start(commands, events)
+	}()
+
+	// a-leg, b-leg from bootstrap, c-leg created and b-leg destroyed
+	for i := 0; i < 4; i++ {
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Errorf("Timeout waiting for change %d", i)
+			return
+		}
+	}
+
+	events.Close()
+	<-done
+
+	if tracker.Len() != 2 {
+		t.Errorf("Expected 2 channels, got %d: %+v", tracker.Len(), tracker.List())
+	}
+
+	a, found := tracker.Get("a-leg")
+	if !found || a.CallerIDName != "Alice" || a.BridgedUUID != "b-leg" || a.CreatedAt.Unix() != 1600000000 {
+		t.Errorf("Unexpected bootstrapped channel: %+v", a)
+	}
+
+	if _, found = tracker.Get("c-leg"); !found {
+		t.Errorf("c-leg was not created")
+	}
+}
+
+func TestTrackerAttach(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "api show calls as json" {
+			return fakeAPIResponse(showCallsFixture)
+		}
+		return fakeCommandReply("+OK")
+	})
+
+	cfg := NewConfig(server.Addr(), fakePassword,
+		WithBackoff(BackoffConfig{InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond}),
+	)
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	tracker := NewTracker()
+	detach, err := tracker.Attach(client)
+	if err != nil {
+		t.Fatalf("Unable to attach: %s", err)
+	}
+	defer detach()
+
+	if tracker.Len() != 2 {
+		t.Errorf("Expected 2 bootstrapped channels, got %d", tracker.Len())
+	}
+
+	count := func(command string) int {
+		count := 0
+		for _, cmd := range server.Commands() {
+			if cmd == command {
+				count++
+			}
+		}
+		return count
+	}
+	bootstraps := func() int { return count("api show calls as json") }
+
+	if count("event plain ALL") != 1 {
+		t.Fatalf("The tracker did not subscribe to ALL: %v", server.Commands())
+	}
+
+	server.Publish(fakeEvent("Event-Name: CHANNEL_CREATE", "Unique-ID: c-leg", "Event-Sequence: 1"))
+	waitFor(t, "c-leg", func() bool { return tracker.Len() == 3 })
+
+	// Events 2-3 were lost, the channels are bootstrapped again
+	server.Publish(fakeEvent("Event-Name: HEARTBEAT", "Event-Sequence: 4"))
+	waitFor(t, "resync", func() bool { return bootstraps() == 2 && tracker.Len() == 2 })
+
+	// A reconnect that did not lose events does not resync
+	reconnected := make(chan struct{}, 1)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+	server.Drop()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not reconnect")
+	}
+	waitFor(t, "subscription", func() bool { return count("event plain ALL") == 2 })
+
+	server.Publish(fakeEvent("Event-Name: CHANNEL_CREATE", "Unique-ID: d-leg", "Event-Sequence: 5"))
+	waitFor(t, "d-leg", func() bool { return tracker.Len() == 3 })
+	if bootstraps() != 2 {
+		t.Errorf("Expected no resync, got %d bootstraps", bootstraps())
+	}
+
+	// Events that were lost during a reconnect cause a single resync
+	server.Drop()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not reconnect")
+	}
+	waitFor(t, "subscription", func() bool { return count("event plain ALL") == 3 })
+
+	server.Publish(fakeEvent("Event-Name: HEARTBEAT", "Event-Sequence: 9"))
+	waitFor(t, "resync", func() bool { return bootstraps() == 3 && tracker.Len() == 2 })
+
+	time.Sleep(50 * time.Millisecond)
+	if bootstraps() != 3 {
+		t.Errorf("Expected a single resync, got %d bootstraps", bootstraps())
+	}
+}
+
+func TestTrackerAttachStaleEvents(t *testing.T) {
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "api show calls as json" {
+			return fakeAPIResponse(`{"row_count":0}`)
+		}
+		return fakeCommandReply("+OK")
+	})
+
+	client, err := NewClient(NewConfig(server.Addr(), fakePassword))
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	defer client.Close()
+
+	tracker := NewTracker()
+	detach, err := tracker.Attach(client)
+	if err != nil {
+		t.Fatalf("Unable to attach: %s", err)
+	}
+	defer detach()
+
+	// The create of a-leg is still queued when the gap is found, since the
+	// tracker is busy with event 1, and its destroy (event 3) was lost
+	tracker.lock.Lock()
+	server.Publish(fakeEvent("Event-Name: CHANNEL_STATE", "Unique-ID: z-leg", "Event-Sequence: 1"))
+	server.Publish(fakeEvent("Event-Name: CHANNEL_CREATE", "Unique-ID: a-leg", "Event-Sequence: 2"))
+	server.Publish(fakeEvent("Event-Name: CHANNEL_CREATE", "Unique-ID: b-leg", "Event-Sequence: 4"))
+	waitFor(t, "gap", func() bool { return client.SequenceStats().Gaps == 1 })
+	tracker.lock.Unlock()
+
+	waitFor(t, "b-leg", func() bool {
+		_, found := tracker.Get("b-leg")
+		return found
+	})
+	if _, found := tracker.Get("a-leg"); found {
+		t.Errorf("A stale event was handled after the bootstrap: %+v", tracker.List())
+	}
+}
+
+func TestTrackerBootstrapMerge(t *testing.T) {
+	unbridged := `{"row_count":1,"rows":[{"uuid":"a-leg","direction":"inbound","created_epoch":"1600000000",` +
+		`"state":"CS_EXECUTE","cid_num":"1000","callstate":"HELD","b_uuid":""}]}`
+
+	var calls atomic.Value
+	calls.Store(showCallsFixture)
+	server := newFakeServer(t, func(cmd string) string {
+		if cmd == "api show calls as json" {
+			return fakeAPIResponse(calls.Load().(string))
+		}
+		return fakeCommandReply("-ERR command not found")
+	})
+	socket := connectFakeServer(t, server)
+
+	tracker := NewTracker()
+	tracker.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_CREATE", "Unique-ID: gone"))
+	tracker.HandleEvent(fakeEventMessage(t, "Event-Name: CHANNEL_ANSWER", "Unique-ID: a-leg", "Event-Date-Timestamp: 1600000002000000",
+		"variable_sip_user_agent: Zoiper%205"))
+
+	err := tracker.Bootstrap(socket)
+	if err != nil {
+		t.Fatalf("Unable to bootstrap: %s", err)
+	}
+
+	if _, found := tracker.Get("gone"); found || tracker.Len() != 2 {
+		t.Errorf("Unexpected channels: %+v", tracker.List())
+	}
+
+	a, _ := tracker.Get("a-leg")
+	if a.BridgedUUID != "b-leg" || a.CallerIDName != "Alice" || a.CreatedAt.Unix() != 1600000000 {
+		t.Errorf("Channel was not updated: %+v", a)
+	}
+	if a.AnsweredAt.Unix() != 1600000002 || a.Variables["sip_user_agent"] != "Zoiper 5" {
+		t.Errorf("Channel lost its event state: %+v", a)
+	}
+
+	b, _ := tracker.Get("b-leg")
+	if b.BridgedUUID != "a-leg" || b.Direction != "outbound" || b.Name != "sofia/internal/1001@10.0.0.6" {
+		t.Errorf("Unexpected b leg: %+v", b)
+	}
+
+	calls.Store(unbridged)
+	err = tracker.Bootstrap(socket)
+	if err != nil {
+		t.Fatalf("Unable to bootstrap: %s", err)
+	}
+
+	a, _ = tracker.Get("a-leg")
+	if tracker.Len() != 1 || a.BridgedUUID != "" || a.CallState != "HELD" || a.CallerIDName != "Alice" {
+		t.Errorf("Unexpected channels: %+v", tracker.List())
+	}
+}